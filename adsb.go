@@ -2,7 +2,6 @@ package adsb
 
 import (
 	"fmt"
-	"strings"
 	"time"
 
 	"github.com/skypies/geo"
@@ -10,6 +9,10 @@ import (
 
 type IcaoId string
 
+// NilCallsign stands in for a callsign that was sent, but was blank; this lets us distinguish
+// flights with a purposefully blank callsign from those we've not yet had a callsign from.
+const NilCallsign = "_._._._."
+
 // http://woodair.net/SBS/Article/Barebones42_Socket_Data.htm
 // https://github.com/MalcolmRobb/dump1090/blob/master/mode_s.c#L834
 //
@@ -51,9 +54,21 @@ type Msg struct {
 	SPI bool `json:"-"`// = 20 // (Ident)	 Flag to indicate transponder Ident has been activated.
 	IsOnGround bool `json:"-"`// = 21 //	 Flag to indicate ground squat switch is active
 
-	// These fields are present for extended basestation format messages (i.e. MLAT)
-	NumStations int64 `json:"-"`
-	//ErrorEstimate int64 `json:"-"`  // Not sure if this is a float or an int, or what it means
+	// Not in SBS; reported in ADS-B airborne velocity messages. Add to Altitude to get the
+	// aircraft's geometric (GNSS) height.
+	GeomMinusBaro int64 `json:"-"`
+
+	EHS // Embedded; extra fields from Mode S Enhanced Surveillance (Comm-B) replies
+
+	Source Source `json:",omitempty"` // What kind of system the message came from
+	Quality       // Embedded; ADS-B integrity & accuracy indicators
+
+	// These fields are present for extended basestation format messages (i.e. MLAT). Like the
+	// other non-SBS fields above, they are only in the JSON when known.
+	NumStations int64 `json:",omitempty"`      // How many receivers contributed to the MLAT solution
+	ErrorEstimate float64 `json:",omitempty"`  // Estimated error of the MLAT position, in metres; 0==unknown
+
+	cpr cprFrame // The raw position from an ADS-B airborne position frame, for CPRDecoder
 	
 	// Flags filled (and only valid) during initial SBS parsing, for fields not
 	// always present
@@ -64,24 +79,39 @@ type Msg struct {
 	hasTrack        bool
 	hasPosition     bool
 	hasVerticalRate bool
+	hasAlertSquawkChange bool
+	hasEmergency    bool
+	hasSPI          bool
+	hasOnGround     bool
+	hasGeomMinusBaro bool
 }
 
 func (m Msg)IsMLAT() bool { return m.Type == "MLAT" }
 
-func (m Msg)IsMasked() bool { return strings.HasPrefix(string(m.Icao24), "~") }
+// IsModeAC is true for Mode A/C replies; they have no Icao24 (unless a correlator has
+// found one for them), and no position.
+func (m Msg)IsModeAC() bool { return m.Source == SourceModeAC }
+
+func (m Msg)IsMasked() bool { return m.Icao24.IsMasked() }
 
-//func (m Msg)HasAltitude()     bool { return m.hasAltitude }
+func (m Msg)HasAltitude()     bool { return m.hasAltitude }
 func (m Msg)HasCallsign()     bool { return m.hasCallsign }
 func (m Msg)HasSquawk()       bool { return m.hasSquawk }
 func (m Msg)HasGroundSpeed()  bool { return m.hasGroundSpeed }
 func (m Msg)HasTrack()        bool { return m.hasTrack }
 func (m Msg)HasPosition()     bool { return m.hasPosition }
 func (m Msg)HasVerticalRate() bool { return m.hasVerticalRate }
+func (m Msg)HasAlertSquawkChange() bool { return m.hasAlertSquawkChange }
+func (m Msg)HasEmergency()    bool { return m.hasEmergency }
+func (m Msg)HasSPI()          bool { return m.hasSPI }
+func (m Msg)HasOnGround()     bool { return m.hasOnGround }
+func (m Msg)HasGeomMinusBaro() bool { return m.hasGeomMinusBaro }
 
 // We create some ADSB messages outside of this lib, and need to assert these values
-func (m Msg)SetHasGroundSpeed() { m.hasGroundSpeed = true }
-func (m Msg)SetHasTrack()       { m.hasTrack = true }
-func (m Msg)SetHasPosition()    { m.hasPosition =true }
+func (m *Msg)SetHasGroundSpeed() { m.hasGroundSpeed = true }
+func (m *Msg)SetHasTrack()       { m.hasTrack = true }
+func (m *Msg)SetHasPosition()    { m.hasPosition =true }
+func (m *Msg)SetGeomMinusBaro(ft int64) { m.GeomMinusBaro, m.hasGeomMinusBaro = ft, true }
 
 
 func (m Msg)String() string {
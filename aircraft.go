@@ -0,0 +1,25 @@
+package adsb
+
+import "fmt"
+
+// AircraftInfo holds details about an aircraft that don't come from ADS-B itself, but from
+// some database keyed on the Icao24 address (see the aircraftdb package).
+type AircraftInfo struct {
+	Registration  string // Tail number, e.g. N12345
+	TypeCode      string // ICAO aircraft type designator, e.g. B738
+	Description   string // Manufacturer & model, e.g. BOEING 737-800
+	Operator      string // Who flies it (e.g. an airline), if known
+	Owner         string // Who it is registered to
+	IsMilitary    bool
+}
+
+func (ai AircraftInfo)String() string {
+	return fmt.Sprintf("%s(%s) %q, op=%q, owner=%q", ai.Registration, ai.TypeCode, ai.Description,
+		ai.Operator, ai.Owner)
+}
+
+// An Enricher adds extra data to composite messages (e.g. the Aircraft field). It should
+// leave the message alone if it has nothing to add.
+type Enricher interface {
+	Enrich(cm *CompositeMsg)
+}
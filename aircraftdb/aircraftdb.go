@@ -0,0 +1,192 @@
+/* Package aircraftdb looks up details about aircraft (registration,
+type, operator, owner) by their Icao24 address, from a local file.
+
+It understands the FAA registry's MASTER.txt, and tar1090-db's CSV &
+JSON formats (optionally gzipped). The whole file is loaded into
+memory; if it changes on disk, Watch will reload it. A DB implements
+adsb.Enricher, so it can be plugged into a msgbuffer.
+
+Sample usage:
+
+    db,err := aircraftdb.Open("aircraft.csv.gz", aircraftdb.FormatAuto)
+    if err != nil { ... }
+    go db.Watch(time.Minute, nil)
+
+    mb := msgbuffer.NewMsgBuffer()
+    mb.Enricher = db
+
+*/
+package aircraftdb
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/skypies/adsb"
+)
+
+// {{{ DB{}
+
+// DB is an in-memory index of aircraft. It is safe for concurrent use.
+type DB struct {
+	Path           string
+	Format         Format
+	UseAlgorithmic bool // If an aircraft isn't in the file, try adsb.IcaoId.Registration
+
+	aircraft       map[adsb.IcaoId]*adsb.AircraftInfo
+	modTime        time.Time // Of the file, when it was last loaded
+	loadedAt       time.Time
+	mu             sync.RWMutex
+}
+
+// New returns an empty DB, which can be populated with Set.
+func New() *DB {
+	return &DB{
+		UseAlgorithmic: true,
+		aircraft:       map[adsb.IcaoId]*adsb.AircraftInfo{},
+	}
+}
+
+// Open loads the file into a new DB.
+func Open(path string, format Format) (*DB, error) {
+	db := New()
+	db.Path, db.Format = path, format
+	if err := db.Reload(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *DB)String() string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return fmt.Sprintf("aircraftdb.DB{%s(%s), %d aircraft, loaded %s}", db.Path, db.Format,
+		len(db.aircraft), db.loadedAt)
+}
+
+// }}}
+
+// {{{ DB.Reload
+
+// Reload reads the file from scratch, and swaps in the new data. If there is an error, the
+// old data is kept.
+func (db *DB)Reload() error {
+	f,err := os.Open(db.Path)
+	if err != nil { return err }
+	defer f.Close()
+
+	info,err := f.Stat()
+	if err != nil { return err }
+
+	aircraft,err := Parse(f, db.Format)
+	if err != nil { return fmt.Errorf("%s: %v", db.Path, err) }
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.aircraft = aircraft
+	db.modTime = info.ModTime()
+	db.loadedAt = time.Now()
+	return nil
+}
+
+// }}}
+// {{{ DB.MaybeReload
+
+// MaybeReload reloads the file if its modification time has changed since it was last
+// loaded. It returns true if it reloaded.
+func (db *DB)MaybeReload() (bool, error) {
+	info,err := os.Stat(db.Path)
+	if err != nil { return false, err }
+
+	db.mu.RLock()
+	unchanged := info.ModTime().Equal(db.modTime)
+	db.mu.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+	return true, db.Reload()
+}
+
+// }}}
+// {{{ DB.Watch
+
+// Watch checks the file every interval, and reloads it if it has changed. It returns when
+// the done channel is closed (or never, if it is nil). Errors are logged, and the old data
+// kept.
+func (db *DB)Watch(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if _,err := db.MaybeReload(); err != nil {
+				log.Printf("aircraftdb: reload failed: %v\n", err)
+			}
+		}
+	}
+}
+
+// }}}
+
+// {{{ DB.Set
+
+// Set adds (or replaces) an aircraft's details; it will be lost if the file is reloaded.
+func (db *DB)Set(id adsb.IcaoId, info *adsb.AircraftInfo) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.aircraft[id] = info
+}
+
+// }}}
+// {{{ DB.Len
+
+func (db *DB)Len() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.aircraft)
+}
+
+// }}}
+// {{{ DB.Lookup
+
+// Lookup returns the aircraft's details, or nil if it isn't known.
+func (db *DB)Lookup(id adsb.IcaoId) *adsb.AircraftInfo {
+	db.mu.RLock()
+	info := db.aircraft[id]
+	db.mu.RUnlock()
+
+	if info == nil && db.UseAlgorithmic {
+		if reg := id.Registration(); reg != "" {
+			info = &adsb.AircraftInfo{Registration:reg, IsMilitary:id.IsMilitary()}
+		}
+	}
+
+	return info
+}
+
+// }}}
+// {{{ DB.Enrich
+
+// Enrich sets the message's Aircraft field, if the aircraft is known.
+func (db *DB)Enrich(cm *adsb.CompositeMsg) {
+	if info := db.Lookup(cm.Icao24); info != nil {
+		cm.Aircraft = info
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
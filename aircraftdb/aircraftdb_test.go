@@ -0,0 +1,145 @@
+package aircraftdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skypies/adsb"
+)
+
+var (
+	faaMaster = "\ufeffN-NUMBER,SERIAL NUMBER,MFR MDL CODE,ENG MFR MDL,YEAR MFR,TYPE REGISTRANT,NAME,STREET,STREET2,CITY,STATE,ZIP CODE,REGION,COUNTY,COUNTRY,LAST ACTION DATE,CERT ISSUE DATE,CERTIFICATION,TYPE AIRCRAFT,TYPE ENGINE,STATUS CODE,MODE S CODE,FRACT OWNER,AIR WORTH DATE,OTHER NAMES(1),OTHER NAMES(2),OTHER NAMES(3),OTHER NAMES(4),OTHER NAMES(5),EXPIRATION DATE,UNIQUE ID,KIT MFR, KIT MODEL,MODE S CODE HEX,\n" +
+		"1    ,RK-140  ,4220012,52038,1992,5,FEDERAL AVIATION ADMINISTRATION   ,800 INDEPENDENCE AVE SW  ,     ,WASHINGTON   ,DC,20591    ,1,001,US,20230705,19921014,1T       ,5,2,V ,50000001,  ,19921006,     ,     ,     ,     ,     ,20301031,00000001,     ,     ,A00001    ,\n" +
+		"12345,0001    ,7102803,41514,1973,1,SMITH JOHN                        ,1 MAIN ST                ,     ,ANYTOWN      ,CA,90000    ,4,037,US,20200101,20200101,1N       ,4,1,V ,50061731,  ,19730101,     ,     ,     ,     ,     ,20270131,00012345,     ,     ,A061D9    ,\n"
+
+	tar1090CSV = `A00001;N1;B350;00;BEECH B300 KING AIR 350;;Federal Aviation Administration;
+ae1234;05-5140;C17;10;BOEING C-17A GLOBEMASTER III;;United States Air Force;
+400F01;G-EUPA;A319;00;AIRBUS A-319;2000;British Airways;
+junk;;;;;;;
+`
+
+	tar1090JSON = `{
+  "A00001": {"r":"N1", "t":"B350", "f":"00", "desc":"BEECH B300 KING AIR 350", "ownOp":"Federal Aviation Administration"},
+  "ae1234": {"r":"05-5140", "t":"C17", "f":"10"}
+}`
+)
+
+func TestParse(t *testing.T) {
+	gz := bytes.Buffer{}
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(tar1090CSV))
+	w.Close()
+
+	tests := []struct {
+		Name    string
+		Data    []byte
+		Format  Format
+		N       int
+	}{
+		{"faa",         []byte(faaMaster),   FormatFAAMaster,   2},
+		{"faa-auto",    []byte(faaMaster),   FormatAuto,        2},
+		{"csv",         []byte(tar1090CSV),  FormatTar1090CSV,  3},
+		{"csv-auto",    []byte(tar1090CSV),  FormatAuto,        3},
+		{"csv-gzipped", gz.Bytes(),          FormatAuto,        3},
+		{"json-auto",   []byte(tar1090JSON), FormatAuto,        2},
+	}
+
+	for _,test := range tests {
+		aircraft,err := Parse(bytes.NewReader(test.Data), test.Format)
+		if err != nil {
+			t.Errorf("%s: %v", test.Name, err)
+			continue
+		} else if len(aircraft) != test.N {
+			t.Errorf("%s: got %d aircraft, expected %d", test.Name, len(aircraft), test.N)
+		}
+
+		n1 := aircraft["A00001"]
+		if n1 == nil || n1.Registration != "N1" {
+			t.Errorf("%s: bad record for A00001: %v", test.Name, n1)
+		}
+		if strings.HasPrefix(test.Name, "faa") {
+			if n1.Owner != "FEDERAL AVIATION ADMINISTRATION" { t.Errorf("%s: bad owner %q", test.Name, n1.Owner) }
+		} else {
+			if n1.TypeCode != "B350" { t.Errorf("%s: bad type %q", test.Name, n1.TypeCode) }
+			if n1.IsMilitary { t.Errorf("%s: N1 is military", test.Name) }
+			if mil := aircraft["AE1234"]; mil == nil || !mil.IsMilitary {
+				t.Errorf("%s: bad record for AE1234: %v", test.Name, mil)
+			}
+		}
+	}
+
+	if _,err := Parse(strings.NewReader("N-NUMBER,NAME\n"), FormatFAAMaster); err == nil {
+		t.Errorf("FAA file without MODE S CODE HEX column did not fail")
+	}
+}
+
+func TestLookupAndEnrich(t *testing.T) {
+	db := New()
+	db.Set("400F01", &adsb.AircraftInfo{Registration:"G-EUPA", TypeCode:"A319"})
+
+	cm := adsb.CompositeMsg{Msg:adsb.Msg{Icao24:"400F01"}}
+	db.Enrich(&cm)
+	if cm.Aircraft == nil || cm.Aircraft.TypeCode != "A319" {
+		t.Errorf("not enriched: %v", cm.Aircraft)
+	}
+
+	// Not in the DB, but it has an algorithmic registration
+	cm = adsb.CompositeMsg{Msg:adsb.Msg{Icao24:"A061D9"}}
+	db.Enrich(&cm)
+	if cm.Aircraft == nil || cm.Aircraft.Registration != "N12345" {
+		t.Errorf("not enriched algorithmically: %v", cm.Aircraft)
+	}
+
+	db.UseAlgorithmic = false
+	cm = adsb.CompositeMsg{Msg:adsb.Msg{Icao24:"A061D9"}}
+	if db.Enrich(&cm); cm.Aircraft != nil {
+		t.Errorf("enriched, when it should not have been: %v", cm.Aircraft)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir,err := ioutil.TempDir("", "aircraftdb")
+	if err != nil { t.Fatal(err) }
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "aircraft.csv")
+	if err := ioutil.WriteFile(path, []byte(tar1090CSV), 0644); err != nil { t.Fatal(err) }
+
+	db,err := Open(path, FormatAuto)
+	if err != nil { t.Fatal(err) }
+	if db.Len() != 3 { t.Errorf("loaded %d aircraft, expected 3", db.Len()) }
+
+	if reloaded,err := db.MaybeReload(); reloaded || err != nil {
+		t.Errorf("reloaded unchanged file (%v)", err)
+	}
+
+	// Rewrite the file, with a different modtime
+	if err := ioutil.WriteFile(path, []byte("C00001;C-FAAA;DH8A;00;;;;\n"), 0644); err != nil { t.Fatal(err) }
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+
+	if reloaded,err := db.MaybeReload(); !reloaded || err != nil {
+		t.Errorf("did not reload changed file (%v)", err)
+	}
+	if db.Len() != 1 || db.Lookup("C00001").TypeCode != "DH8A" {
+		t.Errorf("bad data after reload: %s", db)
+	}
+
+	// A broken file should leave the old data in place
+	if err := ioutil.WriteFile(path, []byte("{ broken"), 0644); err != nil { t.Fatal(err) }
+	later = later.Add(time.Minute)
+	os.Chtimes(path, later, later)
+
+	if _,err := db.MaybeReload(); err == nil {
+		t.Errorf("no error from broken file")
+	}
+	if db.Len() != 1 {
+		t.Errorf("lost data after failed reload: %s", db)
+	}
+}
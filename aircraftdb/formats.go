@@ -0,0 +1,207 @@
+package aircraftdb
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/skypies/adsb"
+)
+
+type Format int
+const (
+	FormatAuto       Format = iota // Work it out from the contents
+	FormatFAAMaster                // The FAA registry's MASTER.txt
+	FormatTar1090CSV               // tar1090-db's aircraft.csv: icao;reg;type;flags;desc;year;ownop
+	FormatTar1090JSON              // A JSON object keyed by icao, with tar1090-db's field names
+)
+
+func (f Format)String() string {
+	switch f {
+	case FormatAuto:        return "auto"
+	case FormatFAAMaster:   return "faa-master"
+	case FormatTar1090CSV:  return "tar1090-csv"
+	case FormatTar1090JSON: return "tar1090-json"
+	default:                return "?"
+	}
+}
+
+// {{{ Parse
+
+// Parse reads aircraft records in the given format, returning them keyed by Icao24. If the
+// data is gzipped, it is decompressed first.
+func Parse(r io.Reader, format Format) (map[adsb.IcaoId]*adsb.AircraftInfo, error) {
+	br := bufio.NewReader(r)
+	if magic,_ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz,err := gzip.NewReader(br)
+		if err != nil { return nil, err }
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	if format == FormatAuto {
+		format = sniff(br)
+	}
+
+	switch format {
+	case FormatFAAMaster:   return ParseFAAMaster(br)
+	case FormatTar1090CSV:  return ParseTar1090CSV(br)
+	case FormatTar1090JSON: return ParseTar1090JSON(br)
+	default:                return nil, fmt.Errorf("aircraftdb: unknown format %d", format)
+	}
+}
+
+// sniff guesses the format from the first line of the data.
+func sniff(br *bufio.Reader) Format {
+	peek,_ := br.Peek(512)
+	peek = bytes.TrimPrefix(peek, []byte("\ufeff"))
+	peek = bytes.TrimLeft(peek, " \t\r\n")
+
+	switch {
+	case bytes.HasPrefix(peek, []byte("{")):        return FormatTar1090JSON
+	case bytes.HasPrefix(peek, []byte("N-NUMBER")): return FormatFAAMaster
+	default:                                        return FormatTar1090CSV
+	}
+}
+
+// }}}
+// {{{ ParseFAAMaster
+
+// ParseFAAMaster reads the FAA aircraft registry's MASTER.txt file, from
+// https://registry.faa.gov/database/ReleasableAircraft.zip. It has no type codes.
+func ParseFAAMaster(r io.Reader) (map[adsb.IcaoId]*adsb.AircraftInfo, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.ReuseRecord = true
+
+	header,err := cr.Read()
+	if err != nil { return nil, fmt.Errorf("aircraftdb: FAA header: %v", err) }
+	cols := map[string]int{}
+	for i,name := range header {
+		cols[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+	for _,name := range []string{"N-NUMBER", "MODE S CODE HEX", "NAME"} {
+		if _,exists := cols[name]; !exists {
+			return nil, fmt.Errorf("aircraftdb: FAA header has no '%s' column", name)
+		}
+	}
+
+	field := func(rec []string, name string) string {
+		if i,exists := cols[name]; exists && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+
+	ret := map[adsb.IcaoId]*adsb.AircraftInfo{}
+	for {
+		rec,err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("aircraftdb: FAA: %v", err)
+		}
+
+		id := adsb.IcaoId(strings.ToUpper(field(rec, "MODE S CODE HEX")))
+		if !id.IsValid() { continue }
+
+		ret[id] = &adsb.AircraftInfo{
+			Registration: "N" + field(rec, "N-NUMBER"),
+			Owner:        field(rec, "NAME"),
+			IsMilitary:   id.IsMilitary(),
+		}
+	}
+
+	return ret, nil
+}
+
+// }}}
+// {{{ ParseTar1090CSV
+
+// tar1090-db flags: the first character is '1' for military aircraft.
+func tar1090IsMilitary(flags string) bool { return strings.HasPrefix(flags, "1") }
+
+// ParseTar1090CSV reads the semicolon separated aircraft.csv from
+// https://github.com/wiedehopf/tar1090-db (branch csv).
+func ParseTar1090CSV(r io.Reader) (map[adsb.IcaoId]*adsb.AircraftInfo, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = ';'
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	ret := map[adsb.IcaoId]*adsb.AircraftInfo{}
+	for {
+		rec,err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("aircraftdb: tar1090 CSV: %v", err)
+		}
+		for len(rec) < 7 { rec = append(rec, "") }
+
+		id := adsb.IcaoId(strings.ToUpper(strings.TrimSpace(rec[0])))
+		if !id.IsValid() { continue }
+
+		ret[id] = &adsb.AircraftInfo{
+			Registration: strings.TrimSpace(rec[1]),
+			TypeCode:     strings.TrimSpace(rec[2]),
+			Description:  strings.TrimSpace(rec[4]),
+			Operator:     strings.TrimSpace(rec[6]),
+			IsMilitary:   tar1090IsMilitary(rec[3]),
+		}
+	}
+
+	return ret, nil
+}
+
+// }}}
+// {{{ ParseTar1090JSON
+
+type tar1090Record struct {
+	R      string `json:"r"`     // Registration
+	T      string `json:"t"`     // Type code
+	F      string `json:"f"`     // Flags
+	Desc   string `json:"desc"`
+	OwnOp  string `json:"ownOp"`
+}
+
+// ParseTar1090JSON reads a single JSON object, keyed by Icao24, whose values use the
+// field names from tar1090-db's JSON files.
+func ParseTar1090JSON(r io.Reader) (map[adsb.IcaoId]*adsb.AircraftInfo, error) {
+	recs := map[string]tar1090Record{}
+	if err := json.NewDecoder(r).Decode(&recs); err != nil {
+		return nil, fmt.Errorf("aircraftdb: tar1090 JSON: %v", err)
+	}
+
+	ret := map[adsb.IcaoId]*adsb.AircraftInfo{}
+	for k,rec := range recs {
+		id := adsb.IcaoId(strings.ToUpper(k))
+		if !id.IsValid() { continue }
+
+		ret[id] = &adsb.AircraftInfo{
+			Registration: rec.R,
+			TypeCode:     rec.T,
+			Description:  rec.Desc,
+			Operator:     rec.OwnOp,
+			IsMilitary:   tar1090IsMilitary(rec.F),
+		}
+	}
+
+	return ret, nil
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
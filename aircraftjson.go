@@ -0,0 +1,174 @@
+package adsb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/skypies/geo"
+)
+
+// dump1090-fa (and readsb) publish a snapshot of every aircraft they are tracking, as
+// aircraft.json. Unlike SBS-1, it says where the data came from, and includes the quality
+// indicators; so we can turn each aircraft into a Msg.
+// https://github.com/flightaware/dump1090/blob/master/README-json.md
+
+type aircraftJSON struct {
+	Now       float64             `json:"now"`
+	Aircraft  []aircraftJSONEntry `json:"aircraft"`
+}
+
+type aircraftJSONEntry struct {
+	Hex        string          `json:"hex"`
+	Type       string          `json:"type"`
+	Flight     *string         `json:"flight"`
+	AltBaro    json.RawMessage `json:"alt_baro"` // A number, or "ground"
+	AltGeom    *int64          `json:"alt_geom"`
+	GS         *float64        `json:"gs"`
+	Track      *float64        `json:"track"`
+	BaroRate   *int64          `json:"baro_rate"`
+	GeomRate   *int64          `json:"geom_rate"`
+	Squawk     *string         `json:"squawk"`
+	Emergency  *string         `json:"emergency"`
+	Lat        *float64        `json:"lat"`
+	Lon        *float64        `json:"lon"`
+	Seen       float64         `json:"seen"`
+	SeenPos    float64         `json:"seen_pos"`
+	MLAT       []string        `json:"mlat"`
+	TISB       []string        `json:"tisb"`
+
+	Version    *int64          `json:"version"`
+	NIC        *int64          `json:"nic"`
+	NACp       *int64          `json:"nac_p"`
+	NACv       *int64          `json:"nac_v"`
+	SIL        *int64          `json:"sil"`
+	GVA        *int64          `json:"gva"`
+
+	IAS        *int64          `json:"ias"`
+	TAS        *int64          `json:"tas"`
+	Mach       *float64        `json:"mach"`
+	MagHeading *float64        `json:"mag_heading"`
+	Roll       *float64        `json:"roll"`
+	NavAltMCP  *int64          `json:"nav_altitude_mcp"`
+	NavAltFMS  *int64          `json:"nav_altitude_fms"`
+	NavQNH     *float64        `json:"nav_qnh"`
+}
+
+// source maps the 'type' field onto a Source. Older versions of dump1090
+// don't have it, but do list which fields came from MLAT or TIS-B.
+func (e aircraftJSONEntry)source() Source {
+	switch {
+	case strings.HasPrefix(e.Type, "adsb_"): return SourceADSB
+	case strings.HasPrefix(e.Type, "adsr_"): return SourceADSR
+	case strings.HasPrefix(e.Type, "tisb_"): return SourceTISB
+	case e.Type == "mlat":                   return SourceMLAT
+	case e.Type == "mode_s":                 return SourceModeS
+	case e.Type == "mode_ac":                return SourceModeAC
+	}
+	for _,f := range e.MLAT {
+		if f == "lat" { return SourceMLAT }
+	}
+	for _,f := range e.TISB {
+		if f == "lat" { return SourceTISB }
+	}
+	return SourceUnknown
+}
+
+func unixFloatToTime(f float64) time.Time {
+	sec,frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac * 1e9)).UTC()
+}
+
+func (e aircraftJSONEntry)toMsg(now time.Time) Msg {
+	m := Msg{
+		Type:   "MSG",
+		Icao24: IcaoId(strings.ToUpper(e.Hex)),
+		Source: e.source(),
+	}
+	if m.Source == SourceMLAT { m.Type = "MLAT" }
+
+	seen := now.Add(-1 * time.Duration(e.Seen * float64(time.Second)))
+	m.GeneratedTimestampUTC, m.LoggedTimestampUTC = seen, now
+
+	if e.Flight != nil {
+		m.Callsign, m.hasCallsign = strings.TrimSpace(*e.Flight), true
+	}
+	if e.Squawk != nil {
+		m.Squawk, m.hasSquawk = *e.Squawk, true
+	}
+	if e.Emergency != nil {
+		m.Emergency, m.hasEmergency = *e.Emergency != "none", true
+	}
+
+	var altBaro int64
+	if len(e.AltBaro) > 0 {
+		if string(e.AltBaro) == `"ground"` {
+			m.IsOnGround, m.hasOnGround = true, true
+		} else if err := json.Unmarshal(e.AltBaro, &altBaro); err == nil {
+			m.Altitude, m.hasAltitude = altBaro, true
+			m.IsOnGround, m.hasOnGround = false, true
+			if e.AltGeom != nil {
+				m.SetGeomMinusBaro(*e.AltGeom - altBaro)
+			}
+		}
+	}
+
+	if e.GS != nil {
+		m.GroundSpeed, m.hasGroundSpeed = int64(math.Round(*e.GS)), true
+	}
+	if e.Track != nil {
+		m.Track, m.hasTrack = int64(math.Round(*e.Track)) % 360, true
+	}
+	if e.BaroRate != nil {
+		m.VerticalRate, m.hasVerticalRate = *e.BaroRate, true
+	} else if e.GeomRate != nil {
+		m.VerticalRate, m.hasVerticalRate = *e.GeomRate, true
+	}
+
+	if e.Lat != nil && e.Lon != nil {
+		m.Position, m.hasPosition = geo.Latlong{Lat:*e.Lat, Long:*e.Lon}, true
+		m.GeneratedTimestampUTC = now.Add(-1 * time.Duration(e.SeenPos * float64(time.Second)))
+		m.SubType = sbsSubTypeAirbornePosition
+		if m.IsOnGround { m.SubType = sbsSubTypeSurfacePosition }
+	}
+
+	if e.Version != nil { m.SetADSBVersion(*e.Version) }
+	if e.NIC != nil     { m.SetNIC(*e.NIC) }
+	if e.NACp != nil    { m.SetNACp(*e.NACp) }
+	if e.NACv != nil    { m.SetNACv(*e.NACv) }
+	if e.SIL != nil     { m.SetSIL(*e.SIL) }
+	if e.GVA != nil     { m.SetGVA(*e.GVA) }
+
+	if e.IAS != nil        { m.SetIndicatedAirspeed(*e.IAS) }
+	if e.TAS != nil        { m.SetTrueAirspeed(*e.TAS) }
+	if e.Mach != nil       { m.SetMach(*e.Mach) }
+	if e.MagHeading != nil { m.SetMagneticHeading(*e.MagHeading) }
+	if e.Roll != nil       { m.SetRollAngle(*e.Roll) }
+	if e.NavAltMCP != nil  { m.SetSelectedAltitude(*e.NavAltMCP) }
+	if e.NavAltFMS != nil  { m.SetFMSSelectedAltitude(*e.NavAltFMS) }
+	if e.NavQNH != nil     { m.SetBaroSetting(*e.NavQNH) }
+
+	return m
+}
+
+// ParseAircraftJSON reads an aircraft.json snapshot, and returns a Msg for each aircraft.
+// The timestamps are worked out from the snapshot's time, and how long ago the data (or
+// position) was seen. Aircraft with positions get SBS-1 subtype 3 (or 2, on the ground);
+// others get subtype 0, as there is no equivalent.
+func ParseAircraftJSON(r io.Reader) ([]Msg, error) {
+	snapshot := aircraftJSON{}
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("aircraft.json: %v", err)
+	}
+
+	now := unixFloatToTime(snapshot.Now)
+	msgs := []Msg{}
+	for _,e := range snapshot.Aircraft {
+		if !IcaoId(strings.ToUpper(e.Hex)).IsValid() { continue }
+		msgs = append(msgs, e.toMsg(now))
+	}
+	return msgs, nil
+}
@@ -0,0 +1,69 @@
+package adsb
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var aircraftJSONSample = `{ "now" : 1457634142.5,
+  "messages" : 123456,
+  "aircraft" : [
+    {"hex":"a81bd0","type":"adsb_icao","flight":"VRD961  ","alt_baro":20125,"alt_geom":20450,"gs":304.2,"track":327.9,
+     "baro_rate":-1856,"squawk":"1200","emergency":"none","lat":36.698040,"lon":-121.860070,"nic":8,"nac_p":9,
+     "nac_v":1,"sil":3,"gva":2,"version":2,"ias":280,"mach":0.612,"nav_altitude_mcp":12000,"nav_qnh":1013.6,
+     "seen_pos":1.5,"seen":0.5,"rssi":-20.1},
+    {"hex":"~2ab123","type":"tisb_other","alt_baro":3500,"lat":37.1,"lon":-122.1,"seen_pos":2.0,"seen":2.0},
+    {"hex":"a2c635","mlat":["lat","lon","track","gs"],"alt_baro":37559,"lat":36.3631,"lon":-120.3861,"seen":0},
+    {"hex":"abeef0","type":"mode_s","alt_baro":"ground","squawk":"7700","emergency":"general","seen":10},
+    {"hex":"not a hex","seen":0}
+  ]
+}`
+
+func TestParseAircraftJSON(t *testing.T) {
+	msgs,err := ParseAircraftJSON(strings.NewReader(aircraftJSONSample))
+	if err != nil { t.Fatal(err) }
+	if len(msgs) != 4 { t.Fatalf("got %d msgs, expected 4", len(msgs)) }
+
+	now := time.Date(2016, 3, 10, 18, 22, 22, 500000000, time.UTC)
+
+	m := msgs[0]
+	if m.Icao24 != "A81BD0" || m.Source != SourceADSB || m.Type != "MSG" || m.SubType != 3 {
+		t.Errorf("bad msg: %s %s", m, m.Source)
+	}
+	if !m.GeneratedTimestampUTC.Equal(now.Add(-1500 * time.Millisecond)) || !m.LoggedTimestampUTC.Equal(now) {
+		t.Errorf("bad timestamps: %s, %s", m.GeneratedTimestampUTC, m.LoggedTimestampUTC)
+	}
+	if m.Callsign != "VRD961" || m.Altitude != 20125 || m.GroundSpeed != 304 || m.Track != 328 ||
+		m.VerticalRate != -1856 || m.Squawk != "1200" || m.Emergency || !m.HasEmergency() {
+		t.Errorf("bad fields: %+v", m)
+	}
+	if !m.HasPosition() || m.Position.Lat != 36.698040 || m.IsOnGround || !m.HasOnGround() {
+		t.Errorf("bad position: %s", m.Position)
+	}
+	if !m.HasGeomMinusBaro() || m.GeomMinusBaro != 325 {
+		t.Errorf("bad GeomMinusBaro: %d", m.GeomMinusBaro)
+	}
+	if m.ADSBVersion != 2 || m.NIC != 8 || m.NACp != 9 || m.NACv != 1 || m.SIL != 3 || m.GVA != 2 {
+		t.Errorf("bad quality: %+v", m.Quality)
+	}
+	if m.IndicatedAirspeed != 280 || m.Mach != 0.612 || m.SelectedAltitude != 12000 || m.BaroSetting != 1013.6 ||
+		m.HasTrueAirspeed() {
+		t.Errorf("bad EHS: %+v", m.EHS)
+	}
+
+	if m = msgs[1]; m.Icao24 != "~2AB123" || m.Source != SourceTISB || !m.IsMasked() || m.HasNIC() {
+		t.Errorf("bad TIS-B msg: %s %s", m, m.Source)
+	}
+	if m = msgs[2]; m.Source != SourceMLAT || m.Type != "MLAT" {
+		t.Errorf("bad MLAT msg: %s %s", m, m.Source)
+	}
+	if m = msgs[3]; m.Source != SourceModeS || m.HasPosition() || m.SubType != 0 || !m.IsOnGround ||
+		!m.Emergency || m.Squawk != "7700" {
+		t.Errorf("bad Mode S msg: %+v", m)
+	}
+
+	if _,err := ParseAircraftJSON(strings.NewReader("{ broken")); err == nil {
+		t.Errorf("broken JSON was accepted")
+	}
+}
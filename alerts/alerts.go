@@ -0,0 +1,271 @@
+/* Package alerts watches composite ADS-B messages for emergencies.
+
+It generates events when an aircraft starts squawking 7500 (hijack),
+7600 (radio failure) or 7700 (emergency), when the emergency or SPI
+(ident) flags get set, and whenever the squawk changes. Each event
+carries some of the aircraft's messages from either side of it, for
+context.
+
+It slots into the same channel pipeline as msgbuffer; messages are
+passed through untouched. Messages with no Icao24 (i.e. Mode A/C
+replies, from a msgbuffer with AllowPositionless) can't be told apart
+by aircraft, so they are ignored; put a modeac.Correlator in front of
+the detector to have the ones it can match looked at too.
+
+Sample usage:
+
+    d := alerts.NewDetector()
+    go d.Run(flushChan, outChan, eventChan)
+
+*/
+package alerts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/skypies/adsb"
+)
+
+// {{{ Event{}
+
+type EventType int
+const (
+	Hijack EventType = iota // Squawk 7500
+	RadioFailure            // Squawk 7600
+	Emergency               // Squawk 7700
+	EmergencyFlag           // The emergency flag was set
+	Ident                   // The SPI (ident) flag was set
+	SquawkChange            // The squawk changed (or the squawk change alert flag was set)
+)
+
+func (et EventType)String() string {
+	switch et {
+	case Hijack:        return "hijack(7500)"
+	case RadioFailure:  return "radiofailure(7600)"
+	case Emergency:     return "emergency(7700)"
+	case EmergencyFlag: return "emergencyflag"
+	case Ident:         return "ident"
+	case SquawkChange:  return "squawkchange"
+	default:            return "?"
+	}
+}
+
+// specialSquawks map the emergency squawk codes to their events
+var specialSquawks = map[string]EventType{
+	"7500": Hijack,
+	"7600": RadioFailure,
+	"7700": Emergency,
+}
+
+type Event struct {
+	Type       EventType
+	Icao24     adsb.IcaoId
+	Callsign   string
+	Squawk     string
+	PrevSquawk string               // Only set for SquawkChange events
+	Time       time.Time
+	Msg        *adsb.CompositeMsg   // The message that triggered the event
+	Context    []*adsb.CompositeMsg // Messages from around the event (including Msg), in order
+}
+
+func (e Event)String() string {
+	s := fmt.Sprintf("%s %s[%s] squawk=%s", e.Type, e.Icao24, e.Callsign, e.Squawk)
+	if e.Type == SquawkChange { s += fmt.Sprintf(" (was %s)", e.PrevSquawk) }
+	return s + fmt.Sprintf(" @ %s, %d context msgs", e.Time, len(e.Context))
+}
+
+// }}}
+// {{{ Detector{}
+
+type aircraft struct {
+	history    []*adsb.CompositeMsg // The most recent ContextBefore messages
+	squawk     string
+	emergency  bool
+	spi        bool
+	alert      bool
+	lastSeen   time.Time
+}
+
+type pendingEvent struct {
+	Event
+	needed int // How many more context messages to collect
+}
+
+// Detector remembers the recent state of each aircraft. It is not safe for concurrent use.
+type Detector struct {
+	ContextBefore int           // How many messages before the event to include
+	ContextAfter  int           // How many messages after the event to wait for
+	MaxQuietTime  time.Duration // Forget aircraft that send no messages for this long
+
+	aircraft      map[adsb.IcaoId]*aircraft
+	pending       map[adsb.IcaoId][]*pendingEvent
+	lastAgeOut    time.Time
+}
+
+func NewDetector() *Detector {
+	return &Detector{
+		ContextBefore: 10,
+		ContextAfter:  10,
+		MaxQuietTime:  time.Second * 360,
+		aircraft:      map[adsb.IcaoId]*aircraft{},
+		pending:       map[adsb.IcaoId][]*pendingEvent{},
+	}
+}
+
+// }}}
+
+// {{{ Detector.ageOut
+
+// ageOut forgets quiet aircraft, and returns any events they had waiting for more context.
+func (d *Detector)ageOut() []Event {
+	if time.Since(d.lastAgeOut) < time.Second { return nil } // Only run once per second.
+	d.lastAgeOut = time.Now()
+
+	events := []Event{}
+	for id,a := range d.aircraft {
+		if time.Since(a.lastSeen) >= d.MaxQuietTime {
+			for _,pe := range d.pending[id] {
+				events = append(events, pe.Event)
+			}
+			delete(d.pending, id)
+			delete(d.aircraft, id)
+		}
+	}
+	return events
+}
+
+// }}}
+// {{{ Detector.detect
+
+// detect compares the message against the aircraft's previous state.
+func (d *Detector)detect(a *aircraft, isNew bool, cm *adsb.CompositeMsg) []EventType {
+	types := []EventType{}
+
+	if cm.Squawk != "" && cm.Squawk != a.squawk {
+		if et,special := specialSquawks[cm.Squawk]; special {
+			types = append(types, et)
+		}
+		if !isNew && a.squawk != "" {
+			types = append(types, SquawkChange)
+		}
+	} else if cm.AlertSquawkChange && !a.alert && !isNew {
+		types = append(types, SquawkChange)
+	}
+	if cm.Emergency && !a.emergency { types = append(types, EmergencyFlag) }
+	if cm.SPI && !a.spi             { types = append(types, Ident) }
+
+	return types
+}
+
+// }}}
+// {{{ Detector.Add
+
+// Add looks at a new message, and returns any events that are now complete (i.e. have
+// collected enough context). Messages without an Icao24 are ignored.
+func (d *Detector)Add(cm *adsb.CompositeMsg) []Event {
+	completed := d.ageOut()
+	if cm.Icao24 == "" {
+		return completed // Mode A/C; we can't tell which aircraft sent it
+	}
+
+	if d.aircraft == nil { d.aircraft = map[adsb.IcaoId]*aircraft{} }
+	if d.pending == nil { d.pending = map[adsb.IcaoId][]*pendingEvent{} }
+
+	a,exists := d.aircraft[cm.Icao24]
+	if !exists {
+		a = &aircraft{}
+		d.aircraft[cm.Icao24] = a
+	}
+
+	// Earlier events for this aircraft get this message as context
+	stillPending := []*pendingEvent{}
+	for _,pe := range d.pending[cm.Icao24] {
+		pe.Context = append(pe.Context, cm)
+		if pe.needed--; pe.needed <= 0 {
+			completed = append(completed, pe.Event)
+		} else {
+			stillPending = append(stillPending, pe)
+		}
+	}
+	d.pending[cm.Icao24] = stillPending
+
+	a.history = append(a.history, cm)
+	if len(a.history) > d.ContextBefore + 1 {
+		a.history = a.history[len(a.history) - d.ContextBefore - 1:]
+	}
+
+	for _,et := range d.detect(a, !exists, cm) {
+		e := Event{
+			Type:     et,
+			Icao24:   cm.Icao24,
+			Callsign: cm.Callsign,
+			Squawk:   cm.Squawk,
+			Time:     cm.GeneratedTimestampUTC,
+			Msg:      cm,
+			Context:  append([]*adsb.CompositeMsg{}, a.history...),
+		}
+		if et == SquawkChange { e.PrevSquawk = a.squawk }
+
+		if d.ContextAfter <= 0 {
+			completed = append(completed, e)
+		} else {
+			d.pending[cm.Icao24] = append(d.pending[cm.Icao24], &pendingEvent{e, d.ContextAfter})
+		}
+	}
+
+	if cm.Squawk != "" { a.squawk = cm.Squawk }
+	a.emergency, a.spi, a.alert = cm.Emergency, cm.SPI, cm.AlertSquawkChange
+	a.lastSeen = time.Now()
+
+	return completed
+}
+
+// }}}
+// {{{ Detector.Flush
+
+// Flush returns all events still waiting for context.
+func (d *Detector)Flush() []Event {
+	events := []Event{}
+	for id,pes := range d.pending {
+		for _,pe := range pes {
+			events = append(events, pe.Event)
+		}
+		delete(d.pending, id)
+	}
+	return events
+}
+
+// }}}
+// {{{ Detector.Run
+
+// Run reads slices of messages from the input channel, and passes them on unchanged to the
+// output channel. Events are sent to the event channel. Either output channel can be nil.
+// When the input channel is closed, any pending events are flushed, and both output channels
+// are closed.
+func (d *Detector)Run(in <-chan []*adsb.CompositeMsg, out chan<- []*adsb.CompositeMsg, events chan<- Event) {
+	for msgs := range in {
+		for _,cm := range msgs {
+			for _,e := range d.Add(cm) {
+				if events != nil { events <- e }
+			}
+		}
+		if out != nil { out <- msgs }
+	}
+
+	for _,e := range d.Flush() {
+		if events != nil { events <- e }
+	}
+	if out != nil { close(out) }
+	if events != nil { close(events) }
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,94 @@
+// go test -v github.com/skypies/adsb/alerts
+package alerts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skypies/adsb"
+)
+
+func makeMsg(icao adsb.IcaoId, secs int, squawk string, emergency, spi bool) *adsb.CompositeMsg {
+	cm := adsb.CompositeMsg{}
+	cm.Icao24 = icao
+	cm.Callsign = "VRD961"
+	cm.Squawk = squawk
+	cm.Emergency, cm.SPI = emergency, spi
+	cm.GeneratedTimestampUTC = time.Date(2015, 11, 27, 21, 0, secs, 0, time.UTC)
+	return &cm
+}
+
+func TestDetector(t *testing.T) {
+	d := NewDetector()
+	d.ContextBefore, d.ContextAfter = 2, 2
+
+	msgs := []*adsb.CompositeMsg{
+		makeMsg("A81BD0", 0, "1200", false, false),
+		makeMsg("ABEEF0", 1, "7600", false, false), // New aircraft, already 7600
+		makeMsg("A81BD0", 2, "1200", false, false),
+		makeMsg("A81BD0", 3, "1200", false, false),
+		makeMsg("A81BD0", 4, "7700", true,  false), // 7700, emergency flag, squawk change
+		makeMsg("A81BD0", 5, "7700", true,  false),
+		makeMsg("A81BD0", 6, "7700", true,  true),  // Ident
+		makeMsg("A81BD0", 7, "7700", true,  true),
+	}
+
+	events := []Event{}
+	for _,cm := range msgs {
+		events = append(events, d.Add(cm)...)
+	}
+	if len(events) != 3 { t.Fatalf("expected 3 events, saw %d: %v", len(events), events) }
+
+	types := map[EventType]Event{}
+	for _,e := range events { types[e.Type] = e }
+	for _,et := range []EventType{Emergency, EmergencyFlag, SquawkChange} {
+		if _,exists := types[et]; !exists { t.Errorf("no %s event", et) }
+	}
+
+	e := types[SquawkChange]
+	if e.PrevSquawk != "1200" || e.Squawk != "7700" { t.Errorf("bad squawk change: %s", e) }
+	if len(e.Context) != 5 || e.Context[2] != msgs[4] {
+		t.Errorf("bad context (%d msgs): %v", len(e.Context), e.Context)
+	}
+
+	// The 7600 aircraft, and the ident, are still waiting for context
+	remaining := d.Flush()
+	if len(remaining) != 2 { t.Errorf("expected 2 pending events, saw %d", len(remaining)) }
+}
+
+func TestModeAC(t *testing.T) {
+	d := NewDetector()
+	events := []Event{}
+	for i,squawk := range []string{"1200", "4521", "7700"} { // From different aircraft
+		cm := makeMsg("", i, squawk, false, false)
+		cm.Source = adsb.SourceModeAC
+		events = append(events, d.Add(cm)...)
+	}
+	events = append(events, d.Flush()...)
+	if len(events) != 0 { t.Errorf("events from Mode A/C replies: %v", events) }
+}
+
+func TestRun(t *testing.T) {
+	d := NewDetector()
+	in := make(chan []*adsb.CompositeMsg, 1)
+	out := make(chan []*adsb.CompositeMsg, 1)
+	events := make(chan Event, 10)
+
+	in <- []*adsb.CompositeMsg{makeMsg("A81BD0", 0, "1200", false, false),
+		makeMsg("A81BD0", 1, "7500", false, false)}
+	close(in)
+	d.Run(in, out, events)
+
+	if len(<-out) != 2 { t.Errorf("messages not passed through") }
+	n := 0
+	for range events { n++ }
+	if n != 2 { t.Errorf("expected 2 events, saw %d", n) }
+
+	// Just the pass-through
+	in = make(chan []*adsb.CompositeMsg, 1)
+	out = make(chan []*adsb.CompositeMsg, 1)
+	in <- []*adsb.CompositeMsg{makeMsg("A81BD0", 0, "7500", false, false)}
+	close(in)
+	NewDetector().Run(in, out, nil)
+	if len(<-out) != 1 { t.Errorf("messages not passed through without an event channel") }
+}
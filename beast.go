@@ -0,0 +1,164 @@
+package adsb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+)
+
+// The Beast binary format, as output by dump1090 (port 30005) and by mlat-client. Each frame
+// is: 0x1A, a type byte, a 6 byte timestamp (12MHz ticks, from the receiver's clock), a
+// signal level byte, and the raw Mode A/C or Mode S frame. Any 0x1A byte after the first
+// is doubled up.
+// https://github.com/firestuff/adsb-tools/blob/master/protocols/beast.md
+
+const (
+	beastEscape      = 0x1A
+	BeastModeAC      = '1'
+	BeastModeSShort  = '2'
+	BeastModeSLong   = '3'
+
+	// mlat-client sets the timestamp of the frames it generates from its results to this
+	// magic value ("\xFF\x00MLAT"); we use it to spot them. The frames are DF18 ADS-B (with
+	// CPR positions), synthesized from the MLAT solution.
+	BeastMLATTimestamp = 0xFF004D4C4154
+)
+
+var beastFrameLen = map[byte]int{BeastModeAC:2, BeastModeSShort:7, BeastModeSLong:14}
+
+type BeastFrame struct {
+	Type      byte   // One of the Beast* constants
+	Timestamp uint64 // 48 bits
+	Signal    byte
+	Data      []byte
+}
+
+func (f BeastFrame)IsMLAT() bool { return f.Timestamp == BeastMLATTimestamp }
+
+func (f BeastFrame)String() string {
+	return fmt.Sprintf("beast[%c] @%012X sig=%d %X", f.Type, f.Timestamp, f.Signal, f.Data)
+}
+
+// Bytes is the inverse of BeastReader.Read.
+func (f BeastFrame)Bytes() []byte {
+	raw := []byte{
+		f.Type,
+		byte(f.Timestamp >> 40), byte(f.Timestamp >> 32), byte(f.Timestamp >> 24),
+		byte(f.Timestamp >> 16), byte(f.Timestamp >> 8), byte(f.Timestamp),
+		f.Signal,
+	}
+	raw = append(raw, f.Data...)
+
+	ret := []byte{beastEscape}
+	for _,b := range raw {
+		if b == beastEscape { ret = append(ret, beastEscape) }
+		ret = append(ret, b)
+	}
+	return ret
+}
+
+// BeastFrameFromModeS wraps a raw Mode A/C or Mode S frame, picking the Beast type from its
+// length.
+func BeastFrameFromModeS(data []byte, timestamp uint64) (BeastFrame, error) {
+	for t,n := range beastFrameLen {
+		if n == len(data) {
+			return BeastFrame{Type:t, Timestamp:timestamp & 0xFFFFFFFFFFFF, Data:data}, nil
+		}
+	}
+	return BeastFrame{}, fmt.Errorf("no Beast frame type for %d bytes", len(data))
+}
+
+// BeastReader reads frames from a stream in Beast format. Frame types we don't know about
+// (e.g. the '4' status frames from some receivers) are skipped, as is anything that doesn't
+// look like a frame, so that a reader can start mid-stream.
+type BeastReader struct {
+	r            *bufio.Reader
+	atFrameStart bool // We've already consumed the 0x1A that starts the next frame
+}
+
+func NewBeastReader(r io.Reader) *BeastReader {
+	return &BeastReader{r: bufio.NewReader(r)}
+}
+
+// readByte reads an escaped byte from inside a frame. If it finds the start of a new frame
+// instead, it returns false.
+func (br *BeastReader)readByte() (byte, bool, error) {
+	b,err := br.r.ReadByte()
+	if err != nil || b != beastEscape {
+		return b, true, err
+	}
+	if next,err := br.r.Peek(1); err != nil {
+		return 0, false, err
+	} else if next[0] != beastEscape {
+		return 0, false, nil // Unescaped 0x1A: a new frame has started
+	}
+	br.r.ReadByte()
+	return b, true, nil
+}
+
+// Read returns the next frame; at the end of the stream, the error is io.EOF.
+func (br *BeastReader)Read() (BeastFrame, error) {
+	for {
+		// Find the start of a frame
+		if !br.atFrameStart {
+			if b,err := br.r.ReadByte(); err != nil {
+				return BeastFrame{}, err
+			} else if b != beastEscape {
+				continue
+			}
+		}
+		br.atFrameStart = false
+
+		t,err := br.r.ReadByte()
+		if err != nil {
+			return BeastFrame{}, err
+		}
+		n,known := beastFrameLen[t]
+		if !known {
+			continue
+		}
+
+		raw := make([]byte, 7 + n)
+		complete := true
+		for i:=range raw {
+			b,ok,err := br.readByte()
+			if err == io.EOF {
+				return BeastFrame{}, io.ErrUnexpectedEOF
+			} else if err != nil {
+				return BeastFrame{}, err
+			} else if !ok {
+				complete = false
+				break
+			}
+			raw[i] = b
+		}
+		if !complete {
+			br.atFrameStart = true // Truncated frame; we're now at the start of the next one
+			continue
+		}
+
+		ts := uint64(0)
+		for _,b := range raw[:6] { ts = ts<<8 | uint64(b) }
+		return BeastFrame{Type:t, Timestamp:ts, Signal:raw[6], Data:raw[7:]}, nil
+	}
+}
+
+// FromBeast decodes a Beast frame, received at time t (see FromModeS & FromModeAC). Frames
+// from mlat-client's results are marked as MLAT.
+func (m *Msg)FromBeast(f BeastFrame, t time.Time) error {
+	var err error
+	if f.Type == BeastModeAC {
+		err = m.FromModeAC(f.Data, t)
+	} else {
+		err = m.FromModeS(f.Data, t)
+	}
+	if err != nil {
+		return err
+	}
+
+	if f.IsMLAT() {
+		m.Type, m.Source = "MLAT", SourceMLAT
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package adsb
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBeastReader(t *testing.T) {
+	long,_ := ParseAVR("*8D4840D6202CC371C32CE0576098;")
+	frames := []BeastFrame{
+		{Type:BeastModeSLong, Timestamp:0x00001A2B3C4D, Signal:0x1A, Data:long}, // Needs escaping
+		{Type:BeastModeSShort, Timestamp:1, Signal:40, Data:[]byte{0x5D, 0x48, 0x40, 0xD6, 0xA1, 0xB2, 0xC3}},
+		{Type:BeastModeAC, Timestamp:2, Signal:50, Data:[]byte{0x77, 0x00}},
+	}
+
+	stream := []byte{0x00, 0x42} // Junk, as if we joined mid-stream
+	for _,f := range frames {
+		stream = append(stream, f.Bytes()...)
+	}
+	stream = append(stream, 0x1A, '4', 0x01, 0x02) // A status frame, which we skip ...
+	stream = append(stream, frames[2].Bytes()[:5]...) // ... and a truncated frame
+	stream = append(stream, frames[2].Bytes()...)
+
+	br := NewBeastReader(bytes.NewReader(stream))
+	for i,expected := range append(frames, frames[2]) {
+		f,err := br.Read()
+		if err != nil {
+			t.Fatalf("[%d] %v", i, err)
+		}
+		if f.Type != expected.Type || f.Timestamp != expected.Timestamp || f.Signal != expected.Signal ||
+			!bytes.Equal(f.Data, expected.Data) {
+			t.Errorf("[%d] got %s, expected %s", i, f, expected)
+		}
+	}
+	if _,err := br.Read(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+
+	if _,err := NewBeastReader(bytes.NewReader(frames[0].Bytes()[:10])).Read(); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected unexpected EOF, got %v", err)
+	}
+}
+
+func TestFromBeastMLAT(t *testing.T) {
+	tm := time.Now()
+	d := NewCPRDecoder()
+
+	// The even/odd pair from The 1090MHz Riddle, as if from an MLAT server
+	for i,avr := range []string{"*8D40621D58C382D690C8AC2863A7;", "*8D40621D58C386435CC412692AD6;"} {
+		data,_ := ParseAVR(avr)
+		f,err := BeastFrameFromModeS(data, BeastMLATTimestamp)
+		if err != nil { t.Fatal(err) }
+
+		m := Msg{}
+		if err := m.FromBeast(f, tm); err != nil { t.Fatal(err) }
+		if !m.IsMLAT() || m.Source != SourceMLAT || m.Icao24 != "40621D" || m.Altitude != 38000 {
+			t.Errorf("[%d] bad MLAT msg: %s %s", i, m, m.Source)
+		}
+		if decoded := d.Decode(&m); decoded != (i == 1) {
+			t.Errorf("[%d] decoded=%v", i, decoded)
+		} else if decoded && (math.Abs(m.Position.Lat - 52.2658) > 0.001 || math.Abs(m.Position.Long - 3.9389) > 0.001) {
+			t.Errorf("[%d] bad position %s", i, m.Position)
+		}
+	}
+
+	f,_ := BeastFrameFromModeS([]byte{0x77, 0x00}, 12345)
+	m := Msg{}
+	if err := m.FromBeast(f, tm); err != nil || !m.IsModeAC() || m.IsMLAT() {
+		t.Errorf("bad Mode A/C msg: %v %+v", err, m)
+	}
+
+	if _,err := BeastFrameFromModeS([]byte{1, 2, 3}, 0); err == nil {
+		t.Errorf("3 byte frame was accepted")
+	}
+}
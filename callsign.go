@@ -0,0 +1,120 @@
+package adsb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type CallsignType int
+const (
+	CallsignUnknown      CallsignType = iota // Empty, or couldn't make sense of it
+	CallsignFlight                           // ICAO operator designator + flight number, e.g. UAL123
+	CallsignRegistration                     // The aircraft's registration, e.g. N123AB
+)
+
+func (ct CallsignType)String() string {
+	switch ct {
+	case CallsignFlight:       return "flight"
+	case CallsignRegistration: return "registration"
+	default:                   return "unknown"
+	}
+}
+
+// Callsign is a callsign, broken down into its parts.
+type Callsign struct {
+	Raw          string       // As it was transmitted, but trimmed & uppercased
+	Type         CallsignType
+
+	// For CallsignFlight
+	Designator   string       // ICAO operator designator, e.g. UAL
+	FlightNumber string       // e.g. 123, 12A
+	IATA         string       // IATA airline code, e.g. UA, if known
+	Airline      string       // Airline name, if known
+
+	// For CallsignRegistration
+	Registration string
+}
+
+func (c Callsign)String() string {
+	switch c.Type {
+	case CallsignFlight:       return fmt.Sprintf("%s[%s/%s]", c.Raw, c.Designator, c.FlightNumber)
+	case CallsignRegistration: return fmt.Sprintf("%s[reg]", c.Raw)
+	default:                   return fmt.Sprintf("%s[?]", c.Raw)
+	}
+}
+
+// IATAFlight returns the flight in IATA form (e.g. UA123), if the airline's IATA code is
+// known; otherwise the empty string.
+func (c Callsign)IATAFlight() string {
+	if c.Type != CallsignFlight || c.IATA == "" { return "" }
+	return c.IATA + strings.TrimLeft(c.FlightNumber, "0")
+}
+
+var (
+	// ICAO flight identifications are a three letter designator, then up to four characters
+	// (starting with a digit).
+	flightCallsignRegexp = regexp.MustCompile(`^([A-Z]{3})([0-9][0-9A-Z]{0,3})$`)
+
+	// Registrations sent as callsigns (without the hyphen). Most are all letters (G-EUPA,
+	// C-FABC, D-AIBC); some states use digits (JA801A, HL7201). N-numbers are checked
+	// separately. All-letter callsigns are only taken as registrations if they start with a
+	// known nationality prefix, as plenty of tactical callsigns (JANET, REACH) are all letters
+	// too; this list is not exhaustive.
+	registrationCallsignRegexps = []*regexp.Regexp{
+		regexp.MustCompile(`^JA[0-9]{2}[0-9A-Z]{2}$`),
+		regexp.MustCompile(`^HL[0-9]{4}$`),
+		regexp.MustCompile(`^[GDFI][A-Z]{4}$`),  // UK, Germany, France, Italy
+		regexp.MustCompile(`^C[FGI][A-Z]{3}$`),  // Canada
+		regexp.MustCompile(`^(VH|ZK|EI|OO|PH|SE|OY|OH|LN|HB|OE|EC|CS|SP|OK|TF|ZS|VT|X[ABC]|P[P-U])[A-Z]{3}$`),
+	}
+)
+
+// ParseCallsign breaks a callsign down into its parts.
+func ParseCallsign(s string) Callsign {
+	c := Callsign{Raw: strings.ToUpper(strings.TrimSpace(s))}
+	if c.Raw == "" || c.Raw == NilCallsign {
+		return c
+	}
+
+	if m := flightCallsignRegexp.FindStringSubmatch(c.Raw); m != nil {
+		c.Type, c.Designator, c.FlightNumber = CallsignFlight, m[1], m[2]
+		if a,exists := LookupAirline(c.Designator); exists {
+			c.IATA, c.Airline = a.IATA, a.Name
+		}
+		return c
+	}
+
+	if strings.HasPrefix(c.Raw, "N") {
+		// Only accept N-numbers that are actually valid
+		if _,err := nnumberToAddr(c.Raw); err == nil {
+			c.Type, c.Registration = CallsignRegistration, c.Raw
+		}
+		return c
+	}
+	for _,re := range registrationCallsignRegexps {
+		if re.MatchString(c.Raw) {
+			c.Type, c.Registration = CallsignRegistration, c.Raw
+			break
+		}
+	}
+
+	return c
+}
+
+// ParsedCallsign returns the message's callsign, broken down into its parts.
+func (m Msg)ParsedCallsign() Callsign { return ParseCallsign(m.Callsign) }
+
+// Airline maps an ICAO operator designator to its IATA code and name.
+type Airline struct {
+	ICAO  string
+	IATA  string // Empty, if the operator has no IATA code
+	Name  string
+}
+
+// LookupAirline finds the airline with the ICAO designator, from a built-in table of the
+// larger airlines and operators.
+func LookupAirline(designator string) (Airline, bool) {
+	a,exists := airlines[strings.ToUpper(designator)]
+	return a, exists
+}
@@ -0,0 +1,108 @@
+package adsb
+
+// A table of ICAO operator designators, for the larger airlines (and a few other common
+// operators). It is not exhaustive; unknown designators just don't get IATA codes or names.
+var airlines = map[string]Airline{
+	"AAL": {"AAL", "AA", "American Airlines"},
+	"AAR": {"AAR", "OZ", "Asiana Airlines"},
+	"AAY": {"AAY", "G4", "Allegiant Air"},
+	"ABX": {"ABX", "GB", "ABX Air"},
+	"ACA": {"ACA", "AC", "Air Canada"},
+	"AEE": {"AEE", "A3", "Aegean Airlines"},
+	"AFR": {"AFR", "AF", "Air France"},
+	"AIC": {"AIC", "AI", "Air India"},
+	"AMX": {"AMX", "AM", "Aeromexico"},
+	"ANA": {"ANA", "NH", "All Nippon Airways"},
+	"ANZ": {"ANZ", "NZ", "Air New Zealand"},
+	"ARG": {"ARG", "AR", "Aerolineas Argentinas"},
+	"ASA": {"ASA", "AS", "Alaska Airlines"},
+	"ASH": {"ASH", "YV", "Mesa Airlines"},
+	"AUA": {"AUA", "OS", "Austrian Airlines"},
+	"AVA": {"AVA", "AV", "Avianca"},
+	"AZU": {"AZU", "AD", "Azul"},
+	"BAW": {"BAW", "BA", "British Airways"},
+	"BEL": {"BEL", "SN", "Brussels Airlines"},
+	"CAL": {"CAL", "CI", "China Airlines"},
+	"CCA": {"CCA", "CA", "Air China"},
+	"CES": {"CES", "MU", "China Eastern Airlines"},
+	"CFG": {"CFG", "DE", "Condor"},
+	"CHH": {"CHH", "HU", "Hainan Airlines"},
+	"CKS": {"CKS", "K4", "Kalitta Air"},
+	"CLX": {"CLX", "CV", "Cargolux"},
+	"CMP": {"CMP", "CM", "Copa Airlines"},
+	"CPA": {"CPA", "CX", "Cathay Pacific"},
+	"CSN": {"CSN", "CZ", "China Southern Airlines"},
+	"CXA": {"CXA", "MF", "Xiamen Airlines"},
+	"DAL": {"DAL", "DL", "Delta Air Lines"},
+	"DLH": {"DLH", "LH", "Lufthansa"},
+	"EDV": {"EDV", "9E", "Endeavor Air"},
+	"EIN": {"EIN", "EI", "Aer Lingus"},
+	"EJA": {"EJA", "1I", "NetJets"},
+	"ELY": {"ELY", "LY", "El Al"},
+	"ENY": {"ENY", "MQ", "Envoy Air"},
+	"ETD": {"ETD", "EY", "Etihad Airways"},
+	"ETH": {"ETH", "ET", "Ethiopian Airlines"},
+	"EVA": {"EVA", "BR", "EVA Air"},
+	"EWG": {"EWG", "EW", "Eurowings"},
+	"EZY": {"EZY", "U2", "easyJet"},
+	"FDX": {"FDX", "FX", "FedEx"},
+	"FFT": {"FFT", "F9", "Frontier Airlines"},
+	"FIN": {"FIN", "AY", "Finnair"},
+	"GIA": {"GIA", "GA", "Garuda Indonesia"},
+	"GLO": {"GLO", "G3", "Gol"},
+	"GTI": {"GTI", "5Y", "Atlas Air"},
+	"HAL": {"HAL", "HA", "Hawaiian Airlines"},
+	"HVN": {"HVN", "VN", "Vietnam Airlines"},
+	"IBE": {"IBE", "IB", "Iberia"},
+	"ICE": {"ICE", "FI", "Icelandair"},
+	"ITY": {"ITY", "AZ", "ITA Airways"},
+	"JAL": {"JAL", "JL", "Japan Airlines"},
+	"JBU": {"JBU", "B6", "JetBlue Airways"},
+	"JIA": {"JIA", "OH", "PSA Airlines"},
+	"JST": {"JST", "JQ", "Jetstar"},
+	"JZA": {"JZA", "QK", "Jazz Aviation"},
+	"KAL": {"KAL", "KE", "Korean Air"},
+	"KLM": {"KLM", "KL", "KLM Royal Dutch Airlines"},
+	"KQA": {"KQA", "KQ", "Kenya Airways"},
+	"LAN": {"LAN", "LA", "LATAM Airlines"},
+	"LOT": {"LOT", "LO", "LOT Polish Airlines"},
+	"LXJ": {"LXJ", "", "Flexjet"},
+	"MAS": {"MAS", "MH", "Malaysia Airlines"},
+	"MSR": {"MSR", "MS", "EgyptAir"},
+	"NAX": {"NAX", "DY", "Norwegian Air Shuttle"},
+	"NKS": {"NKS", "NK", "Spirit Airlines"},
+	"PAL": {"PAL", "PR", "Philippine Airlines"},
+	"POE": {"POE", "PD", "Porter Airlines"},
+	"QFA": {"QFA", "QF", "Qantas"},
+	"QTR": {"QTR", "QR", "Qatar Airways"},
+	"QXE": {"QXE", "QX", "Horizon Air"},
+	"RCH": {"RCH", "", "US Air Force Air Mobility Command (Reach)"},
+	"ROU": {"ROU", "RV", "Air Canada Rouge"},
+	"RPA": {"RPA", "YX", "Republic Airways"},
+	"RYR": {"RYR", "FR", "Ryanair"},
+	"SAA": {"SAA", "SA", "South African Airways"},
+	"SAS": {"SAS", "SK", "Scandinavian Airlines"},
+	"SCX": {"SCX", "SY", "Sun Country Airlines"},
+	"SIA": {"SIA", "SQ", "Singapore Airlines"},
+	"SKW": {"SKW", "OO", "SkyWest Airlines"},
+	"SVA": {"SVA", "SV", "Saudia"},
+	"SWA": {"SWA", "WN", "Southwest Airlines"},
+	"SWR": {"SWR", "LX", "Swiss International Air Lines"},
+	"TAM": {"TAM", "JJ", "LATAM Brasil"},
+	"TAP": {"TAP", "TP", "TAP Air Portugal"},
+	"THA": {"THA", "TG", "Thai Airways"},
+	"THY": {"THY", "TK", "Turkish Airlines"},
+	"TRA": {"TRA", "HV", "Transavia"},
+	"TSC": {"TSC", "TS", "Air Transat"},
+	"UAE": {"UAE", "EK", "Emirates"},
+	"UAL": {"UAL", "UA", "United Airlines"},
+	"UPS": {"UPS", "5X", "UPS Airlines"},
+	"VIR": {"VIR", "VS", "Virgin Atlantic"},
+	"VJC": {"VJC", "VJ", "VietJet Air"},
+	"VLG": {"VLG", "VY", "Vueling"},
+	"VOI": {"VOI", "Y4", "Volaris"},
+	"VOZ": {"VOZ", "VA", "Virgin Australia"},
+	"VRD": {"VRD", "VX", "Virgin America"},
+	"WJA": {"WJA", "WS", "WestJet"},
+	"WZZ": {"WZZ", "W6", "Wizz Air"},
+}
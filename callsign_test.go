@@ -0,0 +1,66 @@
+package adsb
+
+import "testing"
+
+func TestParseCallsign(t *testing.T) {
+	tests := []struct {
+		In           string
+		Type         CallsignType
+		Designator   string
+		FlightNumber string
+		IATAFlight   string
+		Airline      string
+	}{
+		{"VRD961  ", CallsignFlight,       "VRD", "961",  "VX961",  "Virgin America"},
+		{"ual0123",  CallsignFlight,       "UAL", "0123", "UA123",  "United Airlines"},
+		{"BAW12AB",  CallsignFlight,       "BAW", "12AB", "BA12AB", "British Airways"},
+		{"RCH471",   CallsignFlight,       "RCH", "471",  "",       "US Air Force Air Mobility Command (Reach)"},
+		{"XYZ1",     CallsignFlight,       "XYZ", "1",    "",       ""},
+		{"N123AB",   CallsignRegistration, "",    "",     "",       ""},
+		{"N1",       CallsignRegistration, "",    "",     "",       ""},
+		{"GEUPA",    CallsignRegistration, "",    "",     "",       ""},
+		{"CFABC",    CallsignRegistration, "",    "",     "",       ""},
+		{"JA801A",   CallsignRegistration, "",    "",     "",       ""},
+		{"VHOQA",    CallsignRegistration, "",    "",     "",       ""},
+		{"DAIBC",    CallsignRegistration, "",    "",     "",       ""},
+		{"JANET",    CallsignUnknown,      "",    "",     "",       ""}, // Tactical callsigns
+		{"REACH",    CallsignUnknown,      "",    "",     "",       ""},
+		{"TEST",     CallsignUnknown,      "",    "",     "",       ""},
+		{"ROCKY",    CallsignUnknown,      "",    "",     "",       ""},
+		{"GABCDE",   CallsignUnknown,      "",    "",     "",       ""}, // Too long for the UK
+		{"N0123",    CallsignUnknown,      "",    "",     "",       ""}, // Not a valid N-number
+		{"N1ABC",    CallsignUnknown,      "",    "",     "",       ""},
+		{"AB1",      CallsignUnknown,      "",    "",     "",       ""},
+		{"",         CallsignUnknown,      "",    "",     "",       ""},
+		{NilCallsign, CallsignUnknown,     "",    "",     "",       ""},
+	}
+
+	for i,test := range tests {
+		c := ParseCallsign(test.In)
+		if c.Type != test.Type || c.Designator != test.Designator || c.FlightNumber != test.FlightNumber {
+			t.Errorf("[%d] %q: got %s (%s), expected %s[%s/%s]", i, test.In, c, c.Type,
+				test.Type, test.Designator, test.FlightNumber)
+		}
+		if c.IATAFlight() != test.IATAFlight || c.Airline != test.Airline {
+			t.Errorf("[%d] %q: got %q/%q, expected %q/%q", i, test.In, c.IATAFlight(), c.Airline,
+				test.IATAFlight, test.Airline)
+		}
+		if c.Type == CallsignRegistration && c.Registration != c.Raw {
+			t.Errorf("[%d] %q: registration %q", i, test.In, c.Registration)
+		}
+	}
+}
+
+func TestLookupAirline(t *testing.T) {
+	if a,exists := LookupAirline("dal"); !exists || a.IATA != "DL" || a.Name != "Delta Air Lines" {
+		t.Errorf("bad lookup for DAL: %v, %v", a, exists)
+	}
+	if _,exists := LookupAirline("ZZZ"); exists {
+		t.Errorf("found an airline for ZZZ")
+	}
+	for k,a := range airlines {
+		if k != a.ICAO || len(a.ICAO) != 3 || (a.IATA != "" && len(a.IATA) != 2) {
+			t.Errorf("bad table entry %q: %v", k, a)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package adsb
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/skypies/geo"
+)
+
+// ClosestApproach describes the point at which an aircraft came closest to a location on
+// the ground.
+type ClosestApproach struct {
+	Msg           *CompositeMsg // Aircraft state at closest approach; IsSynthetic if interpolated
+	Time          time.Time
+	HorizontalKM  float64       // Distance along the ground
+	SlantKM       float64       // Straight-line distance, taking altitude into account
+	AltitudeAbove float64       // Height (in feet) above the location
+}
+
+func (ca ClosestApproach)String() string {
+	return fmt.Sprintf("%s: %.2fKM (%.2fKM horiz, %.0fft above) @ %s",
+		ca.Msg.Icao24, ca.SlantKM, ca.HorizontalKM, ca.AltitudeAbove, ca.Time)
+}
+
+func (ca ClosestApproach)SlantNM() float64 { return ca.SlantKM * geo.KNauticalMilePerKM }
+func (ca ClosestApproach)HorizontalNM() float64 { return ca.HorizontalKM * geo.KNauticalMilePerKM }
+
+// enuKM projects a position & altitude into a flat local frame (east, north, up; in KM)
+// centered on the origin. Fine for the distances over which CPA matters.
+func enuKM(origin geo.Latlong, elevationFt float64, pos geo.Latlong, altitudeFt int64) [3]float64 {
+	const earthRadiusKM = 6371.0
+	rad := math.Pi / 180
+	return [3]float64{
+		(pos.Long - origin.Long) * rad * earthRadiusKM * math.Cos(origin.Lat * rad),
+		(pos.Lat - origin.Lat) * rad * earthRadiusKM,
+		(float64(altitudeFt) - elevationFt) / geo.KFeetPerKM,
+	}
+}
+
+// FindClosestApproach works out when the aircraft got closest to the location (which is at
+// the given elevation, in feet). Rather than just picking the nearest message, it looks
+// along the straight line between each pair of consecutive messages, and interpolates the
+// aircraft's state at the closest point. The messages should be in time order; those without
+// positions are ignored. Returns false if there were no positions.
+func FindClosestApproach(msgs []*CompositeMsg, pos geo.Latlong, elevationFt float64) (ClosestApproach, bool) {
+	in := []*CompositeMsg{}
+	for _,m := range msgs {
+		if m.HasPosition() { in = append(in, m) }
+	}
+	if len(in) == 0 {
+		return ClosestApproach{}, false
+	}
+
+	best := in[0]
+	bestDistSq := math.MaxFloat64
+	for i:=0; i<len(in); i++ {
+		a := enuKM(pos, elevationFt, in[i].Position, in[i].Altitude)
+		candidate,r := in[i],0.0
+
+		if i+1 < len(in) {
+			// Find r in [0,1] that minimizes |a + r(b-a)|
+			b := enuKM(pos, elevationFt, in[i+1].Position, in[i+1].Altitude)
+			d := [3]float64{b[0]-a[0], b[1]-a[1], b[2]-a[2]}
+			if lenSq := d[0]*d[0] + d[1]*d[1] + d[2]*d[2]; lenSq > 0 {
+				r = -(a[0]*d[0] + a[1]*d[1] + a[2]*d[2]) / lenSq
+				r = math.Max(0, math.Min(1, r))
+				a = [3]float64{a[0] + r*d[0], a[1] + r*d[1], a[2] + r*d[2]}
+			}
+			if r > 0 && r < 1 {
+				span := in[i+1].GeneratedTimestampUTC.Sub(in[i].GeneratedTimestampUTC)
+				t := in[i].GeneratedTimestampUTC.Add(time.Duration(r * float64(span)))
+				candidate = InterpolateComposite(in[i], in[i+1], t)
+			} else if r == 1 {
+				candidate = in[i+1]
+			}
+		}
+
+		if distSq := a[0]*a[0] + a[1]*a[1] + a[2]*a[2]; distSq < bestDistSq {
+			best,bestDistSq = candidate,distSq
+		}
+	}
+
+	ca := ClosestApproach{
+		Msg:           best,
+		Time:          best.GeneratedTimestampUTC,
+		HorizontalKM:  pos.DistKM(best.Position),
+		AltitudeAbove: float64(best.Altitude) - elevationFt,
+	}
+	ca.SlantKM = math.Sqrt(ca.HorizontalKM*ca.HorizontalKM +
+		math.Pow(ca.AltitudeAbove / geo.KFeetPerKM, 2))
+
+	return ca, true
+}
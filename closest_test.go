@@ -0,0 +1,51 @@
+package adsb
+
+import(
+	"bufio"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/skypies/geo"
+)
+
+func compositesFromSBS(sbs string) (ret []*CompositeMsg) {
+	scanner := bufio.NewScanner(strings.NewReader(sbs))
+	for scanner.Scan() {
+		m := Msg{}
+		if err := m.FromSBS1(scanner.Text()); err != nil {
+			panic(err)
+		}
+		ret = append(ret, &CompositeMsg{Msg:m})
+	}
+	return
+}
+
+// Flying due north along -122.0, descending; 10s between points
+var overflightSBS = `MSG,3,1,1,A81BD0,1,2015/11/27,21:31:00.000,2015/11/27,21:31:00.000,,5000,200,0,36.90000,-122.00000,-600,,,,,0
+MSG,3,1,1,A81BD0,1,2015/11/27,21:31:10.000,2015/11/27,21:31:10.000,,4900,200,0,36.91000,-122.00000,-600,,,,,0
+MSG,3,1,1,A81BD0,1,2015/11/27,21:31:20.000,2015/11/27,21:31:20.000,,4800,200,0,36.92000,-122.00000,-600,,,,,0`
+
+func TestFindClosestApproach(t *testing.T) {
+	msgs := compositesFromSBS(overflightSBS)
+	house := geo.Latlong{Lat:36.9125, Long:-121.99}
+
+	ca,ok := FindClosestApproach(msgs, house, 100)
+	if !ok { t.Fatalf("no closest approach found") }
+
+	// Should be between the 2nd & 3rd points, level with the house
+	if !ca.Msg.IsSynthetic { t.Errorf("closest approach was not interpolated") }
+	if ca.Time.Before(msgs[1].GeneratedTimestampUTC) || ca.Time.After(msgs[2].GeneratedTimestampUTC) {
+		t.Errorf("closest approach at wrong time: %s", ca)
+	}
+	if math.Abs(ca.Msg.Position.Lat - house.Lat) > 0.001 { t.Errorf("wrong position: %s", ca.Msg) }
+	if math.Abs(ca.HorizontalKM - 0.892) > 0.01 { t.Errorf("wrong horiz dist: %s", ca) }
+	if math.Abs(ca.AltitudeAbove - 4775) > 5 { t.Errorf("wrong altitude: %s", ca) }
+	if ca.SlantKM <= ca.HorizontalKM { t.Errorf("slant distance too small: %s", ca) }
+
+	if _,ok := FindClosestApproach(nil, house, 0); ok { t.Errorf("found CPA in empty slice") }
+
+	// Beyond the end of the track; should pick the last point
+	ca,_ = FindClosestApproach(msgs, geo.Latlong{Lat:37.5, Long:-122.0}, 0)
+	if ca.Msg != msgs[2] { t.Errorf("did not pick endpoint: %s", ca) }
+}
@@ -0,0 +1,259 @@
+package adsb
+
+import (
+	"math"
+	"strings"
+	"time"
+)
+
+// Comm-B replies (DF20/21) carry 56 bits of data from one of the aircraft's registers, as
+// requested by a Mode S radar; but the reply doesn't say which register it is. As per
+// pyModeS, we look at which layouts the data is consistent with (status bits, reserved
+// bits, sane ranges); if exactly one register fits, we decode it. We handle the ones used
+// for Mode S Enhanced Surveillance:
+//  BDS 2,0: aircraft identification (callsign)
+//  BDS 4,0: selected vertical intention (selected altitude, barometric setting)
+//  BDS 5,0: track and turn report (roll, true track, ground speed, true airspeed)
+//  BDS 6,0: heading and speed report (magnetic heading, IAS, Mach, vertical rate)
+
+// EHS holds the extra (Enhanced Surveillance) fields we get from Comm-B replies. It is
+// embedded in Msg; each field has a Has method, as they are rarely all present.
+type EHS struct {
+	SelectedAltitude    int64   `json:",omitempty"` // feet; MCP/FCU selected altitude
+	FMSSelectedAltitude int64   `json:",omitempty"` // feet
+	BaroSetting         float64 `json:",omitempty"` // millibars; the altimeter setting (i.e. QNH, or standard)
+	RollAngle           float64 `json:",omitempty"` // degrees; positive is right wing down
+	TrueAirspeed        int64   `json:",omitempty"` // knots
+	MagneticHeading     float64 `json:",omitempty"` // degrees
+	IndicatedAirspeed   int64   `json:",omitempty"` // knots
+	Mach                float64 `json:",omitempty"`
+
+	hasSelectedAltitude    bool
+	hasFMSSelectedAltitude bool
+	hasBaroSetting         bool
+	hasRollAngle           bool
+	hasTrueAirspeed        bool
+	hasMagneticHeading     bool
+	hasIndicatedAirspeed   bool
+	hasMach                bool
+
+	// When each field was reported, if recorded by UpdateAt; zero if unknown (e.g. the field
+	// was decoded from this message).
+	selectedAltitudeTime    time.Time
+	fmsSelectedAltitudeTime time.Time
+	baroSettingTime         time.Time
+	rollAngleTime           time.Time
+	trueAirspeedTime        time.Time
+	magneticHeadingTime     time.Time
+	indicatedAirspeedTime   time.Time
+	machTime                time.Time
+}
+
+func (e EHS)HasSelectedAltitude()    bool { return e.hasSelectedAltitude }
+func (e EHS)HasFMSSelectedAltitude() bool { return e.hasFMSSelectedAltitude }
+func (e EHS)HasBaroSetting()         bool { return e.hasBaroSetting }
+func (e EHS)HasRollAngle()           bool { return e.hasRollAngle }
+func (e EHS)HasTrueAirspeed()        bool { return e.hasTrueAirspeed }
+func (e EHS)HasMagneticHeading()     bool { return e.hasMagneticHeading }
+func (e EHS)HasIndicatedAirspeed()   bool { return e.hasIndicatedAirspeed }
+func (e EHS)HasMach()                bool { return e.hasMach }
+
+// The ...Time methods return when the field was reported, as recorded by UpdateAt; or the
+// zero time, if that isn't known.
+func (e EHS)SelectedAltitudeTime()    time.Time { return e.selectedAltitudeTime }
+func (e EHS)FMSSelectedAltitudeTime() time.Time { return e.fmsSelectedAltitudeTime }
+func (e EHS)BaroSettingTime()         time.Time { return e.baroSettingTime }
+func (e EHS)RollAngleTime()           time.Time { return e.rollAngleTime }
+func (e EHS)TrueAirspeedTime()        time.Time { return e.trueAirspeedTime }
+func (e EHS)MagneticHeadingTime()     time.Time { return e.magneticHeadingTime }
+func (e EHS)IndicatedAirspeedTime()   time.Time { return e.indicatedAirspeedTime }
+func (e EHS)MachTime()                time.Time { return e.machTime }
+
+func (e *EHS)SetSelectedAltitude(ft int64)    { e.SelectedAltitude, e.hasSelectedAltitude = ft, true }
+func (e *EHS)SetFMSSelectedAltitude(ft int64) { e.FMSSelectedAltitude, e.hasFMSSelectedAltitude = ft, true }
+func (e *EHS)SetBaroSetting(mb float64)       { e.BaroSetting, e.hasBaroSetting = mb, true }
+func (e *EHS)SetRollAngle(deg float64)        { e.RollAngle, e.hasRollAngle = deg, true }
+func (e *EHS)SetTrueAirspeed(kt int64)        { e.TrueAirspeed, e.hasTrueAirspeed = kt, true }
+func (e *EHS)SetMagneticHeading(deg float64)  { e.MagneticHeading, e.hasMagneticHeading = deg, true }
+func (e *EHS)SetIndicatedAirspeed(kt int64)   { e.IndicatedAirspeed, e.hasIndicatedAirspeed = kt, true }
+func (e *EHS)SetMach(mach float64)            { e.Mach, e.hasMach = mach, true }
+
+// Update copies over any fields that are present in the other EHS.
+func (e *EHS)Update(from EHS) {
+	if from.hasSelectedAltitude    { e.SetSelectedAltitude(from.SelectedAltitude) }
+	if from.hasFMSSelectedAltitude { e.SetFMSSelectedAltitude(from.FMSSelectedAltitude) }
+	if from.hasBaroSetting         { e.SetBaroSetting(from.BaroSetting) }
+	if from.hasRollAngle           { e.SetRollAngle(from.RollAngle) }
+	if from.hasTrueAirspeed        { e.SetTrueAirspeed(from.TrueAirspeed) }
+	if from.hasMagneticHeading     { e.SetMagneticHeading(from.MagneticHeading) }
+	if from.hasIndicatedAirspeed   { e.SetIndicatedAirspeed(from.IndicatedAirspeed) }
+	if from.hasMach                { e.SetMach(from.Mach) }
+}
+
+// UpdateAt is like Update, but also records that the copied fields were reported at time t,
+// so that users of the merged data can tell how old each field is.
+func (e *EHS)UpdateAt(from EHS, t time.Time) {
+	e.Update(from)
+	if from.hasSelectedAltitude    { e.selectedAltitudeTime    = t }
+	if from.hasFMSSelectedAltitude { e.fmsSelectedAltitudeTime = t }
+	if from.hasBaroSetting         { e.baroSettingTime         = t }
+	if from.hasRollAngle           { e.rollAngleTime           = t }
+	if from.hasTrueAirspeed        { e.trueAirspeedTime        = t }
+	if from.hasMagneticHeading     { e.magneticHeadingTime     = t }
+	if from.hasIndicatedAirspeed   { e.indicatedAirspeedTime   = t }
+	if from.hasMach                { e.machTime                = t }
+}
+
+// signed reads a sign bit followed by an n bit value, as a two's complement number.
+func signed(mb []byte, signBit, n int) int64 {
+	v := int64(bits(mb, signBit+1, n))
+	if bits(mb, signBit, 1) == 1 {
+		v -= 1 << uint(n)
+	}
+	return v
+}
+
+// statusOK checks that if a field's status bit is clear, its value bits are clear too.
+func statusOK(mb []byte, status, first, last int) bool {
+	return bits(mb, status, 1) == 1 || bits(mb, first, last-first+1) == 0
+}
+
+func isSet(mb []byte, bit int) bool { return bits(mb, bit, 1) == 1 }
+
+func isBDS20(mb []byte) bool {
+	if mb[0] != 0x20 { return false }
+	for i:=0; i<8; i++ {
+		if adsbCallsignChars[bits(mb, 9+i*6, 6)] == '#' { return false }
+	}
+	return true
+}
+
+func (m *Msg)decodeBDS20(mb []byte) {
+	cs := ""
+	for i:=0; i<8; i++ {
+		cs += string(adsbCallsignChars[bits(mb, 9+i*6, 6)])
+	}
+	m.Callsign, m.hasCallsign = strings.TrimSpace(cs), true
+}
+
+func isBDS40(mb []byte) bool {
+	for _,f := range [][3]int{ {1,2,13}, {14,15,26}, {27,28,39}, {48,49,51}, {54,55,56} } {
+		if !statusOK(mb, f[0], f[1], f[2]) { return false }
+	}
+	if bits(mb, 40, 8) != 0 || bits(mb, 52, 2) != 0 {
+		return false // Reserved
+	}
+	if isSet(mb, 1) && bits(mb, 2, 12)*16 > 50000 { return false }
+	if isSet(mb, 14) && bits(mb, 15, 12)*16 > 50000 { return false }
+	if isSet(mb, 27) {
+		if baro := float64(bits(mb, 28, 12))*0.1 + 800; baro < 900 || baro > 1100 { return false }
+	}
+	return isSet(mb, 1) || isSet(mb, 14) || isSet(mb, 27)
+}
+
+func (m *Msg)decodeBDS40(mb []byte) {
+	if isSet(mb, 1)  { m.SetSelectedAltitude(int64(bits(mb, 2, 12)) * 16) }
+	if isSet(mb, 14) { m.SetFMSSelectedAltitude(int64(bits(mb, 15, 12)) * 16) }
+	if isSet(mb, 27) { m.SetBaroSetting(float64(bits(mb, 28, 12))*0.1 + 800) }
+}
+
+func isBDS50(mb []byte) bool {
+	for _,f := range [][3]int{ {1,2,11}, {12,13,23}, {24,25,34}, {35,36,45}, {46,47,56} } {
+		if !statusOK(mb, f[0], f[1], f[2]) { return false }
+	}
+	if isSet(mb, 1) && math.Abs(float64(signed(mb, 2, 9)) * 45 / 256) > 50 {
+		return false
+	}
+	gs, tas := int64(bits(mb, 25, 10))*2, int64(bits(mb, 47, 10))*2
+	if gs > 600 || tas > 600 {
+		return false
+	}
+	if isSet(mb, 24) && isSet(mb, 46) && (gs - tas > 200 || tas - gs > 200) {
+		return false
+	}
+	return isSet(mb, 1) || isSet(mb, 12) || isSet(mb, 24) || isSet(mb, 46)
+}
+
+func (m *Msg)decodeBDS50(mb []byte) {
+	if isSet(mb, 1) {
+		m.SetRollAngle(float64(signed(mb, 2, 9)) * 45 / 256)
+	}
+	if isSet(mb, 12) {
+		trk := float64(signed(mb, 13, 10)) * 90 / 512
+		m.Track, m.hasTrack = (int64(math.Round(trk)) + 360) % 360, true
+	}
+	if isSet(mb, 24) {
+		m.GroundSpeed, m.hasGroundSpeed = int64(bits(mb, 25, 10)) * 2, true
+	}
+	if isSet(mb, 46) {
+		m.SetTrueAirspeed(int64(bits(mb, 47, 10)) * 2)
+	}
+}
+
+func isBDS60(mb []byte) bool {
+	for _,f := range [][3]int{ {1,2,12}, {13,14,23}, {24,25,34}, {35,36,45}, {46,47,56} } {
+		if !statusOK(mb, f[0], f[1], f[2]) { return false }
+	}
+	if isSet(mb, 13) {
+		if ias := bits(mb, 14, 10); ias == 0 || ias > 500 { return false }
+	}
+	if isSet(mb, 24) && float64(bits(mb, 25, 10)) * 2.048 / 512 > 1 {
+		return false
+	}
+	if isSet(mb, 35) && math.Abs(float64(signed(mb, 36, 9) * 32)) > 6000 { return false }
+	if isSet(mb, 46) && math.Abs(float64(signed(mb, 47, 9) * 32)) > 6000 { return false }
+
+	return isSet(mb, 1) || isSet(mb, 13) || isSet(mb, 24) || isSet(mb, 35) || isSet(mb, 46)
+}
+
+func (m *Msg)decodeBDS60(mb []byte) {
+	if isSet(mb, 1) {
+		hdg := float64(signed(mb, 2, 10)) * 90 / 512
+		if hdg < 0 { hdg += 360 }
+		m.SetMagneticHeading(hdg)
+	}
+	if isSet(mb, 13) { m.SetIndicatedAirspeed(int64(bits(mb, 14, 10))) }
+	if isSet(mb, 24) { m.SetMach(float64(bits(mb, 25, 10)) * 2.048 / 512) }
+
+	// Prefer the barometric rate, as that is what the rest of the system uses
+	if isSet(mb, 35) {
+		m.VerticalRate, m.hasVerticalRate = signed(mb, 36, 9) * 32, true
+	} else if isSet(mb, 46) {
+		m.VerticalRate, m.hasVerticalRate = signed(mb, 47, 9) * 32, true
+	}
+}
+
+// commBRegister works out which register the Comm-B data came from, or "" if it can't tell.
+func commBRegister(mb []byte) string {
+	empty := true
+	for _,b := range mb {
+		if b != 0 { empty = false }
+	}
+	if empty {
+		return ""
+	}
+	switch mb[0] {
+	case 0x10, 0x30: return "" // Data link capability, ACAS RA; not useful
+	}
+	if isBDS20(mb) { return "2,0" }
+
+	candidates := []string{}
+	if isBDS40(mb) { candidates = append(candidates, "4,0") }
+	if isBDS50(mb) { candidates = append(candidates, "5,0") }
+	if isBDS60(mb) { candidates = append(candidates, "6,0") }
+
+	if len(candidates) != 1 {
+		return "" // None match, or ambiguous
+	}
+	return candidates[0]
+}
+
+// decodeCommB fills in whatever it can from the 56 bit MB field of a DF20/21 reply.
+func (m *Msg)decodeCommB(mb []byte) {
+	switch commBRegister(mb) {
+	case "2,0": m.decodeBDS20(mb)
+	case "4,0": m.decodeBDS40(mb)
+	case "5,0": m.decodeBDS50(mb)
+	case "6,0": m.decodeBDS60(mb)
+	}
+}
@@ -0,0 +1,113 @@
+package adsb
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// Test vectors from pyModeS
+func TestCommB(t *testing.T) {
+	tm := time.Now()
+
+	m := Msg{}
+	if err := m.FromAVR("*A000029C85E42F313000007047D3;", tm); err != nil { t.Fatal(err) }
+	if !m.HasSelectedAltitude() || m.SelectedAltitude != 3008 {
+		t.Errorf("BDS 4,0: bad selected altitude %d", m.SelectedAltitude)
+	}
+	if !m.HasFMSSelectedAltitude() || m.FMSSelectedAltitude != 3008 {
+		t.Errorf("BDS 4,0: bad FMS selected altitude %d", m.FMSSelectedAltitude)
+	}
+	if !m.HasBaroSetting() || math.Abs(m.BaroSetting - 1020) > 0.01 {
+		t.Errorf("BDS 4,0: bad baro setting %f", m.BaroSetting)
+	}
+	if m.HasRollAngle() || m.HasIndicatedAirspeed() {
+		t.Errorf("BDS 4,0: decoded as other registers too")
+	}
+
+	m = Msg{}
+	if err := m.FromAVR("*A000139381951536E024D4CCF6B5;", tm); err != nil { t.Fatal(err) }
+	if !m.HasRollAngle() || math.Abs(m.RollAngle - 2.1) > 0.05 {
+		t.Errorf("BDS 5,0: bad roll %f", m.RollAngle)
+	}
+	if !m.HasTrack() || m.Track != 114 || !m.HasGroundSpeed() || m.GroundSpeed != 438 {
+		t.Errorf("BDS 5,0: bad track/speed %d/%d", m.Track, m.GroundSpeed)
+	}
+	if !m.HasTrueAirspeed() || m.TrueAirspeed != 424 {
+		t.Errorf("BDS 5,0: bad TAS %d", m.TrueAirspeed)
+	}
+
+	m = Msg{}
+	if err := m.FromAVR("*A00004128F39F91A7E27C46ADC21;", tm); err != nil { t.Fatal(err) }
+	if !m.HasMagneticHeading() || math.Abs(m.MagneticHeading - 42.715) > 0.01 {
+		t.Errorf("BDS 6,0: bad heading %f", m.MagneticHeading)
+	}
+	if !m.HasIndicatedAirspeed() || m.IndicatedAirspeed != 252 {
+		t.Errorf("BDS 6,0: bad IAS %d", m.IndicatedAirspeed)
+	}
+	if !m.HasMach() || math.Abs(m.Mach - 0.42) > 0.001 {
+		t.Errorf("BDS 6,0: bad Mach %f", m.Mach)
+	}
+	if !m.HasVerticalRate() || m.VerticalRate != -1920 {
+		t.Errorf("BDS 6,0: bad vertical rate %d", m.VerticalRate)
+	}
+}
+
+func TestCommBRegister(t *testing.T) {
+	tests := []struct {
+		MB   []byte
+		BDS  string
+	}{
+		{[]byte{0x20, 0x2C, 0xC3, 0x71, 0xC3, 0x2C, 0xE0}, "2,0"}, // KLM1023
+		{[]byte{0, 0, 0, 0, 0, 0, 0}, ""},
+		{[]byte{0x10, 0, 0, 0, 0, 0, 0}, ""},
+		{[]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, ""},
+	}
+	for i,test := range tests {
+		if bds := commBRegister(test.MB); bds != test.BDS {
+			t.Errorf("[%d] got %q, expected %q", i, bds, test.BDS)
+		}
+	}
+}
+
+func TestEHSUpdate(t *testing.T) {
+	e := EHS{}
+	e.SetSelectedAltitude(5000)
+	e.SetMach(0.78)
+
+	other := EHS{}
+	other.SetSelectedAltitude(3000)
+	other.SetIndicatedAirspeed(250)
+
+	e.Update(other)
+	if e.SelectedAltitude != 3000 || e.IndicatedAirspeed != 250 || !e.HasMach() || e.Mach != 0.78 {
+		t.Errorf("bad update: %+v", e)
+	}
+}
+
+func TestEHSUpdateAt(t *testing.T) {
+	t1 := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(10 * time.Second)
+
+	e := EHS{}
+	if !e.MachTime().IsZero() { t.Errorf("new EHS has a time") }
+
+	first := EHS{}
+	first.SetTrueAirspeed(450)
+	first.SetMach(0.78)
+	e.UpdateAt(first, t1)
+
+	second := EHS{}
+	second.SetTrueAirspeed(460)
+	e.UpdateAt(second, t2)
+
+	if e.TrueAirspeed != 460 || !e.TrueAirspeedTime().Equal(t2) {
+		t.Errorf("bad TAS: %d @ %s", e.TrueAirspeed, e.TrueAirspeedTime())
+	}
+	if e.Mach != 0.78 || !e.MachTime().Equal(t1) {
+		t.Errorf("bad Mach: %.2f @ %s", e.Mach, e.MachTime())
+	}
+	if !e.MagneticHeadingTime().IsZero() {
+		t.Errorf("unreported field has a time: %s", e.MagneticHeadingTime())
+	}
+}
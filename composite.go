@@ -15,9 +15,22 @@ type CompositeMsg struct {
 	Msg // Embedded stuct
 	// Real UTC timefields ??
 	ReceiverName  string // Some identifier for the ADS-B receiver that generated this data
+
+	// If non-empty, a validation stage thinks this message's position is bad, and says why
+	OutlierReason string
+
+	// This message wasn't observed; it was synthesized (e.g. interpolated) from other messages
+	IsSynthetic   bool
+
+	// Details about the aircraft, from an Enricher; nil if unknown. It may be shared between
+	// messages, so should not be modified.
+	Aircraft      *AircraftInfo
 }
 
-// Need to differentiate from 'real' ADSB messages, and synthetic MLAT messages
+func (cm CompositeMsg)IsOutlier() bool { return cm.OutlierReason != "" }
+
+// Need to differentiate from 'real' ADSB messages, and synthetic MLAT messages. (For the
+// finer-grained kind of system, e.g. TIS-B or Mode S, see Source.)
 func (cm CompositeMsg)DataSystem() string {
 	switch cm.Type {
 	case "MLAT": return "MLAT"
@@ -0,0 +1,141 @@
+package adsb
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/skypies/geo"
+)
+
+// ADS-B airborne positions are sent in Compact Position Reporting format: 17 bits each of
+// latitude & longitude, relative to a zone. Frames alternate between two zone layouts (even
+// and odd); given one of each, sent close together in time, the position can be recovered
+// without knowing where the receiver is. See DO-260B, A.1.7. We only handle airborne
+// positions; surface positions need a reference location.
+
+const (
+	cprMax        = 1 << 17
+	cprNZ         = 15
+	cprMaxPairAge = time.Second * 10 // Don't pair up frames further apart in time than this
+)
+
+// cprFrame holds the raw position from an airborne position message.
+type cprFrame struct {
+	lat,lon uint32
+	odd     bool
+	valid   bool
+}
+
+// cprNL is the number of longitude zones at the latitude.
+func cprNL(lat float64) int {
+	lat = math.Abs(lat)
+	switch {
+	case lat == 0:  return 59
+	case lat == 87: return 2
+	case lat > 87:  return 1
+	}
+	a := 1 - math.Cos(math.Pi / (2 * cprNZ))
+	b := math.Pow(math.Cos(math.Pi / 180 * lat), 2)
+	return int(math.Floor(2 * math.Pi / math.Acos(1 - a/b)))
+}
+
+// cprMod is a modulus that is always positive.
+func cprMod(a, b float64) float64 {
+	r := math.Mod(a, b)
+	if r < 0 { r += b }
+	return r
+}
+
+// cprDecode recovers the position from an even and an odd frame. The position is given for
+// the most recent of the two frames.
+func cprDecode(even, odd cprFrame, oddIsLatest bool) (geo.Latlong, error) {
+	latE, latO := float64(even.lat) / cprMax, float64(odd.lat) / cprMax
+	lonE, lonO := float64(even.lon) / cprMax, float64(odd.lon) / cprMax
+
+	j := math.Floor(59*latE - 60*latO + 0.5)
+	rLatE := 360.0 / 60 * (cprMod(j, 60) + latE)
+	rLatO := 360.0 / 59 * (cprMod(j, 59) + latO)
+	if rLatE >= 270 { rLatE -= 360 }
+	if rLatO >= 270 { rLatO -= 360 }
+	if math.Abs(rLatE) > 90 || math.Abs(rLatO) > 90 {
+		return geo.Latlong{}, fmt.Errorf("CPR frames give an impossible latitude (%.2f, %.2f)", rLatE, rLatO)
+	}
+
+	nl := cprNL(rLatE)
+	if nl != cprNL(rLatO) {
+		return geo.Latlong{}, fmt.Errorf("CPR frames straddle a longitude zone boundary")
+	}
+
+	lat, lon, ni := rLatE, lonE, math.Max(float64(nl), 1)
+	if oddIsLatest {
+		lat, lon, ni = rLatO, lonO, math.Max(float64(nl - 1), 1)
+	}
+	m := math.Floor(lonE * float64(nl - 1) - lonO * float64(nl) + 0.5)
+	long := 360 / ni * (cprMod(m, ni) + lon)
+	if long >= 180 { long -= 360 }
+
+	return geo.Latlong{Lat:lat, Long:long}, nil
+}
+
+// CPRDecoder recovers positions from streams of ADS-B airborne position messages (e.g. from
+// FromModeS or FromBeast), by pairing up even and odd frames from each aircraft. It is not safe
+// for concurrent use.
+type CPRDecoder struct {
+	last       map[IcaoId]*cprPair
+	lastAgeOut time.Time
+}
+
+type cprPair struct {
+	even,odd         cprFrame
+	evenTime,oddTime time.Time
+}
+
+func NewCPRDecoder() *CPRDecoder {
+	return &CPRDecoder{last: map[IcaoId]*cprPair{}}
+}
+
+func (d *CPRDecoder)ageOut(now time.Time) {
+	if now.Sub(d.lastAgeOut) < time.Second && d.lastAgeOut.Sub(now) < time.Second { return }
+	d.lastAgeOut = now
+
+	for id,p := range d.last {
+		if now.Sub(p.evenTime) > cprMaxPairAge && now.Sub(p.oddTime) > cprMaxPairAge {
+			delete(d.last, id)
+		}
+	}
+}
+
+// Decode fills in the message's position, if it is an airborne position message and we have
+// a recent frame of the other parity from the same aircraft. It returns true if it did.
+func (d *CPRDecoder)Decode(m *Msg) bool {
+	if !m.cpr.valid || m.Icao24 == "" {
+		return false
+	}
+	t := m.GeneratedTimestampUTC
+	d.ageOut(t)
+
+	if d.last == nil { d.last = map[IcaoId]*cprPair{} }
+	p,exists := d.last[m.Icao24]
+	if !exists {
+		p = &cprPair{}
+		d.last[m.Icao24] = p
+	}
+
+	var other time.Time
+	if m.cpr.odd {
+		p.odd, p.oddTime, other = m.cpr, t, p.evenTime
+	} else {
+		p.even, p.evenTime, other = m.cpr, t, p.oddTime
+	}
+	if dt := t.Sub(other); other.IsZero() || dt > cprMaxPairAge || dt < -cprMaxPairAge {
+		return false
+	}
+
+	pos,err := cprDecode(p.even, p.odd, m.cpr.odd)
+	if err != nil {
+		return false
+	}
+	m.Position, m.hasPosition = pos, true
+	return true
+}
@@ -0,0 +1,43 @@
+package adsb
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCPRDecoder(t *testing.T) {
+	// From The 1090MHz Riddle: an even frame, followed by an odd one
+	tm := time.Now()
+	even, odd := Msg{}, Msg{}
+	if err := even.FromAVR("*8D40621D58C382D690C8AC2863A7;", tm); err != nil { t.Fatal(err) }
+	if err := odd.FromAVR("*8D40621D58C386435CC412692AD6;", tm.Add(time.Second)); err != nil { t.Fatal(err) }
+
+	d := NewCPRDecoder()
+	if d.Decode(&even) || even.HasPosition() {
+		t.Errorf("decoded a position from a single frame")
+	}
+	if !d.Decode(&odd) || !odd.HasPosition() {
+		t.Fatalf("did not decode pair")
+	}
+	if math.Abs(odd.Position.Lat - 52.2658) > 0.001 || math.Abs(odd.Position.Long - 3.9389) > 0.001 {
+		t.Errorf("bad position: %s", odd.Position)
+	}
+
+	// Frames too far apart in time don't pair up
+	d = NewCPRDecoder()
+	odd.GeneratedTimestampUTC = tm.Add(time.Minute)
+	d.Decode(&even)
+	if d.Decode(&odd) {
+		t.Errorf("paired frames a minute apart")
+	}
+}
+
+func TestCPRBadLatitude(t *testing.T) {
+	// A (corrupt) pair whose latitude zone index puts them both outside +/-90
+	even := cprFrame{lat:44564, lon:0, valid:true}
+	odd := cprFrame{lat:0, lon:0, odd:true, valid:true}
+	if pos,err := cprDecode(even, odd, true); err == nil {
+		t.Errorf("decoded an impossible latitude: %s", pos)
+	}
+}
@@ -0,0 +1,218 @@
+/* Package flightphase labels each point of a track with a phase of flight,
+and detects takeoffs and landings.
+
+It works from the Altitude, VerticalRate, GroundSpeed and IsOnGround
+fields of the composite messages, using a small set of configurable
+thresholds.
+
+Sample usage:
+
+    c := flightphase.NewClassifier()
+    phases := c.Classify(track.Messages)   // phases[i] is the phase of track.Messages[i]
+    for _,e := range c.Events(track.Messages) {
+      fmt.Printf("%s\n", e)
+    }
+
+*/
+package flightphase
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/geo"
+)
+
+// {{{ Phase
+
+type Phase int
+const (
+	Unknown Phase = iota
+	Ground
+	Takeoff
+	Climb
+	Cruise
+	Descent
+	Approach
+	Landing
+)
+
+func (p Phase)String() string {
+	switch p {
+	case Ground:   return "ground"
+	case Takeoff:  return "takeoff"
+	case Climb:    return "climb"
+	case Cruise:   return "cruise"
+	case Descent:  return "descent"
+	case Approach: return "approach"
+	case Landing:  return "landing"
+	default:       return "unknown"
+	}
+}
+
+func (p Phase)IsAirborne() bool { return p != Unknown && p != Ground }
+
+// }}}
+// {{{ Classifier{}
+
+// Classifier holds the thresholds used to decide the phases.
+type Classifier struct {
+	MaxTaxiSpeed     int64         // Knots; anything slower than this is on the ground ...
+	MaxTaxiAltitude  int64         // Feet; ... if it is also below this (else it's e.g. a helicopter)
+	MinSpell         time.Duration // Briefer spells on the ground (or in the air) are glitches
+	ClimbRate        int64         // Feet/min; climbing faster than this is a climb
+	DescentRate      int64         // Feet/min; descending faster than this is a descent
+	ApproachAltitude int64         // Feet; descents (and level-offs) below this are approaches
+	TakeoffWindow    time.Duration // Airborne points this soon after liftoff are the takeoff
+	LandingWindow    time.Duration // Airborne points this soon before touchdown are the landing
+}
+
+func NewClassifier() Classifier {
+	return Classifier{
+		MaxTaxiSpeed:     40,
+		MaxTaxiAltitude:  1500,
+		MinSpell:         time.Second * 10,
+		ClimbRate:        300,
+		DescentRate:      300,
+		ApproachAltitude: 4000,
+		TakeoffWindow:    time.Second * 60,
+		LandingWindow:    time.Second * 60,
+	}
+}
+
+// }}}
+
+// {{{ Classifier.isOnGround
+
+// isOnGround trusts the ground flag; failing that, slow & low counts as taxiing. Altitudes are
+// pressure altitudes, so MaxTaxiAltitude needs to allow for the weather, and high airfields
+// will have to rely on the flag.
+func (c Classifier)isOnGround(m *adsb.CompositeMsg) bool {
+	if m.IsOnGround { return true }
+	return m.GroundSpeed > 0 && m.GroundSpeed < c.MaxTaxiSpeed && m.Altitude < c.MaxTaxiAltitude
+}
+
+// }}}
+// {{{ Classifier.debounce
+
+// debounce flips spells on the ground (or in the air) that are shorter than MinSpell, and that
+// have the other state on both sides; they're more likely to be a glitch in the ground flag
+// (or a slow spell in a headwind) than a real touchdown. The spells at either end of the
+// track are left alone, as we can't see how long they really were.
+func (c Classifier)debounce(msgs []*adsb.CompositeMsg, ground []bool) {
+	for i:=0; i<len(msgs); {
+		j := i
+		for j+1 < len(msgs) && ground[j+1] == ground[i] { j++ }
+
+		if i > 0 && j < len(msgs)-1 &&
+			msgs[j].GeneratedTimestampUTC.Sub(msgs[i].GeneratedTimestampUTC) < c.MinSpell {
+			for k:=i; k<=j; k++ { ground[k] = !ground[k] }
+		}
+		i = j+1
+	}
+}
+
+// }}}
+// {{{ Classifier.Classify
+
+// Classify returns the phase of each message; the messages should be a single flight, in
+// time order.
+func (c Classifier)Classify(msgs []*adsb.CompositeMsg) []Phase {
+	phases := make([]Phase, len(msgs))
+
+	ground := make([]bool, len(msgs))
+	for i,m := range msgs {
+		ground[i] = c.isOnGround(m)
+	}
+	c.debounce(msgs, ground)
+
+	// First pass: ground vs. air, and the basic airborne phases
+	for i,m := range msgs {
+		vr := m.VerticalRate
+		switch {
+		case ground[i]:                            phases[i] = Ground
+		case vr >= c.ClimbRate:                    phases[i] = Climb
+		case vr <= -c.DescentRate && m.Altitude < c.ApproachAltitude: phases[i] = Approach
+		case vr <= -c.DescentRate:                 phases[i] = Descent
+		case m.Altitude < c.ApproachAltitude && i > 0 &&
+			(phases[i-1] == Approach || phases[i-1] == Descent): phases[i] = Approach
+		default:                                   phases[i] = Cruise
+		}
+	}
+
+	// Second pass: airborne points close to a liftoff or touchdown
+	for i:=1; i<len(msgs); i++ {
+		if phases[i-1] == Ground && phases[i].IsAirborne() {
+			liftoff := msgs[i].GeneratedTimestampUTC
+			for j:=i; j<len(msgs) && phases[j].IsAirborne(); j++ {
+				if msgs[j].GeneratedTimestampUTC.Sub(liftoff) > c.TakeoffWindow { break }
+				phases[j] = Takeoff
+			}
+		}
+		if phases[i-1].IsAirborne() && phases[i] == Ground {
+			touchdown := msgs[i].GeneratedTimestampUTC
+			for j:=i-1; j>=0 && phases[j].IsAirborne(); j-- {
+				if touchdown.Sub(msgs[j].GeneratedTimestampUTC) > c.LandingWindow { break }
+				phases[j] = Landing
+			}
+		}
+	}
+
+	return phases
+}
+
+// }}}
+
+// {{{ Event{}
+
+type EventType int
+const (
+	TakeoffEvent EventType = iota
+	LandingEvent
+)
+
+func (et EventType)String() string {
+	if et == TakeoffEvent { return "takeoff" }
+	return "landing"
+}
+
+// Event is a liftoff or a touchdown. The time and position are those of the first airborne
+// point (for a takeoff), or of the first point on the ground (for a landing).
+type Event struct {
+	Type     EventType
+	Time     time.Time
+	Position geo.Latlong
+	Msg      *adsb.CompositeMsg
+}
+
+func (e Event)String() string {
+	return fmt.Sprintf("%s %s[%s] at %s @ %s", e.Type, e.Msg.Icao24, e.Msg.Callsign, e.Position, e.Time)
+}
+
+// }}}
+// {{{ Classifier.Events
+
+// Events returns the takeoffs and landings in the messages.
+func (c Classifier)Events(msgs []*adsb.CompositeMsg) []Event {
+	phases := c.Classify(msgs)
+	events := []Event{}
+	for i:=1; i<len(msgs); i++ {
+		if phases[i-1] == Ground && phases[i].IsAirborne() {
+			events = append(events, Event{TakeoffEvent, msgs[i].GeneratedTimestampUTC, msgs[i].Position, msgs[i]})
+		} else if phases[i-1].IsAirborne() && phases[i] == Ground {
+			events = append(events, Event{LandingEvent, msgs[i].GeneratedTimestampUTC, msgs[i].Position, msgs[i]})
+		}
+	}
+	return events
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
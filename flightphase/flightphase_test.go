@@ -0,0 +1,84 @@
+// go test -v github.com/skypies/adsb/flightphase
+package flightphase
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skypies/adsb"
+)
+
+func TestClassify(t *testing.T) {
+	base := time.Date(2015, 11, 27, 21, 0, 0, 0, time.UTC)
+	points := []struct{
+		secs     int
+		alt, vr, speed int64
+		onGround bool
+		expected Phase
+	}{
+		{   0,     0,     0,  15, true,  Ground},
+		{  30,     0,     0, 140, true,  Ground},
+		{  40,   200,  2000, 160, false, Takeoff},
+		{  90,  2000,  2000, 200, false, Takeoff},
+		{ 200,  8000,  2000, 280, false, Climb},
+		{ 600, 35000,     0, 450, false, Cruise},
+		{1200, 35000,     0, 450, false, Cruise},
+		{1500, 20000, -1500, 350, false, Descent},
+		{1800,  3500,  -800, 200, false, Approach},
+		{1850,  3000,     0, 180, false, Approach},
+		{1900,  1500,  -700, 150, false, Landing},
+		{1950,   100,  -700, 140, false, Landing},
+		{1960,     0,     0, 120, true,  Ground},
+		{2100,     0,     0,  10, false, Ground},
+	}
+
+	msgs := []*adsb.CompositeMsg{}
+	for _,p := range points {
+		cm := adsb.CompositeMsg{}
+		cm.Icao24 = "A81BD0"
+		cm.GeneratedTimestampUTC = base.Add(time.Duration(p.secs) * time.Second)
+		cm.Altitude, cm.VerticalRate, cm.GroundSpeed, cm.IsOnGround = p.alt, p.vr, p.speed, p.onGround
+		msgs = append(msgs, &cm)
+	}
+
+	c := NewClassifier()
+	for i,phase := range c.Classify(msgs) {
+		if phase != points[i].expected {
+			t.Errorf("[%d] expected %s, got %s", i, points[i].expected, phase)
+		}
+	}
+
+	events := c.Events(msgs)
+	if len(events) != 2 { t.Fatalf("expected 2 events, saw %d", len(events)) }
+	if events[0].Type != TakeoffEvent || !events[0].Time.Equal(msgs[2].GeneratedTimestampUTC) {
+		t.Errorf("bad takeoff: %s", events[0])
+	}
+	if events[1].Type != LandingEvent || !events[1].Time.Equal(msgs[12].GeneratedTimestampUTC) {
+		t.Errorf("bad landing: %s", events[1])
+	}
+}
+
+func TestGroundGlitches(t *testing.T) {
+	base := time.Date(2015, 11, 27, 21, 0, 0, 0, time.UTC)
+	msgs := []*adsb.CompositeMsg{}
+	add := func(secs int, alt, speed int64, onGround bool) {
+		cm := adsb.CompositeMsg{}
+		cm.Icao24 = "A81BD0"
+		cm.GeneratedTimestampUTC = base.Add(time.Duration(secs) * time.Second)
+		cm.Altitude, cm.GroundSpeed, cm.IsOnGround = alt, speed, onGround
+		msgs = append(msgs, &cm)
+	}
+
+	add(0,  3000, 90, false)
+	add(10, 3000, 30, false) // Slow, but not low; a helicopter, or a strong headwind
+	add(20, 3000, 90, false)
+	add(21, 3000, 90, true)  // Glitch
+	add(22, 3000, 90, true)
+	add(23, 3000, 90, false)
+
+	c := NewClassifier()
+	for i,phase := range c.Classify(msgs) {
+		if phase == Ground { t.Errorf("[%d] classified as on the ground", i) }
+	}
+	if events := c.Events(msgs); len(events) != 0 { t.Errorf("false events: %v", events) }
+}
@@ -0,0 +1,223 @@
+/* Package geofence looks for aircraft entering and leaving volumes of airspace.
+
+The volumes can be polygons (Prism) or circles (Cylinder), each with a
+floor and ceiling. A Fence watches a stream of composite messages, and
+reports an Event whenever an aircraft crosses into or out of a volume;
+the time of the crossing is interpolated between the messages either
+side of it.
+
+Sample usage:
+
+    f := geofence.NewFence(geofence.Cylinder{
+      Name: "home", Center: myHouse, RadiusKM: 2, AltitudeBand: geofence.AltitudeBand{Ceiling:5000},
+    })
+    go f.Run(inChan, outChan, eventChan)
+
+*/
+package geofence
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/skypies/adsb"
+)
+
+// {{{ Event{}
+
+type EventType int
+const (
+	Entry EventType = iota
+	Exit
+)
+
+func (et EventType)String() string {
+	if et == Entry { return "entry" }
+	return "exit"
+}
+
+// An Event is an aircraft crossing the boundary of a volume.
+type Event struct {
+	Type     EventType
+	Volume   Volume
+	Icao24   adsb.IcaoId
+	Callsign string
+	Time     time.Time
+	Msg      *adsb.CompositeMsg // Aircraft state at the crossing (usually interpolated)
+}
+
+func (e Event)String() string {
+	return fmt.Sprintf("%s[%s] %s %s @ %s", e.Icao24, e.Callsign, e.Type, e.Volume, e.Time)
+}
+
+// }}}
+// {{{ Fence{}
+
+type aircraft struct {
+	last     *adsb.CompositeMsg
+	lastSeen time.Time
+	inside   []bool // Indexed like Fence.Volumes
+}
+
+// Fence tracks which aircraft are inside which volumes. It is not safe for concurrent use.
+type Fence struct {
+	Volumes      []Volume      // Can be appended to as traffic flows
+
+	MaxQuietTime time.Duration // Forget aircraft that send no messages for this long
+
+	aircraft     map[adsb.IcaoId]*aircraft
+	lastAgeOut   time.Time
+}
+
+func NewFence(volumes ...Volume) *Fence {
+	return &Fence{
+		Volumes:      volumes,
+		MaxQuietTime: time.Second * 360,
+		aircraft:     map[adsb.IcaoId]*aircraft{},
+	}
+}
+
+// }}}
+
+// {{{ crossingTime
+
+// crossingTime bisects the line between two messages to find where the volume boundary
+// is crossed, and returns the interpolated state there.
+func crossingTime(v Volume, from, to *adsb.CompositeMsg) *adsb.CompositeMsg {
+	wasIn := v.Contains(from.Position, from.Altitude)
+	t0,t1 := from.GeneratedTimestampUTC, to.GeneratedTimestampUTC
+
+	for i:=0; i<20 && t1.Sub(t0) > time.Millisecond; i++ {
+		mid := t0.Add(t1.Sub(t0) / 2)
+		m := adsb.InterpolateComposite(from, to, mid)
+		if v.Contains(m.Position, m.Altitude) == wasIn {
+			t0 = mid
+		} else {
+			t1 = mid
+		}
+	}
+
+	return adsb.InterpolateComposite(from, to, t1)
+}
+
+// }}}
+// {{{ Fence.ageOut
+
+func (f *Fence)ageOut() {
+	if time.Since(f.lastAgeOut) < time.Second { return } // Only run once per second.
+	f.lastAgeOut = time.Now()
+
+	for id,a := range f.aircraft {
+		if time.Since(a.lastSeen) >= f.MaxQuietTime {
+			delete(f.aircraft, id)
+		}
+	}
+}
+
+// }}}
+// {{{ Fence.Add
+
+// Add updates the fence with a new message, and returns any events it triggers. The first
+// message from an aircraft that is already inside a volume generates an entry event at that
+// message. Messages without positions are ignored.
+func (f *Fence)Add(cm *adsb.CompositeMsg) []Event {
+	f.ageOut()
+	if !cm.HasPosition() { return nil }
+
+	if f.aircraft == nil { f.aircraft = map[adsb.IcaoId]*aircraft{} }
+	a,exists := f.aircraft[cm.Icao24]
+	if !exists {
+		a = &aircraft{}
+		f.aircraft[cm.Icao24] = a
+	}
+
+	// Volumes added since the aircraft's last message are treated like a first message; we
+	// don't know where it was, so there's no crossing to interpolate.
+	known := len(a.inside)
+	for len(a.inside) < len(f.Volumes) {
+		a.inside = append(a.inside, false)
+	}
+
+	events := []Event{}
+	for i,v := range f.Volumes {
+		inside := v.Contains(cm.Position, cm.Altitude)
+		if inside == a.inside[i] { continue }
+
+		e := Event{Type:Exit, Volume:v, Icao24:cm.Icao24, Callsign:cm.Callsign, Msg:cm}
+		if inside { e.Type = Entry }
+		if i < known && a.last != nil && cm.GeneratedTimestampUTC.After(a.last.GeneratedTimestampUTC) {
+			e.Msg = crossingTime(v, a.last, cm)
+		}
+		e.Time = e.Msg.GeneratedTimestampUTC
+
+		events = append(events, e)
+		a.inside[i] = inside
+	}
+
+	a.last = cm
+	a.lastSeen = time.Now()
+	return events
+}
+
+// }}}
+// {{{ Fence.IsInside
+
+// IsInside returns true if the aircraft was in any of the volumes, as of its last message.
+func (f *Fence)IsInside(id adsb.IcaoId) bool {
+	if a,exists := f.aircraft[id]; exists {
+		for _,in := range a.inside {
+			if in { return true }
+		}
+	}
+	return false
+}
+
+// }}}
+// {{{ Fence.Run
+
+// Run reads slices of messages from the input channel; messages inside any of the volumes
+// are sent to the output channel, and crossings are sent to the event channel. Either
+// output channel can be nil. Both are closed when the input channel is closed.
+func (f *Fence)Run(in <-chan []*adsb.CompositeMsg, out chan<- []*adsb.CompositeMsg, events chan<- Event) {
+	for msgs := range in {
+		inside := []*adsb.CompositeMsg{}
+		for _,cm := range msgs {
+			for _,e := range f.Add(cm) {
+				if events != nil { events <- e }
+			}
+			if cm.HasPosition() && f.IsInside(cm.Icao24) {
+				inside = append(inside, cm)
+			}
+		}
+		if out != nil && len(inside) > 0 {
+			out <- inside
+		}
+	}
+	if out != nil { close(out) }
+	if events != nil { close(events) }
+}
+
+// }}}
+
+// {{{ TrackEvents
+
+// TrackEvents returns all the entries & exits for a single aircraft's track (which should be
+// in time order).
+func TrackEvents(msgs []*adsb.CompositeMsg, volumes ...Volume) []Event {
+	f := NewFence(volumes...)
+	events := []Event{}
+	for _,cm := range msgs {
+		events = append(events, f.Add(cm)...)
+	}
+	return events
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
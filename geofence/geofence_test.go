@@ -0,0 +1,109 @@
+// go test -v github.com/skypies/adsb/geofence
+package geofence
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/geo"
+)
+
+func composites(sbs string) (ret []*adsb.CompositeMsg) {
+	scanner := bufio.NewScanner(strings.NewReader(sbs))
+	for scanner.Scan() {
+		m := adsb.Msg{}
+		if err := m.FromSBS1(scanner.Text()); err != nil {
+			panic(err)
+		}
+		ret = append(ret, &adsb.CompositeMsg{Msg:m})
+	}
+	return
+}
+
+// Flying due north along -122.0, descending from 5000 to 3000ft
+var northboundSBS = `MSG,3,1,1,A81BD0,1,2015/11/27,21:31:00.000,2015/11/27,21:31:00.000,,5000,200,0,36.90000,-122.00000,-600,,,,,0
+MSG,3,1,1,A81BD0,1,2015/11/27,21:31:10.000,2015/11/27,21:31:10.000,,4500,200,0,36.91000,-122.00000,-600,,,,,0
+MSG,3,1,1,A81BD0,1,2015/11/27,21:31:20.000,2015/11/27,21:31:20.000,,4000,200,0,36.92000,-122.00000,-600,,,,,0
+MSG,3,1,1,A81BD0,1,2015/11/27,21:31:30.000,2015/11/27,21:31:30.000,,3500,200,0,36.93000,-122.00000,-600,,,,,0
+MSG,3,1,1,A81BD0,1,2015/11/27,21:31:40.000,2015/11/27,21:31:40.000,,3000,200,0,36.94000,-122.00000,-600,,,,,0`
+
+func TestVolumes(t *testing.T) {
+	box := Prism{
+		Name: "box",
+		Points: []geo.Latlong{{Lat:36.0, Long:-123.0}, {Lat:37.0, Long:-123.0}, {Lat:37.0, Long:-121.0},
+			{Lat:36.0, Long:-121.0}},
+		AltitudeBand: AltitudeBand{Floor:1000, Ceiling:5000},
+	}
+	if !box.Contains(geo.Latlong{Lat:36.5, Long:-122.0}, 3000) { t.Errorf("box should contain") }
+	if box.Contains(geo.Latlong{Lat:36.5, Long:-122.0}, 6000) { t.Errorf("box too tall") }
+	if box.Contains(geo.Latlong{Lat:37.5, Long:-122.0}, 3000) { t.Errorf("box too big") }
+
+	c := Cylinder{Name: "circle", Center: geo.Latlong{Lat:36.5, Long:-122.0}, RadiusKM: 10}
+	if !c.Contains(geo.Latlong{Lat:36.55, Long:-122.0}, 30000) { t.Errorf("circle should contain") }
+	if c.Contains(geo.Latlong{Lat:36.7, Long:-122.0}, 3000) { t.Errorf("circle too big") }
+}
+
+func TestTrackEvents(t *testing.T) {
+	msgs := composites(northboundSBS)
+
+	// A circle centered on the 3rd point, radius ~1.1KM; and a shelf with a 4200ft ceiling
+	c := Cylinder{Name: "circle", Center: geo.Latlong{Lat:36.92, Long:-122.0}, RadiusKM: 1.112}
+	shelf := Cylinder{Name: "shelf", Center: geo.Latlong{Lat:36.92, Long:-122.0}, RadiusKM: 100,
+		AltitudeBand: AltitudeBand{Floor:0, Ceiling:4200}}
+
+	events := TrackEvents(msgs, c, shelf)
+	if len(events) != 3 { t.Fatalf("expected 3 events, saw %d: %v", len(events), events) }
+
+	base := msgs[0].GeneratedTimestampUTC
+	expected := []struct{
+		Type EventType
+		Volume string
+		Offset time.Duration
+	}{
+		{Entry, "circle", 10 * time.Second},
+		{Entry, "shelf",  16 * time.Second},
+		{Exit,  "circle", 30 * time.Second},
+	}
+	for i,e := range events {
+		exp := expected[i]
+		if e.Type != exp.Type || !strings.HasPrefix(e.Volume.String(), exp.Volume) {
+			t.Errorf("[%d] wrong event: %s", i, e)
+		}
+		if delta := e.Time.Sub(base) - exp.Offset; delta > time.Millisecond*50 || delta < -time.Millisecond*50 {
+			t.Errorf("[%d] wrong time (off by %s): %s", i, delta, e)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	f := NewFence(Cylinder{Name: "circle", Center: geo.Latlong{Lat:36.92, Long:-122.0}, RadiusKM: 1.5})
+	in := make(chan []*adsb.CompositeMsg, 1)
+	out := make(chan []*adsb.CompositeMsg, 1)
+	events := make(chan Event, 10)
+
+	in <- composites(northboundSBS)
+	close(in)
+	f.Run(in, out, events)
+
+	if msgs := <-out; len(msgs) != 3 { t.Errorf("expected 3 msgs inside, saw %d", len(msgs)) }
+	if len(events) != 2 { t.Errorf("expected 2 events, saw %d", len(events)) }
+}
+
+func TestAddVolume(t *testing.T) {
+	msgs := composites(northboundSBS)
+	f := NewFence(Cylinder{Name: "circle", Center: geo.Latlong{Lat:36.92, Long:-122.0}, RadiusKM: 1.5})
+	f.Add(msgs[0])
+	f.Add(msgs[1]) // Enters the circle
+
+	// The aircraft is already inside the new volume
+	f.Volumes = append(f.Volumes, Cylinder{Name: "big", Center: geo.Latlong{Lat:36.92, Long:-122.0}, RadiusKM: 50})
+	events := f.Add(msgs[2])
+	if len(events) != 1 { t.Fatalf("expected 1 event, saw %d: %v", len(events), events) }
+	if e := events[0]; e.Type != Entry || e.Msg != msgs[2] {
+		t.Errorf("bad entry to new volume: %s", e)
+	}
+	if events := f.Add(msgs[3]); len(events) != 0 { t.Errorf("unexpected events: %v", events) }
+}
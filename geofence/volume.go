@@ -0,0 +1,70 @@
+package geofence
+
+import (
+	"fmt"
+
+	"github.com/skypies/geo"
+)
+
+// Volume is a region of airspace.
+type Volume interface {
+	Contains(pos geo.Latlong, altitude int64) bool
+	String() string
+}
+
+// AltitudeBand is a range of altitudes (in feet), inclusive. A zero Ceiling means unlimited.
+type AltitudeBand struct {
+	Floor   int64
+	Ceiling int64
+}
+
+func (b AltitudeBand)String() string {
+	if b.Ceiling == 0 { return fmt.Sprintf("%d+ft", b.Floor) }
+	return fmt.Sprintf("%d-%dft", b.Floor, b.Ceiling)
+}
+
+func (b AltitudeBand)Contains(altitude int64) bool {
+	return altitude >= b.Floor && (b.Ceiling == 0 || altitude <= b.Ceiling)
+}
+
+// Prism is a polygon, extruded between two altitudes (e.g. a class B shelf).
+type Prism struct {
+	Name    string
+	Points  []geo.Latlong // The polygon's vertices; it is closed automatically
+	AltitudeBand
+}
+
+func (p Prism)String() string {
+	return fmt.Sprintf("%s [%d-gon, %s]", p.Name, len(p.Points), p.AltitudeBand)
+}
+
+// Contains does a ray-casting point-in-polygon test, treating lat/long as planar.
+func (p Prism)Contains(pos geo.Latlong, altitude int64) bool {
+	if !p.AltitudeBand.Contains(altitude) { return false }
+
+	inside := false
+	for i,j := 0,len(p.Points)-1; i<len(p.Points); j,i = i,i+1 {
+		a,b := p.Points[i], p.Points[j]
+		if (a.Lat > pos.Lat) != (b.Lat > pos.Lat) &&
+			pos.Long < (b.Long-a.Long) * (pos.Lat-a.Lat) / (b.Lat-a.Lat) + a.Long {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Cylinder is a circle, extruded between two altitudes.
+type Cylinder struct {
+	Name     string
+	Center   geo.Latlong
+	RadiusKM float64
+	AltitudeBand
+}
+
+func (c Cylinder)String() string {
+	return fmt.Sprintf("%s [%s, r=%.1fKM, %s]", c.Name, c.Center, c.RadiusKM, c.AltitudeBand)
+}
+
+func (c Cylinder)Contains(pos geo.Latlong, altitude int64) bool {
+	return c.AltitudeBand.Contains(altitude) && c.Center.DistKM(pos) <= c.RadiusKM
+}
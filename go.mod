@@ -2,4 +2,7 @@ module github.com/skypies/adsb
 
 go 1.13
 
-require github.com/skypies/util v0.1.19 // indirect
+require (
+	github.com/skypies/geo v0.0.0-20180901233721-9d4f211f3066
+	github.com/skypies/util v0.1.19 // indirect
+)
@@ -0,0 +1,76 @@
+package adsb
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A masked IcaoId has a '~' prefix; dump1090 et al use it for addresses that aren't real
+// ICAO 24-bit addresses (e.g. TIS-B targets, identified by some other kind of address).
+const maskedPrefix = "~"
+
+var icaoIdRegexp = regexp.MustCompile(`^~?[0-9A-Fa-f]{6}$`)
+
+// IcaoIdFromUint32 formats a 24-bit address as an IcaoId.
+func IcaoIdFromUint32(addr uint32) IcaoId {
+	return IcaoId(fmt.Sprintf("%06X", addr & 0xFFFFFF))
+}
+
+// IsValid returns true if the IcaoId is six hex digits, with an optional masked prefix.
+func (id IcaoId)IsValid() bool { return icaoIdRegexp.MatchString(string(id)) }
+
+func (id IcaoId)IsMasked() bool { return strings.HasPrefix(string(id), maskedPrefix) }
+
+// IsNonICAO returns true for addresses that weren't allocated by ICAO; see IsMasked.
+func (id IcaoId)IsNonICAO() bool { return id.IsMasked() }
+
+// Uint32 returns the 24-bit address (ignoring any masked prefix).
+func (id IcaoId)Uint32() (uint32, error) {
+	if !id.IsValid() {
+		return 0, fmt.Errorf("IcaoId '%s' is not valid", string(id))
+	}
+	u,err := strconv.ParseUint(strings.TrimPrefix(string(id), maskedPrefix), 16, 32)
+	return uint32(u), err
+}
+
+// icaoRange is a block of addresses, inclusive.
+type icaoRange struct {
+	Start,End uint32
+	Name      string
+}
+
+func (r icaoRange)contains(addr uint32) bool { return addr >= r.Start && addr <= r.End }
+
+// lookup finds the narrowest range containing the address.
+func lookup(table []icaoRange, id IcaoId) (icaoRange, bool) {
+	addr,err := id.Uint32()
+	if err != nil || id.IsNonICAO() {
+		return icaoRange{}, false
+	}
+
+	best,found := icaoRange{}, false
+	for _,r := range table {
+		if r.contains(addr) && (!found || r.End-r.Start < best.End-best.Start) {
+			best,found = r,true
+		}
+	}
+	return best, found
+}
+
+// Country returns the state (or organization) that the address block was allocated to, as
+// per ICAO Annex 10 Vol III; or the empty string, if not known.
+func (id IcaoId)Country() string {
+	if r,found := lookup(icaoAllocations, id); found {
+		return r.Name
+	}
+	return ""
+}
+
+// IsMilitary returns true if the address is in a block known to be used by military
+// aircraft. The list of blocks is not exhaustive.
+func (id IcaoId)IsMilitary() bool {
+	_,found := lookup(icaoMilitaryBlocks, id)
+	return found
+}
@@ -0,0 +1,80 @@
+package adsb
+
+import "testing"
+
+func TestIcaoIdValidity(t *testing.T) {
+	tests := []struct {
+		Id      IcaoId
+		Valid   bool
+		Masked  bool
+	}{
+		{"A81BD0",  true,  false},
+		{"a81bd0",  true,  false},
+		{"~A81BD0", true,  true},
+		{"A81BD",   false, false},
+		{"A81BD0F", false, false},
+		{"G81BD0",  false, false},
+		{"",        false, false},
+	}
+
+	for i,test := range tests {
+		if actual := test.Id.IsValid(); actual != test.Valid {
+			t.Errorf("[%d] %q: IsValid %v, expected %v", i, test.Id, actual, test.Valid)
+		}
+		if actual := test.Id.IsMasked(); actual != test.Masked {
+			t.Errorf("[%d] %q: IsMasked %v, expected %v", i, test.Id, actual, test.Masked)
+		}
+		if actual := test.Id.IsNonICAO(); actual != test.Masked {
+			t.Errorf("[%d] %q: IsNonICAO %v, expected %v", i, test.Id, actual, test.Masked)
+		}
+	}
+}
+
+func TestIcaoIdUint32(t *testing.T) {
+	for _,addr := range []uint32{0x000001, 0xA81BD0, 0xFFFFFF} {
+		id := IcaoIdFromUint32(addr)
+		if back,err := id.Uint32(); err != nil || back != addr {
+			t.Errorf("%06X: round trip gave %q -> %06X (%v)", addr, id, back, err)
+		}
+	}
+
+	if id := IcaoIdFromUint32(0xA81BD0); id != "A81BD0" {
+		t.Errorf("IcaoIdFromUint32 gave %q", id)
+	}
+	if addr,err := IcaoId("~A81BD0").Uint32(); err != nil || addr != 0xA81BD0 {
+		t.Errorf("masked Uint32 gave %06X (%v)", addr, err)
+	}
+	if _,err := IcaoId("nope").Uint32(); err == nil {
+		t.Errorf("invalid IcaoId did not error")
+	}
+}
+
+func TestIcaoIdCountry(t *testing.T) {
+	tests := []struct {
+		Id       IcaoId
+		Country  string
+		Military bool
+	}{
+		{"A81BD0",  "United States",  false},
+		{"AE1234",  "United States",  true},
+		{"C0174F",  "Canada",         false},
+		{"400F01",  "United Kingdom", false},
+		{"43C6F1",  "United Kingdom", true},
+		{"780A3B",  "China",          false},
+		{"789203",  "Hong Kong",      false}, // Nested inside China's block
+		{"7CF9A1",  "Australia",      true},
+		{"E80123",  "Chile",          false},
+		{"EC0000",  "",               false}, // Unallocated
+		{"~A81BD0", "",               false}, // Not a real ICAO address
+		{"nope",    "",               false},
+	}
+
+	for i,test := range tests {
+		if actual := test.Id.Country(); actual != test.Country {
+			t.Errorf("[%d] %q: Country %q, expected %q", i, test.Id, actual, test.Country)
+		}
+		if actual := test.Id.IsMilitary(); actual != test.Military {
+			t.Errorf("[%d] %q: IsMilitary %v, expected %v", i, test.Id, actual, test.Military)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package adsb
+
+import (
+	"math"
+	"time"
+
+	"github.com/skypies/geo"
+)
+
+// InterpolateComposite synthesizes a message at time t, which should lie between the two
+// messages; position, altitude, speed, vertical rate and track are interpolated linearly, and
+// the rest of the data is copied from whichever of the two is closer in time. Both messages
+// should have a position.
+func InterpolateComposite(from, to *CompositeMsg, t time.Time) *CompositeMsg {
+	ratio := 0.0
+	if span := to.GeneratedTimestampUTC.Sub(from.GeneratedTimestampUTC); span > 0 {
+		ratio = float64(t.Sub(from.GeneratedTimestampUTC)) / float64(span)
+	}
+
+	interpInt := func(a, b int64) int64 {
+		return int64(math.Round(float64(a) + float64(b-a)*ratio))
+	}
+
+	nearest := from
+	if ratio > 0.5 { nearest = to }
+
+	cm := *nearest // Clone the closest message; we overwrite the changing fields below
+	cm.GeneratedTimestampUTC = t
+	cm.LoggedTimestampUTC = t
+	cm.Position = from.Position.InterpolateTo(to.Position, ratio)
+	cm.hasPosition = true
+	cm.Altitude = interpInt(from.Altitude, to.Altitude)
+	cm.GroundSpeed = interpInt(from.GroundSpeed, to.GroundSpeed)
+	cm.VerticalRate = interpInt(from.VerticalRate, to.VerticalRate)
+	// Take the short way around (geo.InterpolateHeading doesn't, when from > to)
+	hdg := float64(from.Track) + geo.HeadingDelta(float64(from.Track), float64(to.Track)) * ratio
+	cm.Track = (int64(math.Round(hdg)) + 360) % 360
+	cm.IsSynthetic = true
+
+	return &cm
+}
@@ -0,0 +1,332 @@
+/* Package mlat positions aircraft by multilateration (TDOA), from the times at which
+several receivers heard the same Mode S frame.
+
+It needs Beast frames from each receiver, with 12MHz timestamps from
+clocks that agree with each other (e.g. GPS-disciplined receivers),
+and the location of each receiver. Copies of the same frame from
+different receivers are grouped together; once a group is complete,
+the position (and time of transmission) that best explains the
+arrival times is found by least squares. The frame's own altitude, if
+it has one, is used as an extra measurement, so three receivers can be
+enough (see MinStations); otherwise four are needed.
+
+This is mostly useful for aircraft with Mode S transponders but no
+ADS-B, which don't broadcast their position. The results are
+adsb.Msgs, with Type "MLAT" and the NumStations & ErrorEstimate fields
+set, that can be fed into a msgbuffer.
+
+Sample usage:
+
+    s := mlat.NewSolver()
+    s.Receivers["roof"] = mlat.Receiver{Position:geo.Latlong{Lat:37.4, Long:-122.1}, HeightM:30}
+    ...
+    for _,m := range s.Add(mlat.Reception{"roof", beastFrame, time.Now()}) {
+      mb.Add(m)
+    }
+
+*/
+package mlat
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/geo"
+)
+
+const (
+	beastTicksPerSecond = 12e6
+	minHeightM          = -500 // Solutions below this are not plausible
+)
+
+// {{{ Receiver{}, Reception{}
+
+type Receiver struct {
+	Position    geo.Latlong
+	HeightM     float64 // Height of the antenna above the WGS84 ellipsoid, in metres
+	ClockOffset int64   // Ticks to subtract from this receiver's timestamps, if its clock is off
+}
+
+// Reception is a frame, as heard by one receiver.
+type Reception struct {
+	Receiver string
+	Frame    adsb.BeastFrame
+	Time     time.Time // When it arrived; only used to timestamp the results
+}
+
+// }}}
+// {{{ Solver{}
+
+// group holds the copies of a single frame.
+type group struct {
+	frame     []byte
+	firstTick uint64
+	ticks     map[string]uint64 // By receiver name
+	time      time.Time
+}
+
+type fix struct {
+	pos  ecef
+	time time.Time
+}
+
+// Solver groups up the receptions, and solves for positions. It is not safe for concurrent use.
+type Solver struct {
+	Receivers    map[string]Receiver
+
+	MinStations  int           // Don't try to solve from fewer receivers than this (3 is the minimum)
+	GroupWindow  uint64        // Ticks; copies of a frame must be heard this close together
+	MaxDelay     uint64        // Ticks; how long to wait for slow receivers before solving a group
+	TimingSigmaM float64       // Expected timing error of a receiver, as a distance (metres)
+	AltSigmaM    float64       // Expected error of the altitude in the frame (metres)
+	MaxResidualM float64       // Discard solutions that fit the timings worse than this (RMS)
+	MaxErrorM    float64       // Discard solutions with a bigger error estimate than this
+	MaxRangeKM   float64       // Discard solutions further than this from the receivers
+
+	NumFrames    int64         // Receptions passed to Add
+	NumSolved    int64
+	NumUnsolved  int64         // Groups that had enough receivers, but didn't give a good solution
+
+	groups       map[string][]*group // By frame contents; identical frames are often repeated
+	lastTick     uint64
+	lastFix      map[adsb.IcaoId]fix // Used as the starting point for the next solution
+	lastAgeOut   time.Time
+}
+
+func NewSolver() *Solver {
+	return &Solver{
+		Receivers:    map[string]Receiver{},
+		MinStations:  4,
+		GroupWindow:  beastTicksPerSecond / 500,  // 2ms; receivers 600km apart
+		MaxDelay:     beastTicksPerSecond / 2,
+		TimingSigmaM: 30,                         // 100ns
+		AltSigmaM:    150,                        // Barometric altitude vs. height above ellipsoid
+		MaxResidualM: 500,
+		MaxErrorM:    5000,
+		MaxRangeKM:   500,
+		groups:       map[string][]*group{},
+		lastFix:      map[adsb.IcaoId]fix{},
+	}
+}
+
+// }}}
+
+// {{{ Solver.Add
+
+// Add takes a frame heard by a receiver, and returns any positions that could be solved
+// from the groups of frames that are now complete. Mode A/C frames, frames that mlat-client
+// synthesized from its own results, and frames from unknown receivers are ignored.
+func (s *Solver)Add(r Reception) []*adsb.Msg {
+	s.NumFrames++
+	if _,exists := s.Receivers[r.Receiver]; !exists || r.Frame.Type == adsb.BeastModeAC || r.Frame.IsMLAT() {
+		return nil
+	}
+	if s.groups == nil { s.groups = map[string][]*group{} }
+
+	tick := r.Frame.Timestamp - uint64(s.Receivers[r.Receiver].ClockOffset)
+	if tick > s.lastTick { s.lastTick = tick }
+
+	key := string(r.Frame.Data)
+	found := false
+	for _,g := range s.groups[key] {
+		if absDiff(tick, g.firstTick) <= s.GroupWindow {
+			if _,dup := g.ticks[r.Receiver]; !dup {
+				g.ticks[r.Receiver] = tick
+				if tick < g.firstTick { g.firstTick = tick }
+			}
+			found = true
+			break
+		}
+	}
+	if !found {
+		s.groups[key] = append(s.groups[key], &group{
+			frame:     r.Frame.Data,
+			firstTick: tick,
+			ticks:     map[string]uint64{r.Receiver: tick},
+			time:      r.Time,
+		})
+	}
+
+	return s.expire()
+}
+
+func absDiff(a, b uint64) uint64 {
+	if a > b { return a - b }
+	return b - a
+}
+
+// }}}
+// {{{ Solver.expire
+
+// expire solves the groups that have waited long enough.
+func (s *Solver)expire() []*adsb.Msg {
+	s.ageOut()
+
+	done := []*group{}
+	for key,groups := range s.groups {
+		waiting := []*group{}
+		for _,g := range groups {
+			if s.lastTick > g.firstTick + s.MaxDelay {
+				done = append(done, g)
+			} else {
+				waiting = append(waiting, g)
+			}
+		}
+		if len(waiting) == 0 {
+			delete(s.groups, key)
+		} else {
+			s.groups[key] = waiting
+		}
+	}
+	return s.solveGroups(done)
+}
+
+// }}}
+// {{{ Solver.ageOut
+
+func (s *Solver)ageOut() {
+	if time.Since(s.lastAgeOut) < time.Second { return } // Only run once per second.
+	s.lastAgeOut = time.Now()
+
+	for id,f := range s.lastFix {
+		if time.Since(f.time) > time.Minute {
+			delete(s.lastFix, id)
+		}
+	}
+}
+
+// }}}
+// {{{ Solver.Flush
+
+// Flush solves all the pending groups, regardless of how long they have waited.
+func (s *Solver)Flush() []*adsb.Msg {
+	all := []*group{}
+	for key,groups := range s.groups {
+		all = append(all, groups...)
+		delete(s.groups, key)
+	}
+	return s.solveGroups(all)
+}
+
+// }}}
+// {{{ Solver.solveGroups
+
+func (s *Solver)solveGroups(groups []*group) []*adsb.Msg {
+	ret := []*adsb.Msg{}
+	for _,g := range groups {
+		if len(g.ticks) < s.MinStations || len(g.ticks) < 3 {
+			continue
+		}
+		if m,err := s.solveGroup(g); err != nil {
+			s.NumUnsolved++
+		} else {
+			s.NumSolved++
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
+
+// }}}
+// {{{ Solver.solveGroup
+
+func (s *Solver)solveGroup(g *group) (*adsb.Msg, error) {
+	m := adsb.Msg{}
+	if err := m.FromModeS(g.frame, g.time); err != nil {
+		return nil, err
+	}
+
+	// Distances are relative to the first arrival, to keep the numbers small
+	ms := []measurement{}
+	centroid := ecef{}
+	for name,tick := range g.ticks {
+		rcvr := s.Receivers[name]
+		rPos := toECEF(rcvr.Position, rcvr.HeightM)
+		ms = append(ms, measurement{
+			receiver: rPos,
+			pseudoM:  float64(tick - g.firstTick) / beastTicksPerSecond * speedOfLight,
+		})
+		for i:=0; i<3; i++ { centroid[i] += rPos[i] / float64(len(g.ticks)) }
+	}
+
+	var altM *float64
+	guessAltM := 3000.0
+	if m.HasAltitude() {
+		a := float64(m.Altitude) * feetToMetres
+		altM, guessAltM = &a, a
+	}
+
+	cPos,_ := fromECEF(centroid)
+	guess := toECEF(cPos, guessAltM)
+	if last,exists := s.lastFix[m.Icao24]; exists && g.time.Sub(last.time) < time.Minute {
+		guess = last.pos
+	}
+
+	sol,err := solve(ms, guess, altM, s.TimingSigmaM, s.AltSigmaM)
+	if err != nil {
+		return nil, err
+	}
+
+	// Receivers are close to being in a plane, so the timings are also explained by a mirror
+	// image of the aircraft, below ground. If we don't know the altitude, we may land there.
+	pos,heightM := fromECEF(sol.pos)
+	if heightM < minHeightM && altM == nil {
+		if sol,err = solve(ms, toECEF(cPos, 12000), nil, s.TimingSigmaM, s.AltSigmaM); err != nil {
+			return nil, err
+		}
+		pos,heightM = fromECEF(sol.pos)
+	}
+
+	if heightM < minHeightM {
+		return nil, fmt.Errorf("underground (%.0fm)", heightM)
+	} else if sol.residualM > s.MaxResidualM {
+		return nil, fmt.Errorf("poor fit (residual %.0fm)", sol.residualM)
+	} else if sol.errorM > s.MaxErrorM {
+		return nil, fmt.Errorf("poor geometry (error %.0fm)", sol.errorM)
+	} else if pos.DistKM(cPos) > s.MaxRangeKM {
+		return nil, fmt.Errorf("too far away (%.0fkm)", pos.DistKM(cPos))
+	}
+
+	if s.lastFix == nil { s.lastFix = map[adsb.IcaoId]fix{} }
+	s.lastFix[m.Icao24] = fix{sol.pos, g.time}
+
+	m.Type, m.SubType, m.Source = "MLAT", 3, adsb.SourceMLAT
+	m.Position = pos
+	m.SetHasPosition()
+	if !m.HasAltitude() {
+		m.Altitude = int64(heightM / feetToMetres)
+	}
+	m.NumStations = int64(len(g.ticks))
+	m.ErrorEstimate = sol.errorM
+
+	return &m, nil
+}
+
+// }}}
+// {{{ Solver.Run
+
+// Run reads receptions from the input channel, and sends the solutions to the output
+// channel. When the input channel is closed, the pending groups are flushed, and the output
+// channel is closed.
+func (s *Solver)Run(in <-chan Reception, out chan<- *adsb.Msg) {
+	for r := range in {
+		for _,m := range s.Add(r) {
+			out <- m
+		}
+	}
+	for _,m := range s.Flush() {
+		out <- m
+	}
+	close(out)
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
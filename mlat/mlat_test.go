@@ -0,0 +1,209 @@
+package mlat
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/adsb/simulator"
+	"github.com/skypies/geo"
+)
+
+var receivers = map[string]Receiver{
+	"sfo": {Position:geo.Latlong{Lat:37.6188, Long:-122.3754}, HeightM:10},
+	"pao": {Position:geo.Latlong{Lat:37.4613, Long:-122.1150}, HeightM:20},
+	"oak": {Position:geo.Latlong{Lat:37.7214, Long:-122.2208}, HeightM:30},
+	"hwd": {Position:geo.Latlong{Lat:37.6592, Long:-122.1217}, HeightM:40},
+	"sql": {Position:geo.Latlong{Lat:37.5119, Long:-122.2495}, HeightM:50},
+}
+
+// track is a known trajectory: flying east at 240 knots.
+func track(i int) (geo.Latlong, int64) {
+	secs := float64(i)
+	return geo.Latlong{Lat:37.55, Long:-122.35 + secs * 240 / 3600 / 60 / math.Cos(37.55 * math.Pi / 180)}, 6000
+}
+
+// receptions generates the frame as heard by each of the named receivers, if sent from the
+// position at the tick. Timing noise (in ns) is added, if jitterNS > 0.
+func receptions(names []string, frame []byte, pos geo.Latlong, altFt int64, tick uint64, jitterNS float64) []Reception {
+	p := toECEF(pos, float64(altFt) * feetToMetres)
+	ret := []Reception{}
+	for _,name := range names {
+		r := receivers[name]
+		secs := p.dist(toECEF(r.Position, r.HeightM)) / speedOfLight + rand.NormFloat64() * jitterNS / 1e9
+		f := adsb.BeastFrame{
+			Type:      adsb.BeastModeSShort,
+			Timestamp: tick + uint64(math.Round(secs * beastTicksPerSecond)) + uint64(r.ClockOffset),
+			Data:      frame,
+		}
+		if len(frame) == 14 { f.Type = adsb.BeastModeSLong }
+		ret = append(ret, Reception{name, f, time.Unix(int64(tick / beastTicksPerSecond), 0)})
+	}
+	return ret
+}
+
+func newTestSolver() *Solver {
+	s := NewSolver()
+	for name,r := range receivers { s.Receivers[name] = r }
+	return s
+}
+
+func TestGeometry(t *testing.T) {
+	pos := geo.Latlong{Lat:37.6188, Long:-122.3754}
+	p := toECEF(pos, 1234.5)
+	back,h := fromECEF(p)
+	if back.DistKM(pos) > 0.001 || math.Abs(h - 1234.5) > 0.01 {
+		t.Errorf("round trip: %s %f", back, h)
+	}
+
+	x,_,err := solveLinear([][]float64{{2, 1}, {1, 3}}, []float64{3, 5})
+	if err != nil || math.Abs(x[0] - 0.8) > 1e-9 || math.Abs(x[1] - 1.4) > 1e-9 {
+		t.Errorf("bad linear solution: %v, %v", x, err)
+	}
+	if _,_,err := solveLinear([][]float64{{1, 2}, {2, 4}}, []float64{1, 2}); err == nil {
+		t.Errorf("singular matrix was solved")
+	}
+}
+
+func TestSolveTrack(t *testing.T) {
+	s := newTestSolver()
+	names := []string{"sfo", "pao", "oak", "hwd", "sql"}
+	start := uint64(1000 * beastTicksPerSecond)
+
+	results := []*adsb.Msg{}
+	for i:=0; i<20; i++ {
+		pos,alt := track(i)
+		frame,_ := simulator.SurveillanceAltitudeFrame("A81BD0", alt)
+		for _,r := range receptions(names, frame, pos, alt, start + uint64(i) * beastTicksPerSecond, 50) {
+			results = append(results, s.Add(r)...)
+		}
+	}
+	results = append(results, s.Flush()...)
+
+	if len(results) != 20 || s.NumSolved != 20 {
+		t.Fatalf("expected 20 solutions, got %d (%d unsolved)", len(results), s.NumUnsolved)
+	}
+	for _,m := range results {
+		i := int(m.GeneratedTimestampUTC.Unix() - 1000)
+		pos,_ := track(i)
+		if !m.IsMLAT() || m.Source != adsb.SourceMLAT || !m.HasPosition() || m.Icao24 != "A81BD0" ||
+			m.Altitude != 6000 || m.NumStations != 5 {
+			t.Errorf("[%d] bad msg: %s %+v", i, m, m)
+		}
+		if d := m.Position.DistKM(pos) * 1000; d > 250 || m.ErrorEstimate <= 0 || m.ErrorEstimate > 1000 {
+			t.Errorf("[%d] %s is %.0fm from %s (error estimate %.0fm)", i, m.Position, d, pos, m.ErrorEstimate)
+		}
+	}
+}
+
+func TestSolveWithoutAltitude(t *testing.T) {
+	// An all-call reply has no altitude, so we need four receivers
+	frame,_ := simulator.AllCallFrame("A2C635")
+
+	pos := geo.Latlong{Lat:37.60, Long:-122.25}
+	tick := uint64(500 * beastTicksPerSecond)
+
+	s := newTestSolver()
+	for _,r := range receptions([]string{"sfo", "pao", "oak", "hwd"}, frame, pos, 9000, tick, 0) {
+		s.Add(r)
+	}
+	results := s.Flush()
+	if len(results) != 1 {
+		t.Fatalf("expected 1 solution, got %d", len(results))
+	}
+	if m := results[0]; m.Position.DistKM(pos) > 0.2 || math.Abs(float64(m.Altitude - 9000)) > 500 {
+		t.Errorf("bad solution: %s %dft, expected %s", m.Position, m.Altitude, pos)
+	}
+
+	// Three receivers isn't enough without an altitude ...
+	s = newTestSolver()
+	s.MinStations = 3
+	for _,r := range receptions([]string{"sfo", "pao", "oak"}, frame, pos, 9000, tick, 0) {
+		s.Add(r)
+	}
+	if results := s.Flush(); len(results) != 0 || s.NumUnsolved != 1 {
+		t.Errorf("solved from three receivers, without an altitude")
+	}
+
+	// ... but it is with one
+	altFrame,_ := simulator.SurveillanceAltitudeFrame("A2C635", 9000)
+	for _,r := range receptions([]string{"sfo", "pao", "oak"}, altFrame, pos, 9000, tick, 0) {
+		s.Add(r)
+	}
+	if results := s.Flush(); len(results) != 1 || results[0].Position.DistKM(pos) > 0.5 {
+		t.Errorf("did not solve from three receivers, with an altitude: %v", results)
+	}
+}
+
+func TestGrouping(t *testing.T) {
+	s := newTestSolver()
+	s.MaxDelay = beastTicksPerSecond / 10
+	pos,alt := track(0)
+	frame,_ := simulator.SurveillanceAltitudeFrame("A81BD0", alt)
+	tick := uint64(100 * beastTicksPerSecond)
+
+	rs := receptions([]string{"sfo", "pao", "oak", "hwd"}, frame, pos, alt, tick, 0)
+	rs = append(rs, Reception{"nowhere", rs[0].Frame, rs[0].Time})            // Unknown receiver
+	rs = append(rs, rs[1])                                                     // Duplicate
+	for _,r := range rs {
+		if results := s.Add(r); len(results) != 0 { t.Errorf("solved too early") }
+	}
+
+	// Enough time passes, and the next copy of the frame goes into a new group
+	later := receptions([]string{"sfo"}, frame, pos, alt, tick + beastTicksPerSecond, 0)
+	results := s.Add(later[0])
+	if len(results) != 1 || results[0].NumStations != 4 {
+		t.Errorf("expected the first group to be solved: %v", results)
+	}
+	if len(s.Flush()) != 0 || s.NumUnsolved != 0 {
+		t.Errorf("the single reception was solved")
+	}
+}
+
+func TestClockOffset(t *testing.T) {
+	pos,alt := track(5)
+	frame,_ := simulator.SurveillanceAltitudeFrame("A81BD0", alt)
+	names := []string{"sfo", "pao", "oak", "hwd"}
+
+	r := receivers["oak"]
+	r.ClockOffset = 1200 // 100us; 30km
+	receivers["oak"] = r
+	defer func() { r.ClockOffset = 0; receivers["oak"] = r }()
+
+	s := newTestSolver()
+	for _,rcpt := range receptions(names, frame, pos, alt, 12345678, 0) { s.Add(rcpt) }
+	if results := s.Flush(); len(results) != 1 || results[0].Position.DistKM(pos) > 0.2 {
+		t.Errorf("clock offset not corrected: %v", results)
+	}
+
+	// If we don't know about the offset, the solution is rejected
+	s = newTestSolver()
+	r.ClockOffset = 0
+	s.Receivers["oak"] = r
+	for _,rcpt := range receptions(names, frame, pos, alt, 12345678, 0) { s.Add(rcpt) }
+	results := s.Flush()
+	if len(results) == 1 && results[0].Position.DistKM(pos) < 1 {
+		t.Errorf("solved despite bad clock")
+	}
+}
+
+func TestRun(t *testing.T) {
+	in, out := make(chan Reception, 10), make(chan *adsb.Msg, 10)
+	go newTestSolver().Run(in, out)
+
+	pos,alt := track(0)
+	frame,_ := simulator.SurveillanceAltitudeFrame("A81BD0", alt)
+	for _,r := range receptions([]string{"sfo", "pao", "oak", "hwd"}, frame, pos, alt, 1000, 0) {
+		in <- r
+	}
+	close(in)
+
+	n := 0
+	for m := range out {
+		if !m.IsMLAT() { t.Errorf("not MLAT: %s", m) }
+		n++
+	}
+	if n != 1 { t.Errorf("expected 1 result, got %d", n) }
+}
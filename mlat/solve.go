@@ -0,0 +1,198 @@
+package mlat
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/skypies/geo"
+)
+
+// The geometry is done in ECEF (earth-centred, earth-fixed) coordinates, in metres, on the
+// WGS84 ellipsoid.
+
+const (
+	wgs84A  = 6378137.0
+	wgs84F  = 1 / 298.257223563
+	wgs84E2 = wgs84F * (2 - wgs84F)
+
+	speedOfLight = 299792458.0 // m/s; close enough to the speed of radio in air
+	feetToMetres = 0.3048
+)
+
+// {{{ ecef
+
+type ecef [3]float64
+
+func toECEF(pos geo.Latlong, heightM float64) ecef {
+	lat, long := pos.Lat * math.Pi / 180, pos.Long * math.Pi / 180
+	n := wgs84A / math.Sqrt(1 - wgs84E2 * math.Pow(math.Sin(lat), 2))
+	return ecef{
+		(n + heightM) * math.Cos(lat) * math.Cos(long),
+		(n + heightM) * math.Cos(lat) * math.Sin(long),
+		(n * (1 - wgs84E2) + heightM) * math.Sin(lat),
+	}
+}
+
+// fromECEF returns the position, and the height above the ellipsoid (in metres).
+func fromECEF(p ecef) (geo.Latlong, float64) {
+	long := math.Atan2(p[1], p[0])
+	r := math.Hypot(p[0], p[1])
+	lat := math.Atan2(p[2], r * (1 - wgs84E2))
+
+	h := 0.0
+	for i:=0; i<5; i++ {
+		n := wgs84A / math.Sqrt(1 - wgs84E2 * math.Pow(math.Sin(lat), 2))
+		h = r / math.Cos(lat) - n
+		lat = math.Atan2(p[2], r * (1 - wgs84E2 * n / (n + h)))
+	}
+
+	return geo.Latlong{Lat:lat * 180 / math.Pi, Long:long * 180 / math.Pi}, h
+}
+
+// up is the unit vector pointing away from the earth, at the position.
+func up(p ecef) ecef {
+	pos,_ := fromECEF(p)
+	lat, long := pos.Lat * math.Pi / 180, pos.Long * math.Pi / 180
+	return ecef{math.Cos(lat) * math.Cos(long), math.Cos(lat) * math.Sin(long), math.Sin(lat)}
+}
+
+func (a ecef)sub(b ecef) ecef     { return ecef{a[0]-b[0], a[1]-b[1], a[2]-b[2]} }
+func (a ecef)norm() float64       { return math.Sqrt(a[0]*a[0] + a[1]*a[1] + a[2]*a[2]) }
+func (a ecef)dist(b ecef) float64 { return a.sub(b).norm() }
+
+// }}}
+// {{{ solveLinear
+
+// solveLinear solves Ax=b (for a small, square A) by Gaussian elimination with partial
+// pivoting. It also returns the inverse of A, as we need it for the covariance.
+func solveLinear(a [][]float64, b []float64) (x []float64, inv [][]float64, err error) {
+	n := len(b)
+	m := make([][]float64, n) // A, augmented with b and the identity matrix
+	for i:=range m {
+		m[i] = make([]float64, 2*n+1)
+		copy(m[i], a[i])
+		m[i][n] = b[i]
+		m[i][n+1+i] = 1
+	}
+
+	for col:=0; col<n; col++ {
+		pivot := col
+		for row:=col+1; row<n; row++ {
+			if math.Abs(m[row][col]) > math.Abs(m[pivot][col]) { pivot = row }
+		}
+		if math.Abs(m[pivot][col]) < 1e-12 {
+			return nil, nil, fmt.Errorf("singular matrix")
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+
+		for row:=0; row<n; row++ {
+			if row == col { continue }
+			f := m[row][col] / m[col][col]
+			for k:=col; k<2*n+1; k++ {
+				m[row][k] -= f * m[col][k]
+			}
+		}
+	}
+
+	x = make([]float64, n)
+	inv = make([][]float64, n)
+	for i:=0; i<n; i++ {
+		x[i] = m[i][n] / m[i][i]
+		inv[i] = make([]float64, n)
+		for j:=0; j<n; j++ {
+			inv[i][j] = m[i][n+1+j] / m[i][i]
+		}
+	}
+	return x, inv, nil
+}
+
+// }}}
+// {{{ solve
+
+// measurement is one receiver's timing of a frame, as a distance: the time since some
+// reference, multiplied by the speed of light.
+type measurement struct {
+	receiver ecef
+	pseudoM  float64
+}
+
+type solution struct {
+	pos       ecef
+	errorM    float64 // Estimated position error (from the covariance)
+	residualM float64 // RMS of the timing residuals
+}
+
+// solve finds the position that best explains the arrival times, by Gauss-Newton iteration
+// from the initial guess. The unknowns are the position, and the time the frame was sent.
+// If altM is not nil, it is used as an extra (loosely weighted) measurement of the height.
+func solve(ms []measurement, guess ecef, altM *float64, timingSigmaM, altSigmaM float64) (solution, error) {
+	nUnknowns := 4
+	if len(ms) < nUnknowns && (altM == nil || len(ms) < nUnknowns-1) {
+		return solution{}, fmt.Errorf("not enough receivers (%d)", len(ms))
+	}
+
+	p := guess
+	offset := ms[0].pseudoM - p.dist(ms[0].receiver) // Time of transmission, as a distance
+	var inv [][]float64
+
+	for iter:=0; iter<20; iter++ {
+		// Build the weighted normal equations: (JtJ) dx = Jt r
+		jtj := make([][]float64, nUnknowns)
+		for i:=range jtj { jtj[i] = make([]float64, nUnknowns) }
+		jtr := make([]float64, nUnknowns)
+
+		addRow := func(row []float64, resid, sigma float64) {
+			for i:=0; i<nUnknowns; i++ {
+				for j:=0; j<nUnknowns; j++ {
+					jtj[i][j] += row[i] * row[j] / (sigma * sigma)
+				}
+				jtr[i] += row[i] * resid / (sigma * sigma)
+			}
+		}
+
+		for _,m := range ms {
+			d := p.sub(m.receiver)
+			r := d.norm()
+			addRow([]float64{d[0]/r, d[1]/r, d[2]/r, 1}, m.pseudoM - r - offset, timingSigmaM)
+		}
+		if altM != nil {
+			_,h := fromECEF(p)
+			u := up(p)
+			addRow([]float64{u[0], u[1], u[2], 0}, *altM - h, altSigmaM)
+		}
+
+		dx,inverse,err := solveLinear(jtj, jtr)
+		if err != nil {
+			return solution{}, err
+		}
+		inv = inverse
+		for i:=0; i<3; i++ { p[i] += dx[i] }
+		offset += dx[3]
+
+		if math.Sqrt(dx[0]*dx[0] + dx[1]*dx[1] + dx[2]*dx[2]) < 0.01 {
+			break
+		}
+	}
+
+	sumSq := 0.0
+	for _,m := range ms {
+		resid := m.pseudoM - p.dist(m.receiver) - offset
+		sumSq += resid * resid
+	}
+
+	return solution{
+		pos:       p,
+		errorM:    math.Sqrt(inv[0][0] + inv[1][1] + inv[2][2]),
+		residualM: math.Sqrt(sumSq / float64(len(ms))),
+	}, nil
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,56 @@
+package adsb
+
+import (
+	"fmt"
+	"time"
+)
+
+// Mode A/C replies are just 13 bits long (plus an ident bit); they carry either a squawk
+// (Mode A) or a Gillham coded altitude (Mode C), depending on which kind of interrogation
+// they answered. There is no address, so a receiver can't tell which aircraft sent one - or
+// even whether it is a squawk or an altitude. dump1090 & the Beast format pass them around
+// as two bytes, laid out like a Mode A code (one octal digit per hex nibble, see id13ToModeA),
+// with the ident flag in bit 0x0080.
+
+const modeACIdentBit = 0x0080
+
+// FromModeAC decodes a two byte Mode A/C reply, received at time t. As we can't tell what
+// kind of reply it is, it is decoded both ways: Squawk is always set, and Altitude is also set
+// if the code is a valid Mode C altitude. Icao24 is left empty, and Source is SourceModeAC.
+func (m *Msg)FromModeAC(frame []byte, t time.Time) error {
+	if len(frame) != 2 {
+		return fmt.Errorf("Mode A/C frame is %d bytes", len(frame))
+	}
+
+	code := uint32(frame[0])<<8 | uint32(frame[1])
+	if code & 0x8808 != 0 {
+		return fmt.Errorf("Mode A/C frame %04X has bits set outside the code", code)
+	}
+
+	*m = Msg{
+		Type: "MSG",
+		GeneratedTimestampUTC: t.UTC(),
+		LoggedTimestampUTC: t.UTC(),
+		Source: SourceModeAC,
+	}
+
+	m.Squawk, m.hasSquawk = fmt.Sprintf("%04x", code & 0x7777), true
+	m.SPI, m.hasSPI = code & modeACIdentBit != 0, true
+
+	if n,ok := gillhamToModeC(code & 0x7777); ok && n >= -12 {
+		m.Altitude, m.hasAltitude = n * 100, true
+	}
+
+	return nil
+}
+
+// ModeACFrame is the inverse of FromModeAC; it encodes a squawk (e.g. "7700") as a two
+// byte Mode A/C frame.
+func ModeACFrame(squawk string, ident bool) ([]byte, error) {
+	code := uint32(0)
+	if _,err := fmt.Sscanf(squawk, "%04x", &code); err != nil || len(squawk) != 4 || code & 0x8888 != 0 {
+		return nil, fmt.Errorf("'%s' is not a squawk", squawk)
+	}
+	if ident { code |= modeACIdentBit }
+	return []byte{byte(code >> 8), byte(code)}, nil
+}
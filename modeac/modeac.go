@@ -0,0 +1,215 @@
+/* Package modeac matches Mode A/C replies to the Mode S aircraft that sent them.
+
+Mode A/C replies carry a squawk or an altitude, but no address; a
+receiver can't even tell which of the two it has, so each reply is
+decoded as both (see adsb.Msg.FromModeAC). The correlator remembers
+the latest squawk and altitude of each aircraft that has an address.
+A Mode A/C reply is matched to an aircraft if it is that aircraft's
+squawk (a Mode A match), or else if it decodes to that aircraft's
+altitude (a Mode C match). Matches must be unique; a squawk or an
+altitude shared by two aircraft (e.g. the VFR squawk, 1200) isn't
+matched to either.
+
+It slots into the same channel pipeline as msgbuffer (configured with
+AllowPositionless). Matched replies get the aircraft's Icao24 filled
+in; they keep SourceModeAC, so they can still be told apart.
+
+Sample usage:
+
+    c := modeac.NewCorrelator()
+    go c.Run(flushChan, outChan)
+
+*/
+package modeac
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/skypies/adsb"
+)
+
+// {{{ Match{}
+
+type MatchType int
+const (
+	NoMatch MatchType = iota
+	ModeA                    // The reply was the aircraft's squawk
+	ModeC                    // The reply was the aircraft's altitude
+	Ambiguous                // The reply matched more than one aircraft
+)
+
+func (mt MatchType)String() string {
+	switch mt {
+	case NoMatch:   return "nomatch"
+	case ModeA:     return "modeA"
+	case ModeC:     return "modeC"
+	case Ambiguous: return "ambiguous"
+	default:        return "?"
+	}
+}
+
+type Match struct {
+	Type   MatchType
+	Icao24 adsb.IcaoId // Only set for ModeA and ModeC matches
+}
+
+func (m Match)String() string { return fmt.Sprintf("%s[%s]", m.Type, m.Icao24) }
+
+// }}}
+// {{{ Correlator{}
+
+type aircraft struct {
+	squawk       string
+	squawkTime   time.Time // Timestamp of the message with the squawk
+	altitude     int64
+	altitudeTime time.Time
+	lastSeen     time.Time
+}
+
+// Correlator remembers the recent squawk & altitude of each aircraft. It is not safe for
+// concurrent use.
+type Correlator struct {
+	AltitudeTolerance int64         // A Mode C reply must be this close (in feet) to match
+	MaxAge            time.Duration // Don't match against data older than this (by msg timestamp)
+	MaxQuietTime      time.Duration // Forget aircraft that send no messages for this long
+
+	NumMatched        map[MatchType]int64
+
+	aircraft          map[adsb.IcaoId]*aircraft
+	lastAgeOut        time.Time
+}
+
+func NewCorrelator() *Correlator {
+	return &Correlator{
+		AltitudeTolerance: 200,
+		MaxAge:            time.Second * 30,
+		MaxQuietTime:      time.Second * 360,
+		NumMatched:        map[MatchType]int64{},
+		aircraft:          map[adsb.IcaoId]*aircraft{},
+	}
+}
+
+// }}}
+
+// {{{ Correlator.ageOut
+
+func (c *Correlator)ageOut() {
+	if time.Since(c.lastAgeOut) < time.Second { return } // Only run once per second.
+	c.lastAgeOut = time.Now()
+
+	for id,a := range c.aircraft {
+		if time.Since(a.lastSeen) >= c.MaxQuietTime {
+			delete(c.aircraft, id)
+		}
+	}
+}
+
+// }}}
+// {{{ Correlator.Update
+
+// Update remembers the squawk & altitude (if any) from a message with an address. Mode A/C
+// replies, and messages from masked (non-ICAO) addresses, are ignored.
+func (c *Correlator)Update(m *adsb.Msg) {
+	if m.IsModeAC() || m.Icao24 == "" || m.IsMasked() { return }
+	if !m.HasSquawk() && !m.HasAltitude() { return }
+
+	if c.aircraft == nil { c.aircraft = map[adsb.IcaoId]*aircraft{} }
+	a,exists := c.aircraft[m.Icao24]
+	if !exists {
+		a = &aircraft{}
+		c.aircraft[m.Icao24] = a
+	}
+
+	t := m.GeneratedTimestampUTC
+	if m.HasSquawk() && !t.Before(a.squawkTime) {
+		a.squawk, a.squawkTime = m.Squawk, t
+	}
+	if m.HasAltitude() && !t.Before(a.altitudeTime) {
+		a.altitude, a.altitudeTime = m.Altitude, t
+	}
+	a.lastSeen = time.Now()
+}
+
+// }}}
+// {{{ Correlator.Correlate
+
+// Correlate looks for the aircraft that sent a Mode A/C reply. Mode A matches are preferred
+// to Mode C matches.
+func (c *Correlator)Correlate(m *adsb.Msg) Match {
+	if !m.IsModeAC() { return Match{} }
+
+	t := m.GeneratedTimestampUTC
+	fresh := func(dataTime time.Time) bool {
+		dt := t.Sub(dataTime)
+		return dt < c.MaxAge && dt > -c.MaxAge
+	}
+
+	modeA, modeC := []adsb.IcaoId{}, []adsb.IcaoId{}
+	for id,a := range c.aircraft {
+		if m.HasSquawk() && a.squawk == m.Squawk && fresh(a.squawkTime) {
+			modeA = append(modeA, id)
+		}
+		if m.HasAltitude() && !a.altitudeTime.IsZero() && fresh(a.altitudeTime) {
+			if diff := a.altitude - m.Altitude; diff <= c.AltitudeTolerance && diff >= -c.AltitudeTolerance {
+				modeC = append(modeC, id)
+			}
+		}
+	}
+
+	switch {
+	case len(modeA) == 1: return Match{ModeA, modeA[0]}
+	case len(modeA) > 1:  return Match{Type:Ambiguous}
+	case len(modeC) == 1: return Match{ModeC, modeC[0]}
+	case len(modeC) > 1:  return Match{Type:Ambiguous}
+	default:              return Match{}
+	}
+}
+
+// }}}
+// {{{ Correlator.Add
+
+// Add learns from messages with addresses, and tries to match Mode A/C replies; those that
+// are matched get the aircraft's Icao24.
+func (c *Correlator)Add(cm *adsb.CompositeMsg) Match {
+	c.ageOut()
+
+	if !cm.IsModeAC() {
+		c.Update(&cm.Msg)
+		return Match{}
+	}
+
+	match := c.Correlate(&cm.Msg)
+	if c.NumMatched == nil { c.NumMatched = map[MatchType]int64{} }
+	c.NumMatched[match.Type]++
+	if match.Icao24 != "" {
+		cm.Icao24 = match.Icao24
+	}
+	return match
+}
+
+// }}}
+// {{{ Correlator.Run
+
+// Run reads slices of messages from the input channel, matches the Mode A/C replies (see
+// Add), and passes the messages on to the output channel. The output channel is closed when
+// the input channel is closed.
+func (c *Correlator)Run(in <-chan []*adsb.CompositeMsg, out chan<- []*adsb.CompositeMsg) {
+	for msgs := range in {
+		for _,cm := range msgs {
+			c.Add(cm)
+		}
+		out <- msgs
+	}
+	close(out)
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,88 @@
+package modeac
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/skypies/adsb"
+)
+
+var t0 = time.Date(2016, 3, 10, 18, 0, 0, 0, time.UTC)
+
+func modeS(id adsb.IcaoId, squawk string, alt int64, t time.Time) *adsb.CompositeMsg {
+	sbs := fmt.Sprintf("MSG,5,1,1,%s,1,2016/03/10,18:00:00.000,2016/03/10,18:00:00.000,,%d,,,,,,,,,,0", id, alt)
+	if squawk != "" {
+		sbs = fmt.Sprintf("MSG,6,1,1,%s,1,2016/03/10,18:00:00.000,2016/03/10,18:00:00.000,,%d,,,,,,%s,0,0,0,0",
+			id, alt, squawk)
+	}
+	m := adsb.Msg{}
+	if err := m.FromSBS1(sbs); err != nil { panic(err) }
+	m.GeneratedTimestampUTC = t
+	return &adsb.CompositeMsg{Msg:m}
+}
+
+func modeAC(squawk string, t time.Time) *adsb.CompositeMsg {
+	frame,err := adsb.ModeACFrame(squawk, false)
+	if err != nil { panic(err) }
+	m := adsb.Msg{}
+	if err := m.FromModeAC(frame, t); err != nil { panic(err) }
+	return &adsb.CompositeMsg{Msg:m}
+}
+
+// modeC finds the Mode A/C code that decodes to the altitude.
+func modeC(alt int64, t time.Time) *adsb.CompositeMsg {
+	for i:=0; i<010000; i++ {
+		cm := modeAC(fmt.Sprintf("%04o", i), t)
+		if cm.HasAltitude() && cm.Altitude == alt { return cm }
+	}
+	panic(fmt.Sprintf("no Mode C code for %dft", alt))
+}
+
+func TestCorrelate(t *testing.T) {
+	c := NewCorrelator()
+	c.Add(modeS("A81BD0", "4521", 12300, t0))
+	c.Add(modeS("A2C635", "1200", 4500,  t0))
+	c.Add(modeS("ABEEF0", "1200", 8500,  t0))
+	c.Add(modeS("ABEEF0", "",     8600,  t0.Add(time.Second)))
+
+	tests := []struct {
+		CM     *adsb.CompositeMsg
+		Match  Match
+	}{
+		{modeAC("4521", t0),                  Match{ModeA, "A81BD0"}},
+		{modeAC("1200", t0),                  Match{Type:Ambiguous}},
+		{modeAC("7700", t0),                  Match{}},
+		{modeC(12300, t0),                    Match{ModeC, "A81BD0"}},
+		{modeC(8700, t0),                     Match{ModeC, "ABEEF0"}},  // Within tolerance
+		{modeC(9000, t0),                     Match{}},
+		{modeAC("4521", t0.Add(time.Minute)), Match{}},                 // Stale
+		{modeS("A81BD0", "4521", 12300, t0),  Match{}},                 // Not Mode A/C
+	}
+
+	for i,test := range tests {
+		if m := c.Add(test.CM); m != test.Match {
+			t.Errorf("[%d] %s: got %s, expected %s", i, test.CM, m, test.Match)
+		} else if test.CM.IsModeAC() && test.CM.Icao24 != m.Icao24 {
+			t.Errorf("[%d] Icao24 %q not filled in", i, test.CM.Icao24)
+		}
+	}
+
+	if c.NumMatched[ModeA] != 1 || c.NumMatched[ModeC] != 2 || c.NumMatched[NoMatch] != 3 {
+		t.Errorf("bad counts: %v", c.NumMatched)
+	}
+}
+
+func TestRun(t *testing.T) {
+	in, out := make(chan []*adsb.CompositeMsg, 1), make(chan []*adsb.CompositeMsg, 1)
+	go NewCorrelator().Run(in, out)
+
+	in <- []*adsb.CompositeMsg{modeS("A81BD0", "4521", 12300, t0), modeAC("4521", t0)}
+	if msgs := <-out; len(msgs) != 2 || msgs[1].Icao24 != "A81BD0" {
+		t.Errorf("bad output: %v", msgs)
+	}
+	close(in)
+	if _,ok := <-out; ok {
+		t.Errorf("output not closed")
+	}
+}
@@ -0,0 +1,57 @@
+package adsb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFromModeAC(t *testing.T) {
+	tests := []struct {
+		AVR     string
+		Squawk  string
+		SPI     bool
+		Alt     int64
+		HasAlt  bool
+		Err     bool
+	}{
+		{"*7700;", "7700", false, 0,    false, false}, // No C bits, so not an altitude
+		{"*0420;", "0420", false, -500, true,  false},
+		{"*04A0;", "0420", true,  -500, true,  false},
+		{"*1200;", "1200", false, 0,    false, false},
+		{"*8000;", "",     false, 0,    false, true},
+	}
+
+	for i,test := range tests {
+		m := Msg{}
+		err := m.FromAVR(test.AVR, time.Now())
+		if (err != nil) != test.Err {
+			t.Errorf("[%d] %s: err %v", i, test.AVR, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if !m.IsModeAC() || m.Icao24 != "" || m.HasPosition() || m.Squawk != test.Squawk || !m.HasSquawk() ||
+			m.SPI != test.SPI || m.HasAltitude() != test.HasAlt || m.Altitude != test.Alt {
+			t.Errorf("[%d] %s: got %+v", i, test.AVR, m)
+		}
+	}
+
+	if err := (&Msg{}).FromModeAC([]byte{0x77}, time.Now()); err == nil {
+		t.Errorf("short frame was accepted")
+	}
+}
+
+func TestModeACFrame(t *testing.T) {
+	frame,err := ModeACFrame("7700", true)
+	if err != nil { t.Fatal(err) }
+	m := Msg{}
+	if err := m.FromModeAC(frame, time.Now()); err != nil || m.Squawk != "7700" || !m.SPI {
+		t.Errorf("round trip failed: %v, %+v", err, m)
+	}
+
+	for _,bad := range []string{"7800", "770", "77000", "zzzz"} {
+		if _,err := ModeACFrame(bad, false); err == nil {
+			t.Errorf("'%s' was accepted", bad)
+		}
+	}
+}
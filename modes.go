@@ -0,0 +1,406 @@
+package adsb
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Raw Mode S frames, as output by dump1090 et al in AVR format (e.g. "*8D4840D6202CC371C32CE0576098;").
+// We decode enough of them to fill out a Msg: surveillance replies (DF4/5/20/21), all-call
+// replies (DF11), ADS-B identification, velocity and operational status (DF17, and DF18
+// for TIS-B & ADS-R), and the Comm-B data in DF20/21 (see commb.go). ADS-B positions are CPR
+// encoded, and need more than one frame to decode; see CPRDecoder.
+
+// Mode S downlink formats
+const (
+	DFShortAltitude    = 4
+	DFShortIdentity    = 5
+	DFAllCall          = 11
+	DFExtendedSquitter = 17
+	DFNonTransponder   = 18
+	DFCommBAltitude    = 20
+	DFCommBIdentity    = 21
+)
+
+// The SBS-1 subtypes that the various kinds of frame map onto
+const (
+	sbsSubTypeIdentification   = 1
+	sbsSubTypeSurfacePosition  = 2
+	sbsSubTypeAirbornePosition = 3
+	sbsSubTypeAirborneVelocity = 4
+	sbsSubTypeSurveillanceAlt  = 5
+	sbsSubTypeSurveillanceID   = 6
+	sbsSubTypeAllCall          = 8
+)
+
+const crc24Generator = 0x1FFF409
+
+// crc24 computes the Mode S parity over the data.
+func crc24(data []byte) uint32 {
+	crc := uint32(0)
+	for _,b := range data {
+		crc ^= uint32(b) << 16
+		for i:=0; i<8; i++ {
+			crc <<= 1
+			if crc & 0x1000000 != 0 {
+				crc ^= crc24Generator
+			}
+		}
+	}
+	return crc & 0xFFFFFF
+}
+
+// frameParity returns the CRC computed over the frame, XORed with the parity field at its
+// end. For frames with PI parity (e.g. DF17) this is zero if the frame is intact; for frames
+// with AP parity (e.g. DF20), it is the aircraft's address.
+func frameParity(frame []byte) uint32 {
+	n := len(frame)
+	pi := uint32(frame[n-3])<<16 | uint32(frame[n-2])<<8 | uint32(frame[n-1])
+	return crc24(frame[:n-3]) ^ pi
+}
+
+// ParseAVR decodes a line of AVR output into a raw frame. Both plain ("*...;") and
+// timestamped ("@<12 hex digits>...;") lines are accepted; the timestamp is discarded.
+func ParseAVR(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasSuffix(s, ";") || len(s) < 2 {
+		return nil, fmt.Errorf("AVR '%s': bad framing", s)
+	}
+
+	switch s[0] {
+	case '*': s = s[1:len(s)-1]
+	case '@':
+		if len(s) < 14 { return nil, fmt.Errorf("AVR '%s': too short", s) }
+		s = s[13:len(s)-1]
+	default:
+		return nil, fmt.Errorf("AVR '%s': bad framing", s)
+	}
+
+	frame,err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("AVR '%s': %v", s, err)
+	} else if len(frame) != 2 && len(frame) != 7 && len(frame) != 14 {
+		return nil, fmt.Errorf("AVR '%s': frame is %d bytes", s, len(frame))
+	}
+	return frame, nil
+}
+
+// FormatAVR is the inverse of ParseAVR.
+func FormatAVR(frame []byte) string {
+	return "*" + strings.ToUpper(hex.EncodeToString(frame)) + ";"
+}
+
+// gillhamToModeC turns a Gillham coded altitude (laid out like a Mode A code, see
+// id13ToModeA) into hundreds of feet; it returns false if the code is invalid.
+func gillhamToModeC(modeA uint32) (int64, bool) {
+	if modeA & 0xFFFF8889 != 0 || modeA & 0x000000F0 == 0 {
+		return 0, false
+	}
+
+	oneHundreds, fiveHundreds := uint32(0), uint32(0)
+	if modeA & 0x0010 != 0 { oneHundreds ^= 0x007 } // C1
+	if modeA & 0x0020 != 0 { oneHundreds ^= 0x003 } // C2
+	if modeA & 0x0040 != 0 { oneHundreds ^= 0x001 } // C4
+	if oneHundreds & 5 == 5 { oneHundreds ^= 2 }    // Swap 7s and 5s
+	if oneHundreds > 5 {
+		return 0, false
+	}
+
+	if modeA & 0x0002 != 0 { fiveHundreds ^= 0x0FF } // D2
+	if modeA & 0x0004 != 0 { fiveHundreds ^= 0x07F } // D4
+	if modeA & 0x1000 != 0 { fiveHundreds ^= 0x03F } // A1
+	if modeA & 0x2000 != 0 { fiveHundreds ^= 0x01F } // A2
+	if modeA & 0x4000 != 0 { fiveHundreds ^= 0x00F } // A4
+	if modeA & 0x0100 != 0 { fiveHundreds ^= 0x007 } // B1
+	if modeA & 0x0200 != 0 { fiveHundreds ^= 0x003 } // B2
+	if modeA & 0x0400 != 0 { fiveHundreds ^= 0x001 } // B4
+
+	if fiveHundreds & 1 != 0 { oneHundreds = 6 - oneHundreds }
+
+	return int64(fiveHundreds*5 + oneHundreds) - 13, true
+}
+
+// id13ToModeA rearranges the 13 bit identity field (C1 A1 C2 A2 C4 A4 X B1 D1 B2 D2 B4 D4)
+// into a Mode A code, with one octal digit per hex nibble (so 0x7700 is squawk 7700).
+func id13ToModeA(id13 uint32) uint32 {
+	modeA := uint32(0)
+	for _,b := range []struct{ from,to uint32 }{
+		{0x1000, 0x0010}, {0x0800, 0x1000}, {0x0400, 0x0020}, {0x0200, 0x2000}, // C1 A1 C2 A2
+		{0x0100, 0x0040}, {0x0080, 0x4000}, {0x0020, 0x0100}, {0x0010, 0x0001}, // C4 A4 B1 D1
+		{0x0008, 0x0200}, {0x0004, 0x0002}, {0x0002, 0x0400}, {0x0001, 0x0004}, // B2 D2 B4 D4
+	} {
+		if id13 & b.from != 0 { modeA |= b.to }
+	}
+	return modeA
+}
+
+// decodeAC13 decodes a 13 bit altitude code, into feet.
+func decodeAC13(ac13 uint32) (int64, bool) {
+	if ac13 == 0 || ac13 & 0x0040 != 0 {
+		return 0, false // No altitude, or metric (which nobody uses)
+	}
+	if ac13 & 0x0010 != 0 {
+		// 25ft increments; remove the M & Q bits to get an 11 bit number
+		n := (ac13 & 0x1F80) >> 2 | (ac13 & 0x0020) >> 1 | (ac13 & 0x000F)
+		return int64(n)*25 - 1000, true
+	}
+	if n,ok := gillhamToModeC(id13ToModeA(ac13)); ok && n >= -12 {
+		return n * 100, true
+	}
+	return 0, false
+}
+
+// decodeAC12 decodes the 12 bit altitude code used in ADS-B position messages (which is an
+// AC13 with the M bit removed).
+func decodeAC12(ac12 uint32) (int64, bool) {
+	return decodeAC13((ac12 & 0x0FC0) << 1 | (ac12 & 0x003F))
+}
+
+const adsbCallsignChars = "#ABCDEFGHIJKLMNOPQRSTUVWXYZ##### ###############0123456789######"
+
+// bits returns n bits from data, starting at (1-indexed) bit 'first'.
+func bits(data []byte, first, n int) uint32 {
+	v := uint32(0)
+	for i:=first-1; i<first-1+n; i++ {
+		v = v<<1 | uint32(data[i/8] >> (7 - uint(i%8))) & 1
+	}
+	return v
+}
+
+// The NIC implied by the position message type codes, assuming the NIC supplements are
+// zero (which gives the lower NIC, where there is a choice).
+var (
+	airborneNIC = map[uint32]int64{9:11, 10:10, 11:8, 12:7, 13:6, 14:5, 15:4, 16:2, 17:1, 18:0, 20:11, 21:10, 22:0}
+	surfaceNIC  = map[uint32]int64{5:11, 6:10, 7:8, 8:0}
+)
+
+// decodeExtendedSquitter fills in the fields from the 56 bit ME field of a DF17/18 frame.
+func (m *Msg)decodeExtendedSquitter(me []byte) {
+	switch tc := bits(me, 1, 5); {
+	case tc >= 1 && tc <= 4:
+		m.SubType = sbsSubTypeIdentification
+		cs := ""
+		for i:=0; i<8; i++ {
+			cs += string(adsbCallsignChars[bits(me, 9+i*6, 6)])
+		}
+		m.Callsign = strings.TrimSpace(cs)
+		m.hasCallsign = true
+
+	case tc >= 5 && tc <= 8:
+		// Surface position; we can't get the position from a single frame.
+		m.SubType = sbsSubTypeSurfacePosition
+		m.IsOnGround, m.hasOnGround = true, true
+		m.SetNIC(surfaceNIC[tc])
+
+	case tc >= 9 && tc <= 18:
+		// Airborne position; we can only get the altitude from a single frame (see CPRDecoder).
+		m.SubType = sbsSubTypeAirbornePosition
+		m.Altitude, m.hasAltitude = decodeAC12(bits(me, 9, 12))
+		m.SetNIC(airborneNIC[tc])
+		m.cpr = cprFrame{lat:bits(me, 23, 17), lon:bits(me, 40, 17), odd:bits(me, 22, 1) == 1, valid:true}
+
+	case tc >= 20 && tc <= 22:
+		// Airborne position, with GNSS height instead of barometric altitude.
+		m.SubType = sbsSubTypeAirbornePosition
+		m.SetNIC(airborneNIC[tc])
+		m.cpr = cprFrame{lat:bits(me, 23, 17), lon:bits(me, 40, 17), odd:bits(me, 22, 1) == 1, valid:true}
+
+	case tc == 19:
+		m.SubType = sbsSubTypeAirborneVelocity
+		m.decodeAirborneVelocity(me)
+
+	case tc == 31:
+		// Operational status; there is no SBS-1 equivalent.
+		m.decodeOperationalStatus(me)
+	}
+}
+
+func (m *Msg)decodeOperationalStatus(me []byte) {
+	subtype := bits(me, 6, 3)
+	if subtype > 1 {
+		return // Reserved
+	}
+
+	version := int64(bits(me, 41, 3))
+	m.SetADSBVersion(version)
+	if version == 0 {
+		return // The rest of the message was only defined in version 1
+	}
+
+	m.SetNACp(int64(bits(me, 45, 4)))
+	m.SetSIL(int64(bits(me, 51, 2)))
+	if version >= 2 && subtype == 0 {
+		m.SetGVA(int64(bits(me, 49, 2)))
+	}
+}
+
+func (m *Msg)decodeAirborneVelocity(me []byte) {
+	subtype := bits(me, 6, 3)
+	m.SetNACv(int64(bits(me, 11, 3)))
+
+	switch subtype {
+	case 1,2: // Ground speed
+		vew, vns := float64(bits(me, 15, 10)), float64(bits(me, 26, 10))
+		if vew > 0 && vns > 0 {
+			vew, vns = vew-1, vns-1
+			if subtype == 2 { vew, vns = vew*4, vns*4 } // Supersonic
+			if bits(me, 14, 1) == 1 { vew = -vew }
+			if bits(me, 25, 1) == 1 { vns = -vns }
+
+			m.GroundSpeed = int64(math.Round(math.Hypot(vew, vns)))
+			m.Track = (int64(math.Round(math.Atan2(vew, vns) * 180 / math.Pi)) + 360) % 360
+			m.hasGroundSpeed, m.hasTrack = true, true
+		}
+
+	case 3,4: // Airspeed & heading
+		if bits(me, 14, 1) == 1 {
+			m.SetMagneticHeading(float64(bits(me, 15, 10)) * 360 / 1024)
+		}
+		if as := int64(bits(me, 26, 10)); as > 0 {
+			as--
+			if subtype == 4 { as *= 4 }
+			if bits(me, 25, 1) == 1 {
+				m.SetTrueAirspeed(as)
+			} else {
+				m.SetIndicatedAirspeed(as)
+			}
+		}
+	}
+
+	if vr := int64(bits(me, 38, 9)); vr > 0 {
+		m.VerticalRate = (vr - 1) * 64
+		if bits(me, 37, 1) == 1 { m.VerticalRate = -m.VerticalRate }
+		m.hasVerticalRate = true
+	}
+
+	if diff := int64(bits(me, 50, 7)); diff > 0 {
+		diff = (diff - 1) * 25
+		if bits(me, 49, 1) == 1 { diff = -diff }
+		m.SetGeomMinusBaro(diff)
+	}
+}
+
+// FromModeS decodes a raw Mode S frame (7 or 14 bytes), received at time t. The result is
+// given the SBS-1 type & subtype that dump1090 would use for it.
+//
+// The parity of DF11 & DF17 frames is checked. For DF4/5/20/21 the parity is overlaid with
+// the aircraft's address, so the address we recover is only as good as the frame; callers
+// may want to ignore addresses they haven't seen in other kinds of frame.
+func (m *Msg)FromModeS(frame []byte, t time.Time) error {
+	if len(frame) != 7 && len(frame) != 14 {
+		return fmt.Errorf("Mode S frame is %d bytes", len(frame))
+	}
+
+	df := int(frame[0] >> 3)
+	if df >= 16 && len(frame) != 14 {
+		return fmt.Errorf("DF%d frame is %d bytes", df, len(frame))
+	}
+
+	*m = Msg{
+		Type: "MSG",
+		GeneratedTimestampUTC: t.UTC(),
+		LoggedTimestampUTC: t.UTC(),
+	}
+
+	parity := frameParity(frame)
+
+	switch df {
+	case DFShortAltitude, DFCommBAltitude:
+		m.Source = SourceModeS
+		m.SubType = sbsSubTypeSurveillanceAlt
+		m.Icao24 = IcaoIdFromUint32(parity)
+		m.Altitude, m.hasAltitude = decodeAC13(bits(frame, 20, 13))
+
+	case DFShortIdentity, DFCommBIdentity:
+		m.Source = SourceModeS
+		m.SubType = sbsSubTypeSurveillanceID
+		m.Icao24 = IcaoIdFromUint32(parity)
+		m.Squawk, m.hasSquawk = fmt.Sprintf("%04x", id13ToModeA(bits(frame, 20, 13))), true
+
+	case DFAllCall:
+		if parity & 0xFFFF80 != 0 { // The bottom 7 bits may hold the interrogator's code
+			return fmt.Errorf("DF11 frame has bad parity (%06X)", parity)
+		}
+		m.Source = SourceModeS
+		m.SubType = sbsSubTypeAllCall
+		m.Icao24 = IcaoIdFromUint32(bits(frame, 9, 24))
+
+	case DFExtendedSquitter:
+		if parity != 0 {
+			return fmt.Errorf("DF17 frame has bad parity (%06X)", parity)
+		}
+		m.Source = SourceADSB
+		m.Icao24 = IcaoIdFromUint32(bits(frame, 9, 24))
+		m.decodeExtendedSquitter(frame[4:11])
+
+	case DFNonTransponder:
+		if parity != 0 {
+			return fmt.Errorf("DF18 frame has bad parity (%06X)", parity)
+		}
+		if err := m.decodeNonTransponder(frame); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("DF%d frames are not supported", df)
+	}
+
+	if df == DFCommBAltitude || df == DFCommBIdentity {
+		m.decodeCommB(frame[4:11])
+	}
+
+	// DF4/5/20/21 carry the flight status field
+	if df == DFShortAltitude || df == DFShortIdentity || df == DFCommBAltitude || df == DFCommBIdentity {
+		m.decodeFlightStatus(bits(frame, 6, 3))
+	}
+
+	return nil
+}
+
+// decodeNonTransponder handles DF18 frames; the CF field says what kind of system sent it,
+// and what kind of address it has. Non-ICAO addresses are masked (as dump1090 does).
+func (m *Msg)decodeNonTransponder(frame []byte) error {
+	addr := IcaoIdFromUint32(bits(frame, 9, 24))
+	masked := IcaoId(maskedPrefix) + addr
+
+	switch cf := bits(frame, 6, 3); cf {
+	case 0: m.Source, m.Icao24 = SourceADSB, addr   // ADS-B from a non-transponder device
+	case 1: m.Source, m.Icao24 = SourceADSB, masked // ... with an anonymous/non-ICAO address
+	case 2: m.Source, m.Icao24 = SourceTISB, addr   // Fine TIS-B
+	case 5: m.Source, m.Icao24 = SourceTISB, masked // Fine TIS-B, non-ICAO address
+	case 6: m.Source, m.Icao24 = SourceADSR, addr   // ADS-R
+	default:
+		return fmt.Errorf("DF18 frames with CF=%d are not supported", cf)
+	}
+
+	m.decodeExtendedSquitter(frame[4:11])
+	return nil
+}
+
+// decodeFlightStatus unpacks the 3 bit FS field.
+func (m *Msg)decodeFlightStatus(fs uint32) {
+	switch fs {
+	case 0: m.IsOnGround = false
+	case 1: m.IsOnGround = true
+	case 2: m.IsOnGround, m.AlertSquawkChange = false, true
+	case 3: m.IsOnGround, m.AlertSquawkChange = true, true
+	case 4: m.AlertSquawkChange, m.SPI = true, true
+	case 5: m.SPI = true
+	}
+	m.hasAlertSquawkChange, m.hasSPI = true, true
+	if fs <= 3 { m.hasOnGround = true }
+}
+
+// FromAVR decodes a line of AVR output, received at time t; see FromModeS and FromModeAC.
+func (m *Msg)FromAVR(s string, t time.Time) error {
+	frame,err := ParseAVR(s)
+	if err != nil {
+		return err
+	} else if len(frame) == 2 {
+		return m.FromModeAC(frame, t)
+	}
+	return m.FromModeS(frame, t)
+}
@@ -0,0 +1,145 @@
+package adsb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAVR(t *testing.T) {
+	tests := []struct {
+		In   string
+		Len  int
+		Err  bool
+	}{
+		{"*8D4840D6202CC371C32CE0576098;",             14, false},
+		{"@0123456789AB8D4840D6202CC371C32CE0576098;", 14, false},
+		{"*5D4840D6A1B2C3;",                           7,  false},
+		{"*2345;",                                      2,  false},
+		{"*8D4840D6202CC371C32CE0576098",              0,  true},
+		{"*8D4840D6202CC371C32CE05760;",               0,  true},
+		{"*8D4840D6202CC371C32CE05760ZZ;",             0,  true},
+		{"",                                           0,  true},
+	}
+
+	for i,test := range tests {
+		frame,err := ParseAVR(test.In)
+		if (err != nil) != test.Err || len(frame) != test.Len {
+			t.Errorf("[%d] %q: got %d bytes (%v)", i, test.In, len(frame), err)
+		}
+		if err == nil && test.In[0] == '*' && FormatAVR(frame) != test.In {
+			t.Errorf("[%d] %q: formatted as %q", i, test.In, FormatAVR(frame))
+		}
+	}
+}
+
+func TestAltitudeCodes(t *testing.T) {
+	tests := []struct {
+		AC13  uint32
+		Alt   int64
+		OK    bool
+	}{
+		{0x029C, 3300,  true},  // Q bit set: 25ft increments
+		{0x1FBF, 50175, true},
+		{0x0000, 0,     false}, // No altitude
+		{0x0040, 0,     false}, // Metric
+	}
+	for i,test := range tests {
+		if alt,ok := decodeAC13(test.AC13); alt != test.Alt || ok != test.OK {
+			t.Errorf("[%d] %04X: got %d,%v expected %d,%v", i, test.AC13, alt, ok, test.Alt, test.OK)
+		}
+	}
+
+	// Gillham codes, in Mode A layout. The C bits step through the hundreds in a Gray code,
+	// which reverses direction each time the 500s (D, A & B bits) step.
+	gillham := []struct {
+		ModeA uint32
+		Alt   int64
+	}{
+		{0x0040, -12}, // C4
+		{0x0060, -11}, // C2 C4
+		{0x0010, -8},  // C1
+		{0x0410, -7},  // B4 C1
+		{0x0420, -5},  // B4 C2
+	}
+	for i,test := range gillham {
+		if alt,ok := gillhamToModeC(test.ModeA); !ok || alt != test.Alt {
+			t.Errorf("[%d] %04X: got %d,%v expected %d", i, test.ModeA, alt, ok, test.Alt)
+		}
+	}
+	if _,ok := gillhamToModeC(0x0000); ok {
+		t.Errorf("Gillham code with no C bits was accepted")
+	}
+
+	if id := id13ToModeA(0x1FBF); id != 0x7777 {
+		t.Errorf("ID13 of all ones gave %04X", id)
+	}
+}
+
+func TestFromModeS(t *testing.T) {
+	tm := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	m := Msg{}
+	if err := m.FromAVR("*8D4840D6202CC371C32CE0576098;", tm); err != nil {
+		t.Fatal(err)
+	}
+	if m.Icao24 != "4840D6" || m.SubType != 1 || !m.HasCallsign() || m.Callsign != "KLM1023" {
+		t.Errorf("bad identification: %s %q", m, m.Callsign)
+	}
+	if !m.GeneratedTimestampUTC.Equal(tm) {
+		t.Errorf("bad time: %s", m.GeneratedTimestampUTC)
+	}
+
+	m = Msg{}
+	if err := m.FromAVR("*8D485020994409940838175B284F;", tm); err != nil {
+		t.Fatal(err)
+	}
+	if m.Icao24 != "485020" || m.SubType != 4 || m.GroundSpeed != 159 || m.Track != 183 || m.VerticalRate != -832 {
+		t.Errorf("bad velocity: %s, %dkt %ddeg %dft/min", m, m.GroundSpeed, m.Track, m.VerticalRate)
+	}
+	if !m.HasGeomMinusBaro() || m.GeomMinusBaro != 550 {
+		t.Errorf("bad GNSS-baro difference: %d", m.GeomMinusBaro)
+	}
+
+	// One bit flipped
+	if err := m.FromAVR("*8D485020994409940838175B284E;", tm); err == nil {
+		t.Errorf("corrupted DF17 was accepted")
+	}
+
+	// A DF20; the address comes from the parity
+	m = Msg{}
+	if err := m.FromAVR("*A000139381951536E024D4CCF6B5;", tm); err != nil {
+		t.Fatal(err)
+	}
+	if m.Icao24 != "3C4DD2" || m.SubType != 5 || !m.HasOnGround() || m.IsOnGround {
+		t.Errorf("bad DF20: %s", m)
+	}
+
+	// DF0 (short air-air surveillance) isn't supported
+	if err := m.FromAVR("*02E197B00179C3;", tm); err == nil {
+		t.Errorf("DF0 was accepted")
+	}
+}
+
+func TestReplyFrames(t *testing.T) {
+	tm := time.Now()
+
+	// The parity of DF4/5 is overlaid with the address
+	m := Msg{}
+	if err := m.FromAVR("*200008365914CA;", tm); err != nil || m.Icao24 != "A81BD0" || m.Altitude != 12350 ||
+		m.SubType != sbsSubTypeSurveillanceAlt || m.IsOnGround {
+		t.Errorf("bad DF4: %v %+v", err, m)
+	}
+
+	if err := m.FromAVR("*5DA81BD023FA66;", tm); err != nil || m.Icao24 != "A81BD0" || m.SubType != sbsSubTypeAllCall {
+		t.Errorf("bad DF11: %v %+v", err, m)
+	}
+
+	if err := m.FromAVR("*280004B2B66EAD;", tm); err != nil || m.Icao24 != "A81BD0" || m.Squawk != "4521" {
+		t.Errorf("bad DF5: %v %+v", err, m)
+	}
+
+	// One bit flipped in the address of a DF11, which has plain parity
+	if err := m.FromAVR("*5DA81BD123FA66;", tm); err == nil {
+		t.Errorf("corrupted DF11 was accepted")
+	}
+}
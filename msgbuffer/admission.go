@@ -0,0 +1,124 @@
+package msgbuffer
+
+import(
+	"time"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/geo"
+)
+
+// {{{ AdmissionPolicy{}
+
+// AdmissionPolicy decides which senders the buffer will start tracking, and what happens to
+// their messages before it does.
+type AdmissionPolicy struct {
+	// Messages from senders that haven't yet sent a position (e.g. the callsign packet that
+	// often arrives first) are cached for this long, and used to backfill the sender once
+	// it is admitted. 0==discard them, which was the original behaviour.
+	PreAdmitWindow  time.Duration
+
+	// Don't generate composites for a sender until we've seen its callsign, so that every
+	// output packet gets a callsign. Off by default, as some senders never send one (e.g.
+	// many MLAT targets).
+	RequireCallsign bool
+
+	Allow           map[adsb.IcaoId]bool // If non-empty, only these senders are admitted
+	Deny            map[adsb.IcaoId]bool // These senders are never admitted
+
+	// If not nil, a sender is only admitted if its first position lies inside this box. Once
+	// admitted, a sender stays admitted until it ages out.
+	Bounds          geo.LatlongBox
+
+	// Also generate composites for messages without a position (e.g. Mode S surveillance
+	// replies from aircraft without ADS-B, and Mode A/C replies), so that they can be used
+	// for MLAT or coverage statistics. Senders are then admitted on their first message (so
+	// Bounds is ignored). Mode A/C replies have no address, so they are passed through as-is,
+	// and only if the Allow list is empty. RequireCallsign still applies to Mode S senders.
+	AllowPositionless bool
+}
+
+// }}}
+
+// {{{ AdmissionPolicy.allowsIcao
+
+func (p AdmissionPolicy)allowsIcao(id adsb.IcaoId) bool {
+	if p.Deny[id] { return false }
+	if len(p.Allow) > 0 && !p.Allow[id] { return false }
+	return true
+}
+
+// }}}
+// {{{ AdmissionPolicy.admits
+
+// admits returns true if the message's sender should be whitelisted.
+func (p AdmissionPolicy)admits(m *adsb.Msg) bool {
+	if !p.allowsIcao(m.Icao24) { return false }
+	if !m.HasPosition() { return p.AllowPositionless }
+	if !p.Bounds.IsNil() && !p.Bounds.Contains(m.Position) { return false }
+	return true
+}
+
+// }}}
+
+// {{{ MsgBuffer.admit
+
+// admit handles a message from a sender that isn't in the whitelist. If the sender
+// qualifies, it gets whitelisted (picking up any pre-admission cache), and the newly created
+// sender is returned; else the message is (maybe) cached, and nil is returned.
+func (mb *MsgBuffer)admit(m *adsb.Msg) *ADSBSender {
+	if !mb.Admission.allowsIcao(m.Icao24) {
+		mb.stats.DroppedDenied++
+		return nil
+	}
+
+	if mb.Admission.admits(m) {
+		sender := &ADSBSender{}
+		if cached,exists := mb.pending[m.Icao24]; exists {
+			if time.Since(cached.LastSeen) < mb.Admission.PreAdmitWindow {
+				sender = cached
+			}
+			delete(mb.pending, m.Icao24)
+		}
+		sender.LastSeen = time.Now().UTC()
+		mb.Senders[m.Icao24] = sender
+		mb.stats.SendersAdmitted++
+		return sender
+	}
+
+	// Not admitted; cache anything useful, in case the sender gets admitted soon.
+	if mb.Admission.PreAdmitWindow > 0 && !m.HasPosition() {
+		if mb.pending == nil { mb.pending = make(map[adsb.IcaoId]*ADSBSender) }
+		if _,exists := mb.pending[m.Icao24]; !exists {
+			mb.pending[m.Icao24] = &ADSBSender{}
+		}
+		mb.pending[m.Icao24].updateFromMsg(m)
+		mb.stats.PreAdmitCached++
+	} else {
+		mb.stats.DroppedUnadmitted++
+	}
+
+	return nil
+}
+
+// }}}
+// {{{ MsgBuffer.ageOutPendingSenders
+
+func (mb *MsgBuffer)ageOutPendingSenders() (removed int64) {
+	for id,cached := range mb.pending {
+		if time.Since(cached.LastSeen) >= mb.Admission.PreAdmitWindow {
+			delete(mb.pending, id)
+			removed++
+		}
+	}
+	return
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,63 @@
+package msgbuffer
+
+import(
+	"unsafe"
+
+	"github.com/skypies/adsb"
+)
+
+// {{{ approxSize
+
+// approxSize is a rough guess at how much memory a buffered message is using.
+func approxSize(cm *adsb.CompositeMsg) int64 {
+	n := int64(unsafe.Sizeof(*cm)) + int64(unsafe.Sizeof(cm))
+	n += int64(len(cm.Type) + len(cm.Icao24) + len(cm.Callsign) + len(cm.Squawk) + len(cm.ReceiverName))
+	return n
+}
+
+// }}}
+
+// {{{ MsgBuffer.isOverLimit
+
+func (mb *MsgBuffer)isOverLimit() bool {
+	if mb.MaxMessages > 0 && len(mb.Messages) > mb.MaxMessages { return true }
+	if mb.MaxBytes > 0 && mb.numBytes > mb.MaxBytes { return true }
+	return false
+}
+
+// }}}
+// {{{ MsgBuffer.enforceLimits
+
+// enforceLimits gets the buffer back under its size limits, by flushing early (if configured
+// to), or by discarding the oldest messages.
+func (mb *MsgBuffer)enforceLimits() {
+	if !mb.isOverLimit() {
+		return
+	}
+
+	if mb.FlushOnFull {
+		n := int64(len(mb.Messages))
+		if mb.flush() {
+			mb.NumForceFlushed += n
+			return
+		}
+	}
+
+	// Either we're not flushing, or the flush didn't work out; make room.
+	for len(mb.Messages) > 0 && mb.isOverLimit() {
+		mb.numBytes -= approxSize(mb.Messages[0])
+		mb.Messages[0] = nil
+		mb.Messages = mb.Messages[1:]
+		mb.NumDropped++
+	}
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
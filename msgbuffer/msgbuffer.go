@@ -1,14 +1,27 @@
 /* Package msgbuffer is a temporary buffer of ADS-B messages.
 
 It uses a strict whitelist approach, so that it can immediately
-discard irrelevant messages.
+discard irrelevant messages. Senders are whitelisted once they send a
+position; the AdmissionPolicy can restrict this further (by Icao24,
+or geographically), and can cache the data from a sender's earlier
+messages so that its very first composite is complete. It can also
+let through messages that will never have a position (Mode S
+surveillance replies, and Mode A/C), for MLAT or coverage statistics.
 
 It caches useful data from previous messages, and generates
-'composite' output messages with full data (i.e. every output packet
-gets a callsign).
+'composite' output messages, backfilled with whatever the sender has
+reported so far (callsign, speed, squawk, etc). A composite is made
+for every new position, even if no callsign has been seen yet; set
+the AdmissionPolicy's RequireCallsign to hold them back until one
+is. Positionless composites (see above) have no position, and may
+have little else.
 
 When a maximum age limit is reached, the slice of accumulated messages
-are sent down a channel.
+are sent down a channel. The buffer can also be bounded (by message
+count, or approximate size in bytes); when it fills up, it either
+flushes early or drops its oldest messages. An Enricher (e.g. an
+aircraftdb.DB) can fill in details about each aircraft as the
+messages leave the buffer.
 
 It contains enough memory housekeeping to be used indefinitely.
 
@@ -31,6 +44,7 @@ package msgbuffer
 
 import(
 	"fmt"
+	"sync"
 	"time"
 	"github.com/skypies/adsb"
 )
@@ -45,6 +59,12 @@ type ADSBSender struct {
 	LastTrack         int64
 	LastCallsign      string
 	LastSquawk        string
+	LastEmergency     bool
+	LastSPI           bool
+	LastGeomMinusBaro int64
+	hasGeomMinusBaro  bool
+	LastEHS           adsb.EHS // Comm-B data; each field is kept (with its time) until it is next reported
+	LastQuality       adsb.Quality
 }
 
 func (s ADSBSender)String() string {
@@ -60,16 +80,34 @@ type MsgBuffer struct {
 	MinPublishInterval time.Duration  // Regardless of all else, don't publish faster than this
 	MaxMessageAge      time.Duration  // If we've held a message for more than this, flush the buffer
 	MaxQuietTime       time.Duration  // If a sender sends no messages for this long, remove it
+	Admission          AdmissionPolicy
+	Enricher           adsb.Enricher  // If not nil, fills in extra data as messages are flushed
+
+	MaxMessages        int            // If >0, never hold more than this many messages
+	MaxBytes           int64          // If >0, never hold more than (roughly) this much message data
+	FlushOnFull        bool           // When full, flush regardless of age; else drop the oldest
+	FlushTimeout       time.Duration  // If >0, give up on a blocked FlushChannel after this long
+	FlushRetryInterval time.Duration  // After giving up on a blocked FlushChannel, wait this long to retry
+
+	NumDropped         int64          // Messages discarded because the buffer was full
+	NumForceFlushed    int64          // Messages flushed early because the buffer was full
+	NumFlushTimeouts   int64          // Flushes abandoned because FlushChannel was blocked
 
 	Senders            map[adsb.IcaoId]*ADSBSender // Alive things we're currently getting data from
 	Messages        []*adsb.CompositeMsg           // The actual buffer of messages
 
 	FlushChannel       chan<- []*adsb.CompositeMsg
+	pending            map[adsb.IcaoId]*ADSBSender // Cached data from senders not yet whitelisted
+	numBytes           int64                       // Approx size of Messages
+	stats              Stats                       // Live counters; only touched by Add
+	published          Stats                       // Snapshot of stats, for other goroutines
+	statsMu            sync.Mutex                  // Protects published
 	lastFlush          time.Time
+	lastFlushTimeout   time.Time                   // When we last gave up on FlushChannel
 	lastAgeOut         time.Time
 }
 
-func (mb MsgBuffer)String() string {
+func (mb *MsgBuffer)String() string {
 	s := fmt.Sprintf("--{ MsgBuffer (maxage=%s, maxwait=%s, minpub=%s) }--\n",
 		mb.MaxMessageAge, mb.MaxQuietTime, mb.MinPublishInterval)
 	for k,sender := range mb.Senders { s += fmt.Sprintf(" - %s %s\n", k, sender) }
@@ -86,7 +124,12 @@ func NewMsgBuffer() *MsgBuffer {
 		MinPublishInterval:  time.Second * 5,
 		MaxMessageAge:       time.Second * 30,
 		MaxQuietTime:        time.Second * 360,
+		FlushRetryInterval:  time.Second * 5,
+		Admission:           AdmissionPolicy{
+			PreAdmitWindow:    time.Second * 30,
+		},
 		Senders: make(map[adsb.IcaoId]*ADSBSender),
+		pending: make(map[adsb.IcaoId]*ADSBSender),
 	}
 }
 
@@ -105,13 +148,18 @@ func (s *ADSBSender)updateFromMsg(m *adsb.Msg) {
 		if len(m.Callsign) > 0 {
 			s.LastCallsign = m.Callsign
 		} else {
-			s.LastCallsign = "_._._._." // Our nil value :/
+			s.LastCallsign = adsb.NilCallsign
 		}
 	}
 	if m.HasSquawk()        { s.LastSquawk        = m.Squawk }
 	if m.HasGroundSpeed()   { s.LastGroundSpeed   = m.GroundSpeed }
 	if m.HasTrack()         { s.LastTrack         = m.Track }
 	if m.HasVerticalRate()  { s.LastVerticalSpeed = m.VerticalRate }
+	if m.HasEmergency()     { s.LastEmergency     = m.Emergency }
+	if m.HasSPI()           { s.LastSPI           = m.SPI }
+	if m.HasGeomMinusBaro() { s.LastGeomMinusBaro, s.hasGeomMinusBaro = m.GeomMinusBaro, true }
+	s.LastEHS.UpdateAt(m.EHS, m.GeneratedTimestampUTC)
+	s.LastQuality.Update(m.Quality)
 	
 	if m.Type == "MSG_foooo" {
 		if m.SubType == 1 {
@@ -120,7 +168,7 @@ func (s *ADSBSender)updateFromMsg(m *adsb.Msg) {
 			// don't really want to confuse flights that have a purposefully
 			// blank callsign with those for yet we've yet to receive a MSG,1.
 			// So we use a magic string instead.
-			if m.Callsign == ""     { s.LastCallsign      = "_._._._." }
+			if m.Callsign == ""     { s.LastCallsign      = adsb.NilCallsign }
 			if m.Callsign != ""     { s.LastCallsign      = m.Callsign }
 		
 		} else if m.SubType == 2 {
@@ -141,10 +189,14 @@ func (s *ADSBSender)updateFromMsg(m *adsb.Msg) {
 // }}}
 // {{{ ADSBSender.maybeCreateComposite
 
-// If this message has new position info, *and* we have good backfill, then craft a CompositeMsg.
-// Note, we don't wait for squawk info.
-func (s *ADSBSender)maybeCreateComposite(m *adsb.Msg) *adsb.CompositeMsg {
-	if !m.HasPosition() {
+// If this message has new position info (or we've been asked to allow positionless messages),
+// *and* we have good backfill, then craft a CompositeMsg.
+// Note, we don't wait for squawk info; but we can be asked to wait for the callsign.
+func (s *ADSBSender)maybeCreateComposite(m *adsb.Msg, requireCallsign, allowPositionless bool) *adsb.CompositeMsg {
+	if !m.HasPosition() && !allowPositionless {
+		return nil
+	}
+	if requireCallsign && s.LastCallsign == "" {
 		return nil
 	}
 
@@ -158,6 +210,11 @@ func (s *ADSBSender)maybeCreateComposite(m *adsb.Msg) *adsb.CompositeMsg {
 	if cm.Track == 0        { cm.Track        = s.LastTrack }
 	if cm.Callsign == ""    { cm.Callsign     = s.LastCallsign }
 	if cm.Squawk == ""      { cm.Squawk       = s.LastSquawk }
+	if !m.HasEmergency()    { cm.Emergency    = s.LastEmergency }
+	if !m.HasSPI()          { cm.SPI          = s.LastSPI }
+	if !m.HasGeomMinusBaro() && s.hasGeomMinusBaro { cm.SetGeomMinusBaro(s.LastGeomMinusBaro) }
+	cm.EHS = s.LastEHS // Already includes anything from this message; fields know how old they are
+	cm.Quality = s.LastQuality
 	
 	return &cm
 }
@@ -176,6 +233,8 @@ func (mb *MsgBuffer)ageOutQuietSenders() (removed int64) {
 			removed++
 		}
 	}
+	mb.ageOutPendingSenders()
+	mb.stats.SendersAgedOut += removed
 
 	return
 }
@@ -183,38 +242,97 @@ func (mb *MsgBuffer)ageOutQuietSenders() (removed int64) {
 // }}}
 // {{{ MsgBuffer.flush
 
-func (mb *MsgBuffer)flush() {
+// flush returns false if the messages could not be handed off, in which case they stay in the
+// buffer. If the last attempt timed out, we don't try again until FlushRetryInterval has
+// passed; otherwise every Add would block for another FlushTimeout while the consumer is stuck.
+func (mb *MsgBuffer)flush() bool {
+	if mb.FlushTimeout > 0 && time.Since(mb.lastFlushTimeout) < mb.FlushRetryInterval {
+		return false
+	}
+	return mb.send()
+}
+
+// send hands the messages off, waiting up to FlushTimeout (if set); it returns false if that
+// timed out, leaving the messages in the buffer.
+func (mb *MsgBuffer)send() bool {
+	mb.publishStats() // In case we block for a while
+	start := time.Now()
+
+	if mb.Enricher != nil {
+		for _,cm := range mb.Messages {
+			if cm.Aircraft == nil { mb.Enricher.Enrich(cm) } // May have been enriched before a timeout
+		}
+	}
+
 	if mb.FlushChannel != nil {
-		mb.FlushChannel <- mb.Messages
+		if mb.FlushTimeout > 0 {
+			select {
+			case mb.FlushChannel <- mb.Messages:
+			case <-time.After(mb.FlushTimeout):
+				mb.NumFlushTimeouts++
+				mb.lastFlushTimeout = time.Now()
+				return false
+			}
+		} else {
+			mb.FlushChannel <- mb.Messages
+		}
 	}
 
+	mb.stats.recordFlush(len(mb.Messages), time.Since(start))
+
 	// Reset the accumulator
 	mb.Messages = []*adsb.CompositeMsg{}
+	mb.numBytes = 0
 	mb.lastFlush = time.Now()
+	return true
 }
 
 // }}}
 
+// {{{ MsgBuffer.addComposite
+
+func (mb *MsgBuffer)addComposite(cm *adsb.CompositeMsg) {
+	mb.stats.CompositesBuilt++
+	if !cm.HasPosition() { mb.stats.Positionless++ }
+	mb.Messages = append(mb.Messages, cm)
+	mb.numBytes += approxSize(cm)
+	mb.enforceLimits()
+}
+
+// }}}
 // {{{ MsgBuffer.Add
 
 // MaybeAdd looks at a new message, and updates the buffer as appropriate.
 func (mb *MsgBuffer)Add(m *adsb.Msg) {
+	defer mb.publishStats()
+	mb.stats.MessagesIn++
 
 	mb.ageOutQuietSenders()
-	
-	if _,exists := mb.Senders[m.Icao24]; exists == false {
-		// We've not seen this sender before. If we have position data,
-		// start the whitelisting thing. We only Whitelist senders who
-		// will eventually send useful info (e.g. position), so wait until
-		// we see that.
-		if m.HasPosition() {
-			mb.Senders[m.Icao24] = &ADSBSender{LastSeen: time.Now().UTC()}
+
+	if m.Icao24 == "" {
+		// Mode A/C replies; there is no sender to track, or to backfill from.
+		if mb.Admission.AllowPositionless && len(mb.Admission.Allow) == 0 {
+			mb.addComposite(&adsb.CompositeMsg{Msg:*m})
+		} else {
+			mb.stats.DroppedUnadmitted++
 		}
+
 	} else {
-		mb.Senders[m.Icao24].updateFromMsg(m) // Pluck out anything interesting
-		if composite := mb.Senders[m.Icao24].maybeCreateComposite(m); composite != nil {
-			// We have a message to store !!
-			mb.Messages = append(mb.Messages, composite)
+		sender,exists := mb.Senders[m.Icao24]
+		if exists == false {
+			// We've not seen this sender before. We only whitelist senders
+			// who will eventually send useful info (e.g. position), so wait
+			// until we see that (caching any earlier data, as per the policy).
+			sender = mb.admit(m)
+		}
+
+		if sender != nil {
+			sender.updateFromMsg(m) // Pluck out anything interesting
+			composite := sender.maybeCreateComposite(m, mb.Admission.RequireCallsign,
+				mb.Admission.AllowPositionless)
+			if composite != nil {
+				mb.addComposite(composite) // We have a message to store !!
+			}
 		}
 	}
 
@@ -237,8 +355,21 @@ func (mb *MsgBuffer)Add(m *adsb.Msg) {
 // }}}
 // {{{ MsgBuffer.FinalFlush
 
-func (mb *MsgBuffer)FinalFlush() {
-	mb.flush()
+// FinalFlush hands off whatever is left in the buffer, e.g. at shutdown. It doesn't back off
+// after an earlier timeout; it tries once more, waiting up to FlushTimeout. If that times out
+// too, the messages are dropped (and counted in Stats.DroppedFinalFlush), and an error is
+// returned.
+func (mb *MsgBuffer)FinalFlush() error {
+	var err error
+	if !mb.send() {
+		n := len(mb.Messages)
+		mb.stats.DroppedFinalFlush += int64(n)
+		mb.Messages = []*adsb.CompositeMsg{}
+		mb.numBytes = 0
+		err = fmt.Errorf("final flush timed out after %s; dropped %d messages", mb.FlushTimeout, n)
+	}
+	mb.publishStats()
+	return err
 }
 
 // }}}
@@ -4,11 +4,14 @@ package msgbuffer
 import (
 	"bufio"
 	"fmt"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/skypies/adsb"
+	"github.com/skypies/adsb/simulator"
+	"github.com/skypies/geo"
 )
 
 func msgs(sbs string) (ret []adsb.Msg) {
@@ -38,9 +41,17 @@ MSG,3,1,1,A81BD0,1,2015/11/27,21:31:05.274,2015/11/27,21:31:05.276,,20075,,,36.7
 	unrelatedSBS = `MSG,7,1,1,ABEEF0,1,2015/11/27,21:31:04.753,2015/11/27,21:31:04.752,,20100,,,,,,,,,,0`
 )
 
+// newCallsignBuffer returns a buffer that waits for a callsign; the fixtures above have
+// position packets before the callsign, and most tests expect just the one after it.
+func newCallsignBuffer() *MsgBuffer {
+	mb := NewMsgBuffer()
+	mb.Admission.RequireCallsign = true
+	return mb
+}
+
 func TestAdd(t *testing.T) {
 	m := msgs(maybeAddSBS)
-	mb := NewMsgBuffer()
+	mb := newCallsignBuffer()
 
 	mb.Add(&m[0])
 	if len(mb.Senders) != 0 { t.Errorf("accepted boring packet as new sender") }
@@ -112,7 +123,7 @@ func TestAgeOutQuietSenders(t *testing.T) {
 
 
 func TestFlush(t *testing.T) {
-	mb := NewMsgBuffer()
+	mb := newCallsignBuffer()
 
 	ch := make(chan []*adsb.CompositeMsg, 3)
 
@@ -127,3 +138,309 @@ func TestFlush(t *testing.T) {
 
 	if len(ch) != 2 { t.Errorf("channel does not have two items (has %d)", len(ch)) }
 }
+
+type fakeEnricher struct{ n int }
+
+func (e *fakeEnricher)Enrich(cm *adsb.CompositeMsg) {
+	e.n++
+	cm.Aircraft = &adsb.AircraftInfo{Registration:"N-TEST"}
+}
+
+func TestEnricher(t *testing.T) {
+	mb := newCallsignBuffer()
+	ch := make(chan []*adsb.CompositeMsg, 3)
+	e := fakeEnricher{}
+
+	mb.FlushChannel = ch
+	mb.Enricher = &e
+	mb.MaxMessageAge,mb.MinPublishInterval = 0,0
+
+	for _,msg := range msgs(maybeAddSBS) {
+		mb.Add(&msg)
+	}
+
+	if len(ch) != 1 { t.Fatalf("channel does not have one item (has %d)", len(ch)) }
+	out := <-ch
+	if e.n != 1 || out[0].Aircraft == nil || out[0].Aircraft.Registration != "N-TEST" {
+		t.Errorf("message not enriched (%d calls): %v", e.n, out[0].Aircraft)
+	}
+}
+
+func TestGeomMinusBaroBackfill(t *testing.T) {
+	m := msgs(maybeAddSBS)
+	m[3].SetGeomMinusBaro(-250) // The velocity message
+	mb := newCallsignBuffer()
+
+	for i,_ := range m {
+		mb.Add(&m[i])
+	}
+
+	if len(mb.Messages) != 1 { t.Fatalf("expected 1 message, got %d", len(mb.Messages)) }
+	if cm := mb.Messages[0]; !cm.HasGeomMinusBaro() || cm.GeomMinusBaro != -250 {
+		t.Errorf("GeomMinusBaro not backfilled: %v", cm.GeomMinusBaro)
+	}
+}
+
+func TestEHSBackfill(t *testing.T) {
+	m := msgs(maybeAddSBS)
+	m[4].SetSelectedAltitude(8000) // An altitude message
+
+	// A Comm-B reply, from the same aircraft
+	commB := adsb.Msg{}
+	if err := commB.FromAVR("*A00004128F39F91A7E27C46ADC21;", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	commB.Icao24 = m[0].Icao24
+
+	mb := newCallsignBuffer()
+	for i,_ := range m {
+		if i == 5 { mb.Add(&commB) }
+		mb.Add(&m[i])
+	}
+
+	if len(mb.Messages) != 1 { t.Fatalf("expected 1 message, got %d", len(mb.Messages)) }
+	cm := mb.Messages[0]
+	if !cm.HasSelectedAltitude() || cm.SelectedAltitude != 8000 {
+		t.Errorf("selected altitude not backfilled: %+v", cm.EHS)
+	}
+	if !cm.HasIndicatedAirspeed() || cm.IndicatedAirspeed != 252 {
+		t.Errorf("IAS not backfilled: %+v", cm.EHS)
+	}
+	if !cm.IndicatedAirspeedTime().Equal(commB.GeneratedTimestampUTC) {
+		t.Errorf("IAS has the wrong time: %s", cm.IndicatedAirspeedTime())
+	}
+	if !cm.SelectedAltitudeTime().Equal(m[4].GeneratedTimestampUTC) {
+		t.Errorf("selected altitude has the wrong time: %s", cm.SelectedAltitudeTime())
+	}
+}
+
+func TestPreAdmissionCache(t *testing.T) {
+	m := msgs(maybeAddSBS)
+
+	mb := NewMsgBuffer()
+	mb.Add(&m[5]) // Callsign, before any position
+	mb.Add(&m[3]) // Velocity
+	if len(mb.Senders) != 0 { t.Errorf("callsign packet admitted sender") }
+
+	mb.Add(&m[1])
+	if len(mb.Senders) != 1 { t.Errorf("did not admit pos packet as new sender") }
+	if len(mb.Messages) != 1 {
+		t.Fatalf("first pos packet not emitted, despite cached callsign")
+	}
+	if cm := mb.Messages[0]; cm.Callsign != "VRD961" || cm.GroundSpeed != 304 {
+		t.Errorf("first composite not backfilled from cache: %s", cm)
+	}
+
+	mb = newCallsignBuffer()
+	mb.Admission.PreAdmitWindow = 0
+	mb.Add(&m[5])
+	mb.Add(&m[1])
+	if len(mb.Messages) != 0 { t.Errorf("cache used, even though disabled") }
+}
+
+func TestNoCallsign(t *testing.T) {
+	m := msgs(maybeAddSBS)
+
+	// An MLAT target that never sends a MSG,1 still gets composites, unless asked otherwise
+	mb := NewMsgBuffer()
+	mb.Add(&m[1])
+	mb.Add(&m[2])
+	if len(mb.Messages) != 2 { t.Errorf("expected 2 composites without a callsign, got %d", len(mb.Messages)) }
+}
+
+func TestAdmissionFilters(t *testing.T) {
+	m := msgs(maybeAddSBS)
+
+	mb := NewMsgBuffer()
+	mb.Admission.Deny = map[adsb.IcaoId]bool{"A81BD0":true}
+	mb.Add(&m[1])
+	if len(mb.Senders) != 0 { t.Errorf("admitted denied sender") }
+
+	mb = NewMsgBuffer()
+	mb.Admission.Allow = map[adsb.IcaoId]bool{"ABEEF0":true}
+	mb.Add(&m[1])
+	if len(mb.Senders) != 0 { t.Errorf("admitted sender not on allow list") }
+
+	mb = NewMsgBuffer()
+	mb.Admission.Bounds = geo.Latlong{Lat:37.0, Long:-122.0}.Box(10, 10)
+	mb.Add(&m[1])
+	if len(mb.Senders) != 0 { t.Errorf("admitted sender outside bounds") }
+
+	mb.Admission.Bounds = geo.Latlong{Lat:36.7, Long:-121.86}.Box(10, 10)
+	mb.Add(&m[1])
+	if len(mb.Senders) != 1 { t.Errorf("did not admit sender inside bounds") }
+}
+
+func TestPositionless(t *testing.T) {
+	m := msgs(maybeAddSBS)
+	modeAC := adsb.Msg{}
+	if err := modeAC.FromAVR("*0420;", time.Now()); err != nil { t.Fatal(err) }
+
+	mb := NewMsgBuffer()
+	mb.Add(&modeAC)
+	mb.Add(&m[4])
+	if len(mb.Messages) != 0 || len(mb.Senders) != 0 { t.Errorf("positionless msgs accepted by default") }
+
+	mb = NewMsgBuffer()
+	mb.Admission.AllowPositionless = true
+	mb.Add(&modeAC)
+	mb.Add(&m[4]) // Altitude only
+	mb.Add(&m[3]) // Velocity
+	if len(mb.Senders) != 1 { t.Errorf("positionless sender not admitted") }
+	if len(mb.Messages) != 3 { t.Fatalf("expected 3 msgs, got %d", len(mb.Messages)) }
+	if cm := mb.Messages[0]; !cm.IsModeAC() || cm.Squawk != "0420" || cm.Altitude != -500 {
+		t.Errorf("bad Mode A/C composite: %s", cm)
+	}
+	if cm := mb.Messages[2]; cm.HasPosition() || cm.Altitude != 0 || cm.GroundSpeed != 304 {
+		t.Errorf("bad positionless composite: %s", cm)
+	}
+	if s := mb.Stats(); s.Positionless != 3 || s.CompositesBuilt != 3 {
+		t.Errorf("bad stats: %+v", s)
+	}
+
+	mb.Admission.Allow = map[adsb.IcaoId]bool{"A81BD0":true}
+	mb.Add(&modeAC)
+	if len(mb.Messages) != 3 { t.Errorf("Mode A/C accepted despite allow list") }
+}
+
+func TestBufferLimits(t *testing.T) {
+	messages := msgs(maybeAddSBS)
+	pos := messages[len(messages)-1]
+
+	mb := newCallsignBuffer()
+	mb.MaxMessages = 3
+	for _,msg := range messages { mb.Add(&msg) }
+	for i:=0; i<5; i++ { mb.Add(&pos) }
+	if len(mb.Messages) != 3 { t.Errorf("buffer not bounded (has %d)", len(mb.Messages)) }
+	if mb.NumDropped != 3 { t.Errorf("expected 3 dropped, saw %d", mb.NumDropped) }
+
+	ch := make(chan []*adsb.CompositeMsg, 1)
+	mb = newCallsignBuffer()
+	mb.FlushChannel = ch
+	mb.MaxBytes = 3 * approxSize(&adsb.CompositeMsg{Msg:pos})
+	mb.FlushOnFull = true
+	mb.FlushTimeout = time.Millisecond
+	for _,msg := range messages { mb.Add(&msg) }
+	for i:=0; i<3; i++ { mb.Add(&pos) }
+	if len(ch) != 1 { t.Fatalf("buffer did not flush when full") }
+	if flushed := <-ch; int64(len(flushed)) != mb.NumForceFlushed {
+		t.Errorf("flushed %d, but counted %d", len(flushed), mb.NumForceFlushed)
+	}
+
+	// Now block the channel; the buffer should time out, then drop.
+	ch <- nil
+	for i:=0; i<6; i++ { mb.Add(&pos) }
+	if mb.NumFlushTimeouts == 0 { t.Errorf("blocked channel did not time out") }
+	if mb.NumDropped == 0 || mb.isOverLimit() { t.Errorf("blocked buffer did not drop") }
+}
+
+func TestFlushRetry(t *testing.T) {
+	pos := msgs(maybeAddSBS)[1]
+	ch := make(chan []*adsb.CompositeMsg) // Nobody is reading
+	mb := NewMsgBuffer()
+	mb.FlushChannel = ch
+	mb.MaxMessageAge,mb.MinPublishInterval = 0,0
+	mb.FlushTimeout = time.Millisecond * 50
+
+	start := time.Now()
+	for i:=0; i<20; i++ { mb.Add(&pos) }
+	if mb.NumFlushTimeouts != 1 { t.Errorf("expected 1 timeout, saw %d", mb.NumFlushTimeouts) }
+	if time.Since(start) > time.Millisecond * 500 { t.Errorf("blocked for %s", time.Since(start)) }
+	if len(mb.Messages) != 20 { t.Errorf("lost messages while backing off: %d", len(mb.Messages)) }
+
+	// Once the retry interval has passed, we try again
+	mb.FlushRetryInterval = 0
+	mb.Add(&pos)
+	if mb.NumFlushTimeouts != 2 { t.Errorf("did not retry; %d timeouts", mb.NumFlushTimeouts) }
+}
+
+func TestFinalFlushAfterTimeout(t *testing.T) {
+	pos := msgs(maybeAddSBS)[1]
+	ch := make(chan []*adsb.CompositeMsg)
+	mb := NewMsgBuffer()
+	mb.FlushChannel = ch
+	mb.MaxMessageAge,mb.MinPublishInterval = 0,0
+	mb.FlushTimeout = time.Millisecond * 50
+
+	for i:=0; i<5; i++ { mb.Add(&pos) } // Times out, then backs off
+	if mb.NumFlushTimeouts != 1 { t.Fatalf("expected 1 timeout, saw %d", mb.NumFlushTimeouts) }
+
+	// The consumer is back; the final flush should not be held back by the backoff
+	done := make(chan int)
+	go func() { done <- len(<-ch) }()
+	if err := mb.FinalFlush(); err != nil { t.Errorf("final flush failed: %v", err) }
+	if n := <-done; n != 5 { t.Errorf("final flush sent %d messages", n) }
+
+	// If the consumer is still stuck, the messages are dropped, and we say so
+	for i:=0; i<3; i++ { mb.Add(&pos) }
+	if err := mb.FinalFlush(); err == nil { t.Errorf("stuck final flush did not fail") }
+	if s := mb.Stats(); s.DroppedFinalFlush != 3 || s.BufferedMessages != 0 {
+		t.Errorf("drops not counted: %+v", s)
+	}
+}
+
+func TestStats(t *testing.T) {
+	ch := make(chan []*adsb.CompositeMsg, 3)
+	mb := newCallsignBuffer()
+	mb.FlushChannel = ch
+	mb.MaxMessageAge,mb.MinPublishInterval = 0,0
+
+	messages := msgs(maybeAddSBS)
+	for _,msg := range messages {
+		mb.Add(&msg)
+	}
+
+	s := mb.Stats()
+	if s.MessagesIn != int64(len(messages)) { t.Errorf("MessagesIn: %d", s.MessagesIn) }
+	if s.SendersAdmitted != 1 || s.Senders != 1 { t.Errorf("senders: %s", s) }
+	if s.CompositesBuilt != 1 || s.MessagesOut != 1 || s.Flushes != 1 { t.Errorf("flushes: %s", s) }
+	if s.BufferedMessages != 0 { t.Errorf("depth: %s", s) }
+
+	rec := httptest.NewRecorder()
+	mb.ServeMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "msgbuffer_messages_out_total 1\n") {
+		t.Errorf("metrics output lacked messages_out:\n%s", body)
+	}
+}
+
+func TestSimulatedTraffic(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	sfo := geo.Latlong{Lat:37.6188, Long:-122.3754}
+	ac := simulator.Aircraft{
+		Icao24: "A81BD0", Callsign: "VRD961", Squawk: "1200", Start: start,
+		Route: []simulator.Waypoint{
+			{Position:sfo, AltitudeFt:2000, SpeedKnots:200},
+			{Position:sfo.MoveKM(135, 40), AltitudeFt:9000},
+		},
+	}
+	sim := simulator.NewSimulator(1)
+	sim.Aircraft = []simulator.Aircraft{ac}
+	sim.Receivers = []simulator.Receiver{{Name:"lossy", DropRate:0.2}}
+
+	ch := make(chan []*adsb.CompositeMsg, 1)
+	mb := newCallsignBuffer()
+	mb.FlushChannel = ch
+	mb.MaxMessageAge = time.Hour * 24 // Only flush at the end
+
+	nPos, seenCallsign := 0, false
+	for _,r := range sim.Generate(start, ac.End()) {
+		m,err := r.Msg()
+		if err != nil { t.Fatal(err) }
+		if m.SubType == 1 { seenCallsign = true }
+		if m.SubType == 3 && seenCallsign { nPos++ }
+		mb.Add(&m)
+	}
+	mb.FinalFlush()
+
+	out := <-ch
+	if len(out) != nPos { t.Errorf("%d composites, from %d positions after the callsign", len(out), nPos) }
+	for i,cm := range out {
+		s,_ := ac.StateAt(cm.GeneratedTimestampUTC)
+		if cm.Callsign != "VRD961" || cm.Squawk != "1200" || cm.Position.DistKM(s.Position) > 0.01 {
+			t.Errorf("[%d] bad composite: %s", i, cm)
+		}
+	}
+	if cm := out[len(out)-1]; cm.GroundSpeed != 200 || cm.VerticalRate <= 0 {
+		t.Errorf("velocity not backfilled: %dkt, %dft/min", cm.GroundSpeed, cm.VerticalRate)
+	}
+}
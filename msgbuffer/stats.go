@@ -0,0 +1,162 @@
+package msgbuffer
+
+import(
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// {{{ Stats{}
+
+// Stats follow the messages passed to Add: how many turned into composites, how many of
+// those were flushed, and why the rest were dropped or cached. Everything is a running total
+// since the buffer was created, apart from the final group, which describe the senders and
+// composites being held right now.
+type Stats struct {
+	MessagesIn        int64         // Messages passed to Add
+	CompositesBuilt   int64         // Composite messages added to the buffer
+	Positionless      int64         // ... of which had no position (see AllowPositionless)
+	MessagesOut       int64         // Composite messages successfully flushed
+
+	SendersAdmitted   int64
+	SendersAgedOut    int64
+	PreAdmitCached    int64         // Messages cached from senders not yet admitted
+
+	Flushes           int64
+	FlushLatency      time.Duration // Total time spent handing flushes to the FlushChannel
+	MaxFlushLatency   time.Duration
+	LastFlush         time.Time
+
+	DroppedDenied     int64         // Messages from senders excluded by the Allow/Deny lists
+	DroppedUnadmitted int64         // Messages from senders not admitted, that were not cached
+	DroppedBufferFull int64         // Composites discarded because the buffer was full
+	ForceFlushed      int64         // Composites flushed early because the buffer was full
+	FlushTimeouts     int64         // Flushes abandoned because the FlushChannel was blocked
+	DroppedFinalFlush int64         // Composites discarded because the FinalFlush timed out
+
+	Senders           int           // Number of senders currently whitelisted
+	PendingSenders    int           // Number of senders with cached pre-admission data
+	BufferedMessages  int           // Number of composites waiting to be flushed
+	BufferedBytes     int64         // Approx size of those composites
+}
+
+func (s Stats)String() string {
+	return fmt.Sprintf("in=%d, built=%d, out=%d, flushes=%d, dropped=%d/%d/%d, depth=%d, senders=%d",
+		s.MessagesIn, s.CompositesBuilt, s.MessagesOut, s.Flushes,
+		s.DroppedDenied, s.DroppedUnadmitted, s.DroppedBufferFull, s.BufferedMessages, s.Senders)
+}
+
+// }}}
+
+// {{{ Stats.recordFlush
+
+func (s *Stats)recordFlush(n int, latency time.Duration) {
+	s.Flushes++
+	s.MessagesOut += int64(n)
+	s.FlushLatency += latency
+	if latency > s.MaxFlushLatency { s.MaxFlushLatency = latency }
+	s.LastFlush = time.Now()
+}
+
+// }}}
+// {{{ MsgBuffer.publishStats
+
+// publishStats takes a snapshot of the stats, so that other goroutines can safely read them
+// without stopping the buffer.
+func (mb *MsgBuffer)publishStats() {
+	s := mb.stats
+	s.DroppedBufferFull = mb.NumDropped
+	s.ForceFlushed      = mb.NumForceFlushed
+	s.FlushTimeouts     = mb.NumFlushTimeouts
+	s.Senders           = len(mb.Senders)
+	s.PendingSenders    = len(mb.pending)
+	s.BufferedMessages  = len(mb.Messages)
+	s.BufferedBytes     = mb.numBytes
+
+	mb.statsMu.Lock()
+	mb.published = s
+	mb.statsMu.Unlock()
+}
+
+// }}}
+// {{{ MsgBuffer.Stats
+
+// Stats returns the figures as they were at the end of the last Add (or flush). Add can block
+// on the FlushChannel, so this reads a separate copy, under its own lock; a metrics handler
+// can call it while Add is stuck.
+func (mb *MsgBuffer)Stats() Stats {
+	mb.statsMu.Lock()
+	defer mb.statsMu.Unlock()
+	return mb.published
+}
+
+// }}}
+
+// {{{ Stats.WritePrometheus
+
+// WritePrometheus writes the stats in the Prometheus text exposition format.
+func (s Stats)WritePrometheus(w io.Writer) {
+	metric := func(name, kind, help string, vals ...string) {
+		fmt.Fprintf(w, "# HELP msgbuffer_%s %s\n# TYPE msgbuffer_%s %s\n", name, help, name, kind)
+		for _,v := range vals {
+			fmt.Fprintf(w, "msgbuffer_%s%s\n", name, v)
+		}
+	}
+	i := func(n int64) string { return fmt.Sprintf(" %d", n) }
+
+	metric("messages_in_total", "counter", "Messages passed to Add.", i(s.MessagesIn))
+	metric("composites_built_total", "counter", "Composite messages added to the buffer.",
+		i(s.CompositesBuilt))
+	metric("messages_out_total", "counter", "Composite messages flushed.", i(s.MessagesOut))
+	metric("senders_admitted_total", "counter", "Senders whitelisted.", i(s.SendersAdmitted))
+	metric("senders_aged_out_total", "counter", "Senders removed for being quiet.",
+		i(s.SendersAgedOut))
+	metric("preadmit_cached_total", "counter", "Messages cached from senders not yet admitted.",
+		i(s.PreAdmitCached))
+	metric("messages_dropped_total", "counter", "Messages discarded, by reason.",
+		fmt.Sprintf(`{reason="denied"} %d`, s.DroppedDenied),
+		fmt.Sprintf(`{reason="unadmitted"} %d`, s.DroppedUnadmitted),
+		fmt.Sprintf(`{reason="buffer_full"} %d`, s.DroppedBufferFull),
+		fmt.Sprintf(`{reason="final_flush"} %d`, s.DroppedFinalFlush))
+	metric("force_flushed_total", "counter", "Composites flushed early as the buffer was full.",
+		i(s.ForceFlushed))
+	metric("flush_timeouts_total", "counter", "Flushes abandoned due to a blocked channel.",
+		i(s.FlushTimeouts))
+	metric("flush_latency_seconds", "summary", "Time spent handing off flushes.",
+		fmt.Sprintf("_sum %f", s.FlushLatency.Seconds()),
+		fmt.Sprintf("_count %d", s.Flushes))
+	metric("flush_latency_max_seconds", "gauge", "Longest time spent handing off a flush.",
+		fmt.Sprintf(" %f", s.MaxFlushLatency.Seconds()))
+	if !s.LastFlush.IsZero() {
+		metric("last_flush_timestamp_seconds", "gauge", "Unix time of the most recent flush.",
+			fmt.Sprintf(" %d", s.LastFlush.Unix()))
+	}
+	metric("senders", "gauge", "Senders currently whitelisted.", i(int64(s.Senders)))
+	metric("pending_senders", "gauge", "Senders with cached pre-admission data.",
+		i(int64(s.PendingSenders)))
+	metric("buffered_messages", "gauge", "Composites waiting to be flushed.",
+		i(int64(s.BufferedMessages)))
+	metric("buffered_bytes", "gauge", "Approx size of composites waiting to be flushed.",
+		i(s.BufferedBytes))
+}
+
+// }}}
+// {{{ MsgBuffer.ServeMetrics
+
+// ServeMetrics is an http.HandlerFunc that exports the stats for Prometheus, e.g.
+//   http.HandleFunc("/metrics", mb.ServeMetrics)
+func (mb *MsgBuffer)ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	mb.Stats().WritePrometheus(w)
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
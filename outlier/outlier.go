@@ -0,0 +1,214 @@
+/* Package outlier spots bad positions in streams of composite ADS-B messages.
+
+Bad CPR decodes and MLAT glitches show up as single points that are
+far away from the rest of the track; some receivers also output
+unknown positions as (0,0). Each new position is checked against the
+aircraft's last good fix; if the implied speed or climb rate is
+implausible, the message is flagged (or dropped), with a reason. MLAT
+positions can also be rejected outright, if their error estimate is
+too big or too few receivers contributed.
+
+Sample usage:
+
+    f := outlier.NewFilter()
+    f.Drop = true
+    go f.Run(inChan, outChan)
+
+*/
+package outlier
+
+import(
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/skypies/adsb"
+)
+
+// Reason codes, for CompositeMsg.OutlierReason
+const (
+	ReasonNone         = ""
+	ReasonNullIsland   = "nullisland"   // Position is (0,0)
+	ReasonBadCoords    = "badcoords"    // Position is not on the globe
+	ReasonSpeed        = "speed"        // Implied speed from last fix is implausible
+	ReasonVerticalRate = "verticalrate" // Implied (or reported) climb/descent is implausible
+	ReasonMLATQuality  = "mlatquality"  // MLAT solution's error estimate or station count is poor
+)
+
+// {{{ Filter{}
+
+type fix struct {
+	msg       *adsb.CompositeMsg
+	nRejected int  // How many consecutive messages have been rejected against this fix
+}
+
+// Filter keeps track of the last good fix for each aircraft. It is not safe for concurrent use.
+type Filter struct {
+	MaxSpeedKnots     float64       // Implied ground speeds above this are outliers
+	MaxVerticalRate   float64       // Implied vertical rates (feet/min) above this are outliers
+	MinInterval       time.Duration // Treat fixes closer in time than this as this far apart
+	MaxStaleness      time.Duration // Don't compare against fixes older than this
+	ResetAfter        int           // After this many consecutive outliers, trust the new data
+	MaxMLATError      float64       // If >0, MLAT positions with bigger error estimates (metres) are outliers
+	MinMLATStations   int64         // If >0, MLAT positions from fewer receivers than this are outliers
+	Drop              bool          // Run & FilterMsgs drop outliers, instead of just flagging them
+
+	Counts            map[string]int64 // How many messages were flagged, by reason
+
+	lastFix           map[adsb.IcaoId]*fix
+	latest            time.Time // Newest message timestamp seen; staleness is relative to this
+	lastAgeOut        time.Time // ... as of the last ageOut
+}
+
+func NewFilter() *Filter {
+	return &Filter{
+		MaxSpeedKnots:   800,
+		MaxVerticalRate: 12000,
+		MinInterval:     time.Second,
+		MaxStaleness:    time.Minute * 5,
+		ResetAfter:      4,
+		Counts:          map[string]int64{},
+		lastFix:         map[adsb.IcaoId]*fix{},
+	}
+}
+
+func (f *Filter)String() string {
+	return fmt.Sprintf("outlier.Filter{%d aircraft, %v}", len(f.lastFix), f.Counts)
+}
+
+// }}}
+
+// {{{ Filter.ageOut
+
+// ageOut forgets fixes that are too old to compare against. Age is measured in message
+// time, not wall clock time, so that replayed logs (or a backlog) are filtered properly.
+func (f *Filter)ageOut() {
+	if f.latest.Sub(f.lastAgeOut) < time.Second { return } // Only run once per (message) second.
+	f.lastAgeOut = f.latest
+
+	for id,last := range f.lastFix {
+		if f.latest.Sub(last.msg.GeneratedTimestampUTC) > f.MaxStaleness {
+			delete(f.lastFix, id)
+		}
+	}
+}
+
+// }}}
+// {{{ Filter.compare
+
+// compare checks a new position against a previous fix.
+func (f *Filter)compare(prev, cm *adsb.CompositeMsg) string {
+	dt := cm.GeneratedTimestampUTC.Sub(prev.GeneratedTimestampUTC)
+	if dt < 0 { dt = -dt }
+	if dt > f.MaxStaleness {
+		return ReasonNone
+	}
+	if dt < f.MinInterval { dt = f.MinInterval }
+
+	if distNM := prev.Position.DistNM(cm.Position); distNM / dt.Hours() > f.MaxSpeedKnots {
+		return ReasonSpeed
+	}
+
+	dAlt := math.Abs(float64(cm.Altitude - prev.Altitude))
+	if cm.Altitude != 0 && prev.Altitude != 0 && dAlt / dt.Minutes() > f.MaxVerticalRate {
+		return ReasonVerticalRate
+	}
+
+	return ReasonNone
+}
+
+// }}}
+// {{{ Filter.poorMLAT
+
+// poorMLAT looks at the quality of MLAT solutions; estimates & counts of zero are unknown,
+// and are let through.
+func (f *Filter)poorMLAT(cm *adsb.CompositeMsg) bool {
+	if !cm.IsMLAT() { return false }
+	if f.MaxMLATError > 0 && cm.ErrorEstimate > f.MaxMLATError { return true }
+	if f.MinMLATStations > 0 && cm.NumStations > 0 && cm.NumStations < f.MinMLATStations { return true }
+	return false
+}
+
+// }}}
+// {{{ Filter.Check
+
+// Check looks at the message's position, and returns a reason code if it is an outlier
+// (or ReasonNone if it looks fine). The message is not modified.
+func (f *Filter)Check(cm *adsb.CompositeMsg) string {
+	if cm.GeneratedTimestampUTC.After(f.latest) { f.latest = cm.GeneratedTimestampUTC }
+	f.ageOut()
+
+	if !cm.HasPosition() {
+		return ReasonNone
+	}
+
+	reason := ReasonNone
+	if math.Abs(cm.Position.Lat) < 0.01 && math.Abs(cm.Position.Long) < 0.01 {
+		reason = ReasonNullIsland
+	} else if math.Abs(cm.Position.Lat) > 90 || math.Abs(cm.Position.Long) > 180 {
+		reason = ReasonBadCoords
+	} else if f.poorMLAT(cm) {
+		reason = ReasonMLATQuality
+	} else if math.Abs(float64(cm.VerticalRate)) > f.MaxVerticalRate {
+		reason = ReasonVerticalRate
+	} else if last,exists := f.lastFix[cm.Icao24]; exists {
+		reason = f.compare(last.msg, cm)
+
+		// If we keep rejecting data, maybe it was the fix we were comparing against that was bad.
+		if reason != ReasonNone {
+			last.nRejected++
+			if last.nRejected >= f.ResetAfter {
+				reason = ReasonNone
+			}
+		}
+	}
+
+	if reason == ReasonNone {
+		f.lastFix[cm.Icao24] = &fix{msg:cm}
+	} else {
+		f.Counts[reason]++
+	}
+
+	return reason
+}
+
+// }}}
+// {{{ Filter.FilterMsgs
+
+// FilterMsgs checks each message in turn, flagging its OutlierReason. If the filter is set
+// to Drop, the outliers are omitted from the returned slice.
+func (f *Filter)FilterMsgs(msgs []*adsb.CompositeMsg) []*adsb.CompositeMsg {
+	ret := []*adsb.CompositeMsg{}
+	for _,cm := range msgs {
+		if cm.OutlierReason = f.Check(cm); cm.IsOutlier() && f.Drop {
+			continue
+		}
+		ret = append(ret, cm)
+	}
+	return ret
+}
+
+// }}}
+// {{{ Filter.Run
+
+// Run filters each slice of messages read from the input channel, and sends the results to
+// the output channel (which is closed when the input channel is closed). Slices that end up
+// empty are not sent.
+func (f *Filter)Run(in <-chan []*adsb.CompositeMsg, out chan<- []*adsb.CompositeMsg) {
+	for msgs := range in {
+		if filtered := f.FilterMsgs(msgs); len(filtered) > 0 {
+			out <- filtered
+		}
+	}
+	close(out)
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,114 @@
+// go test -v github.com/skypies/adsb/outlier
+package outlier
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skypies/adsb"
+)
+
+func composites(sbs string) (ret []*adsb.CompositeMsg) {
+	scanner := bufio.NewScanner(strings.NewReader(sbs))
+	for scanner.Scan() {
+		m := adsb.Msg{}
+		if err := m.FromSBS1(scanner.Text()); err != nil {
+			panic(err)
+		}
+		ret = append(ret, &adsb.CompositeMsg{Msg:m})
+	}
+	return
+}
+
+var (
+	// A track with a CPR glitch (line 3), a null island (line 4) and an altitude spike (line 6)
+	glitchySBS = `MSG,3,1,1,A81BD0,1,2015/11/27,21:31:03.354,2015/11/27,21:31:03.316,,20125,,,36.69804,-121.86007,,,,,,0
+MSG,3,1,1,A81BD0,1,2015/11/27,21:31:03.704,2015/11/27,21:31:03.716,,20125,,,36.69830,-121.86017,,,,,,0
+MSG,3,1,1,A81BD0,1,2015/11/27,21:31:04.704,2015/11/27,21:31:04.716,,20100,,,38.69830,-121.86017,,,,,,0
+MSG,3,1,1,A81BD0,1,2015/11/27,21:31:05.204,2015/11/27,21:31:05.216,,20100,,,0.00,0.00,,,,,,0
+MSG,3,1,1,A81BD0,1,2015/11/27,21:31:05.274,2015/11/27,21:31:05.276,,20075,,,36.70029,-121.86190,,,,,,0
+MSG,3,1,1,A81BD0,1,2015/11/27,21:31:06.274,2015/11/27,21:31:06.276,,30075,,,36.70129,-121.86290,,,,,,0
+MSG,3,1,1,A81BD0,1,2015/11/27,21:31:07.274,2015/11/27,21:31:07.276,,20050,,,36.70229,-121.86390,,,,,,0`
+)
+
+func TestCheck(t *testing.T) {
+	f := NewFilter()
+
+	expected := []string{"", "", ReasonSpeed, ReasonNullIsland, "", ReasonVerticalRate, ""}
+	for i,cm := range composites(glitchySBS) {
+		if reason := f.Check(cm); reason != expected[i] {
+			t.Errorf("[%d] expected '%s', got '%s'", i, expected[i], reason)
+		}
+	}
+	if f.Counts[ReasonSpeed] != 1 { t.Errorf("counts were off: %v", f.Counts) }
+}
+
+func TestMLATQuality(t *testing.T) {
+	mlatSBS := `MLAT,3,1,1,A76E37,1,2016/03/10,18:22:22.989,2016/03/10,18:22:22.989,,28211,497,66,36.8347,-120.4883,1696,,,,,,5,,120
+MLAT,3,1,1,A2C635,1,2016/03/10,18:22:23.220,2016/03/10,18:22:23.220,,37559,379,316,36.3631,-120.3861,-884,,,,,,6,,2500
+MLAT,3,1,1,A24757,1,2016/03/10,18:22:23.527,2016/03/10,18:22:23.527,,39006,465,150,35.9798,-119.7215,-5,,,,,,3,,80
+MLAT,3,1,1,A81A3E,1,2016/03/10,18:22:24.115,2016/03/10,18:22:24.115,,21113,399,143,36.8268,-121.4215,1003,,,,,,,,`
+
+	f := NewFilter()
+	expected := []string{"", "", "", ""}
+	for i,cm := range composites(mlatSBS) {
+		if reason := f.Check(cm); reason != expected[i] {
+			t.Errorf("[%d] default: expected '%s', got '%s'", i, expected[i], reason)
+		}
+	}
+
+	f = NewFilter()
+	f.MaxMLATError = 1000
+	f.MinMLATStations = 4
+	expected = []string{"", ReasonMLATQuality, ReasonMLATQuality, ""} // Unknown quality is let through
+	for i,cm := range composites(mlatSBS) {
+		if reason := f.Check(cm); reason != expected[i] {
+			t.Errorf("[%d] expected '%s', got '%s'", i, expected[i], reason)
+		}
+	}
+}
+
+// Staleness is judged by message time, so replayed data (from 2015) is compared as normal,
+// and fixes only expire when the data moves on.
+func TestStaleness(t *testing.T) {
+	msgs := composites(glitchySBS)
+	f := NewFilter()
+	f.Check(msgs[0])
+	f.Check(msgs[1])
+	if r := f.Check(msgs[2]); r != ReasonSpeed { t.Errorf("replayed glitch not caught: '%s'", r) }
+
+	later := *msgs[0]
+	later.Icao24 = "ABEEF0"
+	later.GeneratedTimestampUTC = msgs[1].GeneratedTimestampUTC.Add(f.MaxStaleness + time.Second)
+	f.Check(&later)
+	if _,exists := f.lastFix["A81BD0"]; exists { t.Errorf("stale fix was not aged out") }
+	if _,exists := f.lastFix["ABEEF0"]; !exists { t.Errorf("fresh fix was aged out") }
+}
+
+func TestReset(t *testing.T) {
+	f := NewFilter()
+	f.ResetAfter = 2
+
+	msgs := composites(glitchySBS)
+	f.Check(msgs[2]) // Start with the bad one
+	if r := f.Check(msgs[0]); r != ReasonSpeed { t.Errorf("first good msg not rejected") }
+	if r := f.Check(msgs[1]); r != ReasonNone { t.Errorf("filter did not reset") }
+	if r := f.Check(msgs[4]); r != ReasonNone { t.Errorf("filter did not accept new data") }
+}
+
+func TestRun(t *testing.T) {
+	f := NewFilter()
+	f.Drop = true
+
+	in := make(chan []*adsb.CompositeMsg, 1)
+	out := make(chan []*adsb.CompositeMsg, 1)
+	in <- composites(glitchySBS)
+	close(in)
+	f.Run(in, out)
+
+	msgs := <-out
+	if len(msgs) != 4 { t.Errorf("expected 4 msgs to survive, saw %d", len(msgs)) }
+	if _,ok := <-out; ok { t.Errorf("output channel not closed") }
+}
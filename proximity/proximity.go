@@ -0,0 +1,225 @@
+/* Package proximity looks for pairs of aircraft that are too close together.
+
+It keeps the latest position of each aircraft, in a spatialindex;
+each new position is only compared against aircraft nearby. A pair is
+in conflict if it is closer than both the horizontal and vertical
+thresholds; optionally, each pair's current tracks and speeds are
+projected forward in time, to catch conflicts that are about to
+happen.
+
+Sample usage:
+
+    d := proximity.NewDetector()
+    d.LookAhead = time.Minute
+    go d.Run(flushChan, outChan, conflictChan)
+
+*/
+package proximity
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/adsb/spatialindex"
+	"github.com/skypies/geo"
+)
+
+// {{{ Conflict{}
+
+// Conflict describes two aircraft that are (or will shortly be) too close.
+type Conflict struct {
+	A,B          *adsb.CompositeMsg // The messages that triggered the conflict
+	Time         time.Time          // When the separation is smallest
+	HorizontalNM float64            // Separation at that time
+	VerticalFt   float64
+	Predicted    bool               // The separation is projected forward from the current state
+}
+
+func (c Conflict)String() string {
+	s := fmt.Sprintf("%s[%s] / %s[%s]: %.2fNM, %.0fft @ %s",
+		c.A.Icao24, c.A.Callsign, c.B.Icao24, c.B.Callsign, c.HorizontalNM, c.VerticalFt, c.Time)
+	if c.Predicted { s += " (predicted)" }
+	return s
+}
+
+// }}}
+// {{{ Detector{}
+
+// Detector holds the latest position of each aircraft. It is not safe for concurrent use.
+type Detector struct {
+	HorizontalNM  float64       // Aircraft closer than this horizontally ...
+	VerticalFt    float64       // ... and this vertically, are in conflict
+	LookAhead     time.Duration // If >0, project each pair forward this far in time
+	MaxPosAge     time.Duration // Don't compare positions further apart in time than this
+	Rearm         time.Duration // Don't report the same pair again for this long
+	MaxSpeed      float64       // Knots; used to decide how far away to look for a predicted conflict
+
+	Index         *spatialindex.Index // Latest positions; its MaxQuietTime decides when to forget
+
+	reported      map[[2]adsb.IcaoId]time.Time
+	lastAgeOut    time.Time
+}
+
+func NewDetector() *Detector {
+	return &Detector{
+		HorizontalNM:  1.0,
+		VerticalFt:    500,
+		MaxPosAge:     time.Second * 10,
+		Rearm:         time.Minute * 2,
+		MaxSpeed:      600,
+		Index:         spatialindex.NewIndex(),
+		reported:      map[[2]adsb.IcaoId]time.Time{},
+	}
+}
+
+// }}}
+
+// {{{ Detector.ageOut
+
+func (d *Detector)ageOut() {
+	if time.Since(d.lastAgeOut) < time.Second { return } // Only run once per second.
+	d.lastAgeOut = time.Now()
+
+	for pair,t := range d.reported {
+		if time.Since(t) >= d.Rearm {
+			delete(d.reported, pair)
+		}
+	}
+}
+
+// }}}
+// {{{ state
+
+// state is an aircraft's position & velocity in a flat local frame (in NM, NM/hour, feet
+// and feet/min), relative to some origin.
+type state struct {
+	x,y,vx,vy float64
+	alt,vr    float64
+}
+
+func toState(origin, cm *adsb.CompositeMsg) state {
+	rad := math.Pi / 180
+	hdg := float64(cm.Track) * rad
+	return state{
+		x:   (cm.Position.Long - origin.Position.Long) * 60 * math.Cos(origin.Position.Lat * rad),
+		y:   (cm.Position.Lat - origin.Position.Lat) * 60,
+		vx:  float64(cm.GroundSpeed) * math.Sin(hdg),
+		vy:  float64(cm.GroundSpeed) * math.Cos(hdg),
+		alt: float64(cm.Altitude),
+		vr:  float64(cm.VerticalRate),
+	}
+}
+
+// at extrapolates the state dt into the future.
+func (s state)at(dt time.Duration) state {
+	s.x += s.vx * dt.Hours()
+	s.y += s.vy * dt.Hours()
+	s.alt += s.vr * dt.Minutes()
+	return s
+}
+
+func separation(a, b state) (horizNM, vertFt float64) {
+	return math.Hypot(a.x-b.x, a.y-b.y), math.Abs(a.alt-b.alt)
+}
+
+// }}}
+// {{{ Detector.compare
+
+// compare two aircraft, at the time of the newer message.
+func (d *Detector)compare(cm, other *adsb.CompositeMsg) (Conflict, bool) {
+	t := cm.GeneratedTimestampUTC
+	a := toState(cm, cm)
+	b := toState(cm, other).at(t.Sub(other.GeneratedTimestampUTC)) // Bring other up to date
+
+	c := Conflict{A:cm, B:other, Time:t}
+	c.HorizontalNM, c.VerticalFt = separation(a, b)
+	if c.HorizontalNM < d.HorizontalNM && c.VerticalFt < d.VerticalFt {
+		return c, true
+	}
+	if d.LookAhead <= 0 {
+		return c, false
+	}
+
+	// Find the closest horizontal approach, assuming constant velocities
+	rx,ry,rvx,rvy := b.x-a.x, b.y-a.y, b.vx-a.vx, b.vy-a.vy
+	v2 := rvx*rvx + rvy*rvy
+	if v2 == 0 {
+		return c, false
+	}
+	tCPA := time.Duration(-(rx*rvx + ry*rvy) / v2 * float64(time.Hour))
+	if tCPA <= 0 {
+		return c, false // Diverging
+	}
+	if tCPA > d.LookAhead { tCPA = d.LookAhead }
+
+	p := Conflict{A:cm, B:other, Time:t.Add(tCPA), Predicted:true}
+	p.HorizontalNM, p.VerticalFt = separation(a.at(tCPA), b.at(tCPA))
+	return p, p.HorizontalNM < d.HorizontalNM && p.VerticalFt < d.VerticalFt
+}
+
+// }}}
+// {{{ Detector.Add
+
+// Add updates the aircraft's position, and returns any new conflicts it is involved in.
+// Aircraft on the ground, and messages without positions, are ignored.
+func (d *Detector)Add(cm *adsb.CompositeMsg) []Conflict {
+	d.ageOut()
+	if !cm.HasPosition() || cm.IsOnGround {
+		return nil
+	}
+
+	conflicts := []Conflict{}
+	radiusNM := d.HorizontalNM + 2 * d.MaxSpeed * (d.MaxPosAge + d.LookAhead).Hours()
+
+	for _,r := range d.Index.WithinRadius(cm.Position, geo.NM2KM(radiusNM)) {
+		other,id := r.Msg, r.Msg.Icao24
+		if id == cm.Icao24 { continue }
+		if dt := cm.GeneratedTimestampUTC.Sub(other.GeneratedTimestampUTC); dt > d.MaxPosAge || dt < -d.MaxPosAge {
+			continue
+		}
+
+		pair := [2]adsb.IcaoId{cm.Icao24, id}
+		if id < cm.Icao24 { pair = [2]adsb.IcaoId{id, cm.Icao24} }
+		if _,exists := d.reported[pair]; exists { continue }
+
+		if c,inConflict := d.compare(cm, other); inConflict {
+			conflicts = append(conflicts, c)
+			d.reported[pair] = time.Now()
+		}
+	}
+
+	d.Index.Update(cm)
+
+	return conflicts
+}
+
+// }}}
+// {{{ Detector.Run
+
+// Run reads slices of messages from the input channel, and passes them on unchanged to the
+// output channel (if not nil). Conflicts are sent to the conflict channel. Both output
+// channels are closed when the input channel is closed.
+func (d *Detector)Run(in <-chan []*adsb.CompositeMsg, out chan<- []*adsb.CompositeMsg, conflicts chan<- Conflict) {
+	for msgs := range in {
+		for _,cm := range msgs {
+			for _,c := range d.Add(cm) {
+				conflicts <- c
+			}
+		}
+		if out != nil { out <- msgs }
+	}
+	if out != nil { close(out) }
+	close(conflicts)
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
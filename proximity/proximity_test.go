@@ -0,0 +1,57 @@
+// go test -v github.com/skypies/adsb/proximity
+package proximity
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skypies/adsb"
+)
+
+func composites(sbs string) (ret []*adsb.CompositeMsg) {
+	scanner := bufio.NewScanner(strings.NewReader(sbs))
+	for scanner.Scan() {
+		m := adsb.Msg{}
+		if err := m.FromSBS1(scanner.Text()); err != nil {
+			panic(err)
+		}
+		ret = append(ret, &adsb.CompositeMsg{Msg:m})
+	}
+	return
+}
+
+var trafficSBS = `MSG,3,1,1,AAAAAA,1,2015/11/27,21:31:00.000,2015/11/27,21:31:00.000,,5000,240,90,37.00000,-122.00000,0,,,,,0
+MSG,3,1,1,BBBBBB,1,2015/11/27,21:31:01.000,2015/11/27,21:31:01.000,,5300,240,270,37.00000,-121.99000,0,,,,,0
+MSG,3,1,1,EEEEEE,1,2015/11/27,21:31:01.000,2015/11/27,21:31:01.000,,8000,240,90,37.00000,-122.00000,0,,,,,0
+MSG,3,1,1,CCCCCC,1,2015/11/27,21:31:02.000,2015/11/27,21:31:02.000,,5000,240,270,37.00000,-121.50000,0,,,,,0
+MSG,3,1,1,DDDDDD,1,2015/11/27,21:31:02.000,2015/11/27,21:31:02.000,,5000,240,90,37.00000,-121.70000,0,,,,,0
+MSG,3,1,1,BBBBBB,1,2015/11/27,21:31:03.000,2015/11/27,21:31:03.000,,5300,240,270,37.00000,-121.99500,0,,,,,0`
+
+func TestDetector(t *testing.T) {
+	d := NewDetector()
+	conflicts := []Conflict{}
+	for _,cm := range composites(trafficSBS) {
+		conflicts = append(conflicts, d.Add(cm)...)
+	}
+	if len(conflicts) != 1 { t.Fatalf("expected 1 conflict, saw %d: %v", len(conflicts), conflicts) }
+	if c := conflicts[0]; c.A.Icao24 != "BBBBBB" || c.B.Icao24 != "AAAAAA" || c.Predicted {
+		t.Errorf("wrong conflict: %s", c)
+	}
+
+	d = NewDetector()
+	d.LookAhead = time.Minute * 2
+	conflicts = []Conflict{}
+	for _,cm := range composites(trafficSBS) {
+		conflicts = append(conflicts, d.Add(cm)...)
+	}
+	if len(conflicts) != 2 { t.Fatalf("expected 2 conflicts, saw %d: %v", len(conflicts), conflicts) }
+	c := conflicts[1]
+	if !c.Predicted || c.A.Icao24 != "DDDDDD" || c.HorizontalNM > 0.1 {
+		t.Errorf("wrong predicted conflict: %s", c)
+	}
+	if ahead := c.Time.Sub(c.A.GeneratedTimestampUTC); ahead < 70*time.Second || ahead > 74*time.Second {
+		t.Errorf("predicted conflict at wrong time (%s ahead): %s", ahead, c)
+	}
+}
@@ -0,0 +1,97 @@
+package qnh
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skypies/geo"
+)
+
+var (
+	metarStationRegexp = regexp.MustCompile(`^[A-Z][A-Z0-9]{3}$`)
+	metarTimeRegexp    = regexp.MustCompile(`^(\d{2})(\d{2})(\d{2})Z$`)
+	metarQNHRegexp     = regexp.MustCompile(`^([QA])(\d{4})$`)
+)
+
+// {{{ ParseMETAR
+
+// ParseMETAR pulls the station, time and QNH out of a METAR (or SPECI) report. METARs only
+// give the day of the month, so ref is used to work out which month (and year) it is from;
+// it should be roughly when the report was issued.
+func ParseMETAR(metar string, ref time.Time) (Observation, error) {
+	o := Observation{}
+	ref = ref.UTC()
+
+	fields := strings.Fields(strings.TrimSpace(metar))
+	for len(fields) > 0 && (fields[0] == "METAR" || fields[0] == "SPECI") {
+		fields = fields[1:]
+	}
+	if len(fields) < 2 || !metarStationRegexp.MatchString(fields[0]) {
+		return o, fmt.Errorf("METAR '%s': no station", metar)
+	}
+	o.Station = fields[0]
+
+	m := metarTimeRegexp.FindStringSubmatch(fields[1])
+	if m == nil {
+		return o, fmt.Errorf("METAR '%s': no time", metar)
+	}
+	day,_ := strconv.Atoi(m[1])
+	hour,_ := strconv.Atoi(m[2])
+	min,_ := strconv.Atoi(m[3])
+	o.Time = time.Date(ref.Year(), ref.Month(), day, hour, min, 0, 0, time.UTC)
+	if o.Time.After(ref.Add(time.Hour * 24)) {
+		o.Time = time.Date(ref.Year(), ref.Month()-1, day, hour, min, 0, 0, time.UTC)
+	}
+
+	for _,f := range fields[2:] {
+		if f == "RMK" { break }
+		if m := metarQNHRegexp.FindStringSubmatch(f); m != nil {
+			val,_ := strconv.ParseFloat(m[2], 64)
+			if m[1] == "Q" {
+				o.QNH = val                   // millibars
+			} else {
+				o.QNH = val / 100 / InHgPerMb // hundredths of inHg
+			}
+			return o, nil
+		}
+	}
+
+	return o, fmt.Errorf("METAR '%s': no QNH", metar)
+}
+
+// }}}
+// {{{ Table.AddMETARs
+
+// AddMETARs reads METARs, one per line, and adds their QNH observations to the table. Only
+// stations with a location in the map are added; lines that aren't METARs (e.g. the
+// timestamps in NOAA's files) are skipped. It returns how many observations were added.
+func (t *Table)AddMETARs(r io.Reader, stations map[string]geo.Latlong, ref time.Time) (int, error) {
+	n := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		o,err := ParseMETAR(scanner.Text(), ref)
+		if err != nil { continue }
+		pos,exists := stations[o.Station]
+		if !exists { continue }
+
+		o.Location = pos
+		t.Add(o)
+		n++
+	}
+	return n, scanner.Err()
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
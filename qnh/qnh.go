@@ -0,0 +1,195 @@
+/* Package qnh corrects Mode C pressure altitudes, to get heights above
+mean sea level.
+
+Transponders report altitude relative to the standard pressure of
+1013.25mb, whatever the actual weather. Given the local sea level
+pressure (QNH) at the time, we can work out how far off that is. The
+QNH can come from a Table of observations (e.g. loaded from a file of
+METARs); or, if the aircraft reports the difference between its GNSS
+and barometric altitudes, that can be used instead.
+
+Sample usage:
+
+    table := qnh.NewTable()
+    table.AddMETARs(metarFile, stationLocations, time.Now())
+    c := qnh.Corrector{Source:table, UseGNSS:true}
+    for _,r := range c.CorrectMsgs(track.Messages) {
+      fmt.Printf("%dft MSL\n", r.Altitude)
+    }
+
+*/
+package qnh
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/geo"
+	"github.com/skypies/geo/altitude"
+)
+
+const (
+	StandardQNH   = 1013.25    // millibars (hPa)
+	InHgPerMb     = 0.02953
+)
+
+// {{{ Source, Observation{}, Table{}
+
+// A Source knows the QNH (in millibars) at a given time and place.
+type Source interface {
+	QNH(t time.Time, pos geo.Latlong) (float64, bool)
+}
+
+// Observation is a single QNH reading, e.g. from a METAR.
+type Observation struct {
+	Station   string
+	Location  geo.Latlong
+	Time      time.Time
+	QNH       float64 // millibars
+}
+
+func (o Observation)String() string {
+	return fmt.Sprintf("%s %.1fmb @ %s", o.Station, o.QNH, o.Time)
+}
+
+// Table is a set of observations. Lookups use the most recent observation (at or before the
+// time) from the nearest station. It is not safe for concurrent modification.
+type Table struct {
+	MaxDistKM     float64       // Ignore stations further away than this
+	MaxAge        time.Duration // Ignore observations older than this
+
+	Observations  map[string][]Observation // By station, in time order
+}
+
+func NewTable() *Table {
+	return &Table{
+		MaxDistKM:    100,
+		MaxAge:       time.Hour * 3,
+		Observations: map[string][]Observation{},
+	}
+}
+
+// }}}
+
+// {{{ Table.Add
+
+func (t *Table)Add(o Observation) {
+	obs := append(t.Observations[o.Station], o)
+	sort.Slice(obs, func(i,j int) bool { return obs[i].Time.Before(obs[j].Time) })
+	t.Observations[o.Station] = obs
+}
+
+// }}}
+// {{{ Table.Lookup
+
+// Lookup finds the observation that applies at the given time and place.
+func (t *Table)Lookup(tm time.Time, pos geo.Latlong) (Observation, bool) {
+	best,bestDist,found := Observation{}, 0.0, false
+
+	for _,obs := range t.Observations {
+		if len(obs) == 0 { continue }
+		dist := obs[0].Location.DistKM(pos)
+		if t.MaxDistKM > 0 && dist > t.MaxDistKM { continue }
+		if found && dist >= bestDist { continue }
+
+		// The latest observation at or before tm
+		i := sort.Search(len(obs), func(i int) bool { return obs[i].Time.After(tm) }) - 1
+		if i < 0 { continue }
+		if t.MaxAge > 0 && tm.Sub(obs[i].Time) > t.MaxAge { continue }
+
+		best,bestDist,found = obs[i],dist,true
+	}
+
+	return best, found
+}
+
+// }}}
+// {{{ Table.QNH
+
+func (t *Table)QNH(tm time.Time, pos geo.Latlong) (float64, bool) {
+	obs,found := t.Lookup(tm, pos)
+	return obs.QNH, found
+}
+
+// }}}
+
+// {{{ CorrectAltitude
+
+// CorrectAltitude converts a pressure altitude (in feet, relative to 1013.25mb) into height
+// above mean sea level, given the QNH (in millibars).
+func CorrectAltitude(pressureAltitude, qnh float64) float64 {
+	return altitude.PressureAltitudeToIndicatedAltitude(pressureAltitude, qnh * InHgPerMb)
+}
+
+// }}}
+// {{{ Method, Result{}
+
+type Method int
+const (
+	MethodNone Method = iota // Not corrected; Altitude is still the pressure altitude
+	MethodQNH                // Corrected using the QNH from the Source
+	MethodGNSS               // The aircraft's reported GNSS altitude
+)
+
+func (m Method)String() string {
+	switch m {
+	case MethodQNH:  return "qnh"
+	case MethodGNSS: return "gnss"
+	default:         return "none"
+	}
+}
+
+type Result struct {
+	Altitude  int64   // feet
+	Method    Method
+	QNH       float64 // millibars; only for MethodQNH
+}
+
+// }}}
+// {{{ Corrector{}
+
+// Corrector works out altitudes above mean sea level.
+type Corrector struct {
+	Source    Source // May be nil
+	UseGNSS   bool   // Prefer the aircraft's GNSS altitude, if it reports one
+}
+
+// Correct returns the message's altitude above mean sea level. The GNSS altitude is relative
+// to the WGS84 ellipsoid rather than the geoid, so may be off by a hundred feet or so in
+// places.
+func (c Corrector)Correct(cm *adsb.CompositeMsg) Result {
+	if c.UseGNSS && cm.HasGeomMinusBaro() {
+		return Result{Altitude:cm.Altitude + cm.GeomMinusBaro, Method:MethodGNSS}
+	}
+
+	if c.Source != nil && cm.HasPosition() {
+		if qnh,found := c.Source.QNH(cm.GeneratedTimestampUTC, cm.Position); found {
+			alt := CorrectAltitude(float64(cm.Altitude), qnh)
+			return Result{Altitude:int64(math.Round(alt)), Method:MethodQNH, QNH:qnh}
+		}
+	}
+
+	return Result{Altitude:cm.Altitude, Method:MethodNone}
+}
+
+// CorrectMsgs corrects each message (e.g. all the messages in a trackbuffer.Track).
+func (c Corrector)CorrectMsgs(msgs []*adsb.CompositeMsg) []Result {
+	ret := make([]Result, len(msgs))
+	for i,cm := range msgs {
+		ret[i] = c.Correct(cm)
+	}
+	return ret
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
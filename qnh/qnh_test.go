@@ -0,0 +1,127 @@
+package qnh
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/geo"
+)
+
+var (
+	sfo = geo.Latlong{Lat:37.6189, Long:-122.3750}
+	lhr = geo.Latlong{Lat:51.4700, Long:-0.4543}
+
+	ref = time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	metars = `2016/03/01 11:56
+KSFO 011156Z 29012KT 10SM FEW010 14/09 A3042 RMK AO2 SLP305
+METAR EGLL 011150Z 24015KT 9999 SCT020 08/04 Q0998 NOSIG
+SPECI KSFO 010956Z 29010KT 10SM CLR 12/08 A3030 RMK AO2
+KOAK 011153Z 30008KT 10SM CLR 15/08 A3041
+EGLL 291150Z 24015KT 9999 NOSIG`
+)
+
+func TestParseMETAR(t *testing.T) {
+	tests := []struct {
+		In       string
+		Station  string
+		Time     time.Time
+		QNH      float64
+		Err      bool
+	}{
+		{"KSFO 011156Z 29012KT 10SM FEW010 14/09 A3042 RMK AO2", "KSFO",
+			time.Date(2016, 3, 1, 11, 56, 0, 0, time.UTC), 1030.1, false},
+		{"METAR EGLL 011150Z 24015KT 9999 SCT020 08/04 Q0998 NOSIG", "EGLL",
+			time.Date(2016, 3, 1, 11, 50, 0, 0, time.UTC), 998, false},
+		{"EGLL 291150Z 24015KT 9999 Q1020", "EGLL", // Last month
+			time.Date(2016, 2, 29, 11, 50, 0, 0, time.UTC), 1020, false},
+		{"EGLL 291150Z 24015KT 9999 NOSIG", "", time.Time{}, 0, true},
+		{"KSFO 29012KT A3042", "", time.Time{}, 0, true},
+		{"2016/03/01 11:56", "", time.Time{}, 0, true},
+	}
+
+	for i,test := range tests {
+		o,err := ParseMETAR(test.In, ref)
+		if (err != nil) != test.Err {
+			t.Errorf("[%d] err %v, expected error=%v", i, err, test.Err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if o.Station != test.Station || !o.Time.Equal(test.Time) || math.Abs(o.QNH - test.QNH) > 0.1 {
+			t.Errorf("[%d] got %s, expected %s %.1fmb @ %s", i, o, test.Station, test.QNH, test.Time)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	table := NewTable()
+	n,err := table.AddMETARs(strings.NewReader(metars), map[string]geo.Latlong{"KSFO":sfo, "EGLL":lhr}, ref)
+	if err != nil || n != 3 {
+		t.Fatalf("added %d observations (%v), expected 3", n, err)
+	}
+
+	near := geo.Latlong{Lat:37.7, Long:-122.4}
+	if qnh,found := table.QNH(ref, near); !found || math.Abs(qnh - 1030.1) > 0.1 {
+		t.Errorf("bad QNH for SFO at %s: %.1f, %v", ref, qnh, found)
+	}
+	if qnh,found := table.QNH(ref.Add(-time.Hour), near); !found || math.Abs(qnh - 1026.0) > 0.1 {
+		t.Errorf("bad QNH for SFO an hour earlier: %.1f, %v", qnh, found)
+	}
+	if _,found := table.QNH(ref.Add(-time.Hour*3), near); found {
+		t.Errorf("found a QNH from before the first observation")
+	}
+	if _,found := table.QNH(ref.Add(time.Hour*4), near); found {
+		t.Errorf("found a QNH from a stale observation")
+	}
+	if qnh,found := table.QNH(ref, lhr); !found || qnh != 998 {
+		t.Errorf("bad QNH for LHR: %.1f, %v", qnh, found)
+	}
+	if _,found := table.QNH(ref, geo.Latlong{Lat:40, Long:-100}); found {
+		t.Errorf("found a QNH far from any station")
+	}
+}
+
+func TestCorrector(t *testing.T) {
+	// At standard pressure, nothing changes; at high pressure, aircraft are higher than they say
+	if alt := CorrectAltitude(5000, StandardQNH); math.Abs(alt - 5000) > 5 {
+		t.Errorf("correction at standard pressure: %.0f", alt)
+	}
+	if alt := CorrectAltitude(5000, 1030); alt < 5440 || alt > 5480 {
+		t.Errorf("correction at 1030mb: %.0f", alt)
+	}
+	if alt := CorrectAltitude(5000, 990); alt > 4380 || alt < 4340 {
+		t.Errorf("correction at 990mb: %.0f", alt)
+	}
+
+	table := NewTable()
+	table.Add(Observation{Station:"KSFO", Location:sfo, Time:ref, QNH:1030})
+
+	m := adsb.Msg{}
+	m.FromSBS1("MSG,3,1,1,A81BD0,1,2016/03/01,12:01:00.000,2016/03/01,12:01:00.000,,5000,,,37.61890,-122.37500,,,,,,0")
+	m.GeneratedTimestampUTC = ref.Add(time.Minute)
+	cm := &adsb.CompositeMsg{Msg:m}
+
+	if r := (Corrector{}).Correct(cm); r.Method != MethodNone || r.Altitude != 5000 {
+		t.Errorf("corrected without a source: %v", r)
+	}
+	if r := (Corrector{Source:table}).Correct(cm); r.Method != MethodQNH || r.Altitude < 5440 || r.QNH != 1030 {
+		t.Errorf("bad QNH correction: %v", r)
+	}
+
+	cm.SetGeomMinusBaro(375)
+	if r := (Corrector{Source:table, UseGNSS:true}).Correct(cm); r.Method != MethodGNSS || r.Altitude != 5375 {
+		t.Errorf("bad GNSS correction: %v", r)
+	}
+	if r := (Corrector{Source:table}).Correct(cm); r.Method != MethodQNH {
+		t.Errorf("used GNSS when not asked to: %v", r)
+	}
+
+	rs := (Corrector{Source:table}).CorrectMsgs([]*adsb.CompositeMsg{cm, cm})
+	if len(rs) != 2 || rs[1].Method != MethodQNH {
+		t.Errorf("bad CorrectMsgs: %v", rs)
+	}
+}
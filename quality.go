@@ -0,0 +1,95 @@
+package adsb
+
+// Source says what kind of system a message came from; they vary a lot in how much the
+// data can be trusted.
+type Source int
+const (
+	SourceUnknown Source = iota
+	SourceADSB           // ADS-B, direct from the aircraft
+	SourceADSR           // ADS-B rebroadcast by a ground station (from UAT, usually)
+	SourceTISB           // Radar data, broadcast by a ground station
+	SourceMLAT           // Multilateration, from receivers' timing of Mode S replies
+	SourceModeS          // Mode S replies to radar interrogations (no position)
+	SourceModeAC         // Mode A/C replies (no address or position)
+)
+
+func (s Source)String() string {
+	switch s {
+	case SourceADSB:   return "ADSB"
+	case SourceADSR:   return "ADSR"
+	case SourceTISB:   return "TISB"
+	case SourceMLAT:   return "MLAT"
+	case SourceModeS:  return "ModeS"
+	case SourceModeAC: return "ModeAC"
+	default:           return "unknown"
+	}
+}
+
+// Quality holds the integrity & accuracy indicators that ADS-B transmitters report about
+// their own data. It is embedded in Msg; each field has a Has method. The meaning of the
+// values depends on the ADS-B version (see DO-260B); version 0 transmitters report NUC
+// values instead, which we store as NIC & NACv.
+type Quality struct {
+	ADSBVersion  int64 `json:",omitempty"` // 0, 1 or 2
+	NIC          int64 `json:",omitempty"` // Navigation Integrity Category (0-11)
+	NACp         int64 `json:",omitempty"` // Navigation Accuracy Category, position (0-11)
+	NACv         int64 `json:",omitempty"` // Navigation Accuracy Category, velocity (0-4)
+	SIL          int64 `json:",omitempty"` // Source Integrity Level (0-3)
+	GVA          int64 `json:",omitempty"` // Geometric Vertical Accuracy (0-3)
+
+	hasADSBVersion bool
+	hasNIC         bool
+	hasNACp        bool
+	hasNACv        bool
+	hasSIL         bool
+	hasGVA         bool
+}
+
+func (q Quality)HasADSBVersion() bool { return q.hasADSBVersion }
+func (q Quality)HasNIC()         bool { return q.hasNIC }
+func (q Quality)HasNACp()        bool { return q.hasNACp }
+func (q Quality)HasNACv()        bool { return q.hasNACv }
+func (q Quality)HasSIL()         bool { return q.hasSIL }
+func (q Quality)HasGVA()         bool { return q.hasGVA }
+
+func (q *Quality)SetADSBVersion(v int64) { q.ADSBVersion, q.hasADSBVersion = v, true }
+func (q *Quality)SetNIC(v int64)         { q.NIC, q.hasNIC = v, true }
+func (q *Quality)SetNACp(v int64)        { q.NACp, q.hasNACp = v, true }
+func (q *Quality)SetNACv(v int64)        { q.NACv, q.hasNACv = v, true }
+func (q *Quality)SetSIL(v int64)         { q.SIL, q.hasSIL = v, true }
+func (q *Quality)SetGVA(v int64)         { q.GVA, q.hasGVA = v, true }
+
+// Update copies over any fields that are present in the other Quality.
+func (q *Quality)Update(from Quality) {
+	if from.hasADSBVersion { q.SetADSBVersion(from.ADSBVersion) }
+	if from.hasNIC         { q.SetNIC(from.NIC) }
+	if from.hasNACp        { q.SetNACp(from.NACp) }
+	if from.hasNACv        { q.SetNACv(from.NACv) }
+	if from.hasSIL         { q.SetSIL(from.SIL) }
+	if from.hasGVA         { q.SetGVA(from.GVA) }
+}
+
+// The 95% accuracy bound (Estimated Position Uncertainty) for each NACp, in meters.
+var nacpEPU = []float64{0, 18520, 7408, 3704, 1852, 926, 555.6, 185.2, 92.6, 30, 10, 3}
+
+// EPU returns the 95% bound on the position error implied by NACp, in meters; false if NACp
+// is missing, or says the accuracy is unknown.
+func (q Quality)EPU() (float64, bool) {
+	if !q.hasNACp || q.NACp <= 0 || q.NACp >= int64(len(nacpEPU)) {
+		return 0, false
+	}
+	return nacpEPU[q.NACp], true
+}
+
+// sourceFromSBS1 guesses where an SBS-1 message came from. dump1090 marks non-ICAO addresses
+// (which are nearly always TIS-B) with a '~', and only ADS-B gives the position & velocity
+// subtypes.
+func sourceFromSBS1(m *Msg) Source {
+	switch {
+	case m.Type == "MLAT": return SourceMLAT
+	case m.Type != "MSG":  return SourceUnknown
+	case m.IsMasked():     return SourceTISB
+	case m.SubType >= 5:   return SourceModeS
+	default:               return SourceADSB
+	}
+}
@@ -0,0 +1,145 @@
+package adsb
+
+import (
+	"testing"
+	"time"
+)
+
+// setBits writes the n bit value into data, starting at (1-indexed) bit 'first'.
+func setBits(data []byte, first, n int, val uint32) {
+	for i:=0; i<n; i++ {
+		bit := first - 1 + i
+		mask := byte(1) << (7 - uint(bit%8))
+		if val & (1 << uint(n-1-i)) != 0 {
+			data[bit/8] |= mask
+		} else {
+			data[bit/8] &^= mask
+		}
+	}
+}
+
+// makeExtendedSquitter builds a DF17/18 frame, with valid parity.
+func makeExtendedSquitter(df, cf, addr uint32, me []byte) []byte {
+	frame := make([]byte, 14)
+	setBits(frame, 1, 5, df)
+	setBits(frame, 6, 3, cf)
+	setBits(frame, 9, 24, addr)
+	copy(frame[4:11], me)
+	p := crc24(frame[:11])
+	frame[11], frame[12], frame[13] = byte(p>>16), byte(p>>8), byte(p)
+	return frame
+}
+
+func TestSourceFromSBS1(t *testing.T) {
+	tests := []struct {
+		SBS     string
+		Source  Source
+	}{
+		{"MSG,3,1,1,A81BD0,1,2015/11/27,21:31:03.354,2015/11/27,21:31:03.316,,20125,,,36.69804,-121.86007,,,,,,0", SourceADSB},
+		{"MSG,3,1,1,~A81BD0,1,2015/11/27,21:31:03.354,2015/11/27,21:31:03.316,,20125,,,36.69804,-121.86007,,,,,,0", SourceTISB},
+		{"MSG,5,1,1,A81BD0,1,2015/11/27,21:31:03.354,2015/11/27,21:31:03.316,,20125,,,,,,,,,,0", SourceModeS},
+		{"MLAT,3,1,1,A76E37,1,2016/03/10,18:22:22.989,2016/03/10,18:22:22.989,,28211,497,66,36.8347,-120.4883,1696,,,,,,,,", SourceMLAT},
+	}
+	for i,test := range tests {
+		m := Msg{}
+		if err := m.FromSBS1(test.SBS); err != nil { t.Fatal(err) }
+		if m.Source != test.Source {
+			t.Errorf("[%d] source %s, expected %s", i, m.Source, test.Source)
+		}
+	}
+}
+
+func TestQualityFromModeS(t *testing.T) {
+	tm := time.Now()
+
+	// Airborne position (type code 11), from The 1090MHz Riddle
+	m := Msg{}
+	if err := m.FromAVR("*8D40621D58C382D690C8AC2863A7;", tm); err != nil { t.Fatal(err) }
+	if m.Source != SourceADSB || !m.HasNIC() || m.NIC != 8 || m.Altitude != 38000 {
+		t.Errorf("bad position msg: %s %s NIC=%d alt=%d", m, m.Source, m.NIC, m.Altitude)
+	}
+
+	// Velocity (NACv is 0 in this one)
+	m = Msg{}
+	if err := m.FromAVR("*8D485020994409940838175B284F;", tm); err != nil { t.Fatal(err) }
+	if !m.HasNACv() || m.NACv != 0 {
+		t.Errorf("bad NACv: %d", m.NACv)
+	}
+
+	// Operational status, version 2, airborne: NACp 9, GVA 2, SIL 3
+	me := make([]byte, 7)
+	setBits(me, 1, 5, 31)
+	setBits(me, 41, 3, 2)
+	setBits(me, 45, 4, 9)
+	setBits(me, 49, 2, 2)
+	setBits(me, 51, 2, 3)
+	m = Msg{}
+	if err := m.FromModeS(makeExtendedSquitter(17, 5, 0xA81BD0, me), tm); err != nil { t.Fatal(err) }
+	if m.ADSBVersion != 2 || m.NACp != 9 || m.GVA != 2 || m.SIL != 3 || !m.HasGVA() {
+		t.Errorf("bad operational status: %+v", m.Quality)
+	}
+	if epu,ok := m.EPU(); !ok || epu != 30 {
+		t.Errorf("bad EPU: %f, %v", epu, ok)
+	}
+
+	// Version 0 only gives us the version
+	setBits(me, 41, 3, 0)
+	m = Msg{}
+	if err := m.FromModeS(makeExtendedSquitter(17, 5, 0xA81BD0, me), tm); err != nil { t.Fatal(err) }
+	if !m.HasADSBVersion() || m.ADSBVersion != 0 || m.HasNACp() || m.HasSIL() {
+		t.Errorf("bad v0 operational status: %+v", m.Quality)
+	}
+}
+
+func TestNonTransponder(t *testing.T) {
+	me := make([]byte, 7)
+	setBits(me, 1, 5, 11) // Airborne position
+	setBits(me, 9, 12, 0xC38) // 38000ft
+
+	tests := []struct {
+		CF      uint32
+		Source  Source
+		Icao24  IcaoId
+		Err     bool
+	}{
+		{0, SourceADSB, "A81BD0",  false},
+		{1, SourceADSB, "~A81BD0", false},
+		{2, SourceTISB, "A81BD0",  false},
+		{5, SourceTISB, "~A81BD0", false},
+		{6, SourceADSR, "A81BD0",  false},
+		{3, SourceUnknown, "",     true},
+	}
+
+	for i,test := range tests {
+		m := Msg{}
+		err := m.FromModeS(makeExtendedSquitter(18, test.CF, 0xA81BD0, me), time.Now())
+		if (err != nil) != test.Err {
+			t.Errorf("[%d] err %v", i, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if m.Source != test.Source || m.Icao24 != test.Icao24 || m.Altitude != 38000 {
+			t.Errorf("[%d] got %s/%s/%d, expected %s/%s", i, m.Source, m.Icao24, m.Altitude,
+				test.Source, test.Icao24)
+		}
+	}
+}
+
+func TestQualityUpdate(t *testing.T) {
+	q := Quality{}
+	q.SetNIC(8)
+	q.SetSIL(3)
+
+	other := Quality{}
+	other.SetNIC(7)
+	other.SetNACp(10)
+
+	q.Update(other)
+	if q.NIC != 7 || q.NACp != 10 || q.SIL != 3 || q.HasGVA() {
+		t.Errorf("bad update: %+v", q)
+	}
+	if _,ok := (Quality{}).EPU(); ok {
+		t.Errorf("EPU without NACp")
+	}
+}
@@ -0,0 +1,282 @@
+package adsb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Some states assign 24-bit addresses algorithmically from the aircraft's registration, so
+// we can convert between the two without a database. These tables follow the schemes
+// documented by the FAA and used in dump1090's registrations.js.
+//
+// Japan is not covered: JA registrations get addresses from Japan's block (840000-87FFFF)
+// as they are issued, and there is no known formula linking the two. As for most of the
+// world, JA aircraft need a database lookup (see the aircraftdb package).
+
+// N-numbers are 'N', a digit 1-9, then up to four more digits, with up to two letters
+// (never I or O) on the end; at most five characters after the 'N'. They fill the block
+// A00001-ADF7C7 in order; each bucket below is the number of addresses used by all the
+// registrations sharing a given prefix.
+const (
+	nnumberFirst   = 0xA00001
+	nnumberLast    = 0xADF7C7
+	nnumberLetters = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+	nnumberSuffixSize = 1 + 24*(1+24)                      // "", "A", "AA" .. "ZZ"
+	nnumberBucket4    = 1 + 24 + 10                        // N1234, N1234A .. N1234Z, N12340 .. N12349
+	nnumberBucket3    = nnumberSuffixSize + 10*nnumberBucket4
+	nnumberBucket2    = nnumberSuffixSize + 10*nnumberBucket3
+	nnumberBucket1    = nnumberSuffixSize + 10*nnumberBucket2
+)
+
+var nnumberBuckets = []int{nnumberBucket2, nnumberBucket3, nnumberBucket4}
+
+// nnumberSuffix decodes an offset into the one or two letters that end an N-number.
+func nnumberSuffix(offset int) string {
+	if offset == 0 { return "" }
+	offset--
+	s := nnumberLetters[offset/25 : offset/25+1]
+	if offset % 25 > 0 {
+		s += nnumberLetters[offset%25-1 : offset%25]
+	}
+	return s
+}
+
+func nnumberSuffixOffset(s string) (int, error) {
+	if len(s) > 2 { return 0, fmt.Errorf("too many letters") }
+	offset := 0
+	for i,c := range s {
+		idx := strings.IndexRune(nnumberLetters, c)
+		if idx < 0 { return 0, fmt.Errorf("bad letter '%c'", c) }
+		if i == 0 {
+			offset += 1 + idx*25
+		} else {
+			offset += 1 + idx
+		}
+	}
+	return offset, nil
+}
+
+func nnumberFromAddr(addr uint32) string {
+	if addr < nnumberFirst || addr > nnumberLast { return "" }
+
+	offset := int(addr - nnumberFirst)
+	reg := "N" + strconv.Itoa(1 + offset/nnumberBucket1)
+	offset %= nnumberBucket1
+
+	for _,bucket := range nnumberBuckets {
+		if offset < nnumberSuffixSize {
+			return reg + nnumberSuffix(offset)
+		}
+		offset -= nnumberSuffixSize
+		reg += strconv.Itoa(offset / bucket)
+		offset %= bucket
+	}
+
+	// The last character is a single letter or digit
+	if offset == 0 {
+		return reg
+	} else if offset <= 24 {
+		return reg + nnumberLetters[offset-1:offset]
+	}
+	return reg + strconv.Itoa(offset-25)
+}
+
+func nnumberToAddr(reg string) (uint32, error) {
+	s := strings.TrimPrefix(reg, "N")
+	if len(s) < 1 || len(s) > 5 || s[0] < '1' || s[0] > '9' {
+		return 0, fmt.Errorf("'%s' is not a valid N-number", reg)
+	}
+
+	addr := nnumberFirst + int(s[0]-'1')*nnumberBucket1
+	s = s[1:]
+
+	for _,bucket := range nnumberBuckets {
+		if len(s) == 0 {
+			return uint32(addr), nil
+		} else if s[0] < '0' || s[0] > '9' {
+			offset,err := nnumberSuffixOffset(s)
+			if err != nil { return 0, fmt.Errorf("'%s' is not a valid N-number: %v", reg, err) }
+			return uint32(addr + offset), nil
+		}
+		addr += nnumberSuffixSize + int(s[0]-'0')*bucket
+		s = s[1:]
+	}
+
+	if len(s) == 0 {
+		return uint32(addr), nil
+	} else if s[0] >= '0' && s[0] <= '9' {
+		return uint32(addr + 25 + int(s[0]-'0')), nil
+	} else if idx := strings.IndexByte(nnumberLetters, s[0]); idx >= 0 {
+		return uint32(addr + 1 + idx), nil
+	}
+	return 0, fmt.Errorf("'%s' is not a valid N-number", reg)
+}
+
+const strideAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// A strideMapping assigns a block of addresses to registrations of the form prefix+XYZ,
+// where XYZ are three letters; the address is start + X*s1 + Y*s2 + Z (less the offset of
+// the first registration in the block, if it isn't AAA). When s1 and s2 are powers of two,
+// there are gaps in the block.
+type strideMapping struct {
+	Start       uint32
+	S1,S2       uint32
+	Prefix      string
+	First,Last  string // Range of suffixes in the block; empty means AAA-ZZZ
+}
+
+var strideMappings = []strideMapping{
+	{0x008011, 26*26, 26, "ZS-", "", ""},
+	{0x390000, 1024,  32, "F-G", "", ""},
+	{0x398000, 1024,  32, "F-H", "", ""},
+	{0x3C4421, 1024,  32, "D-A", "AAA", "OZZ"},
+	{0x3C0001, 26*26, 26, "D-A", "PAA", "ZZZ"},
+	{0x3C8421, 1024,  32, "D-B", "AAA", "OZZ"},
+	{0x3C2001, 26*26, 26, "D-B", "PAA", "ZZZ"},
+	{0x3CC000, 26*26, 26, "D-C", "", ""},
+	{0x3D04A8, 26*26, 26, "D-E", "", ""},
+	{0x3D4950, 26*26, 26, "D-F", "", ""},
+	{0x3D8DF8, 26*26, 26, "D-G", "", ""},
+	{0x3DD2A0, 26*26, 26, "D-H", "", ""},
+	{0x3E1748, 26*26, 26, "D-I", "", ""},
+	{0x448421, 1024,  32, "OO-", "", ""},
+	{0x458421, 1024,  32, "OY-", "", ""},
+	{0x460000, 26*26, 26, "OH-", "", ""},
+	{0x468421, 1024,  32, "SX-", "", ""},
+	{0x490421, 1024,  32, "CS-", "", ""},
+	{0x4A0421, 1024,  32, "YR-", "", ""},
+	{0x4B8421, 1024,  32, "TC-", "", ""},
+	{0x740421, 1024,  32, "JY-", "", ""},
+	{0x760421, 1024,  32, "AP-", "", ""},
+	{0x768421, 1024,  32, "9V-", "", ""},
+	{0x778421, 1024,  32, "YK-", "", ""},
+	{0xC00001, 26*26, 26, "C-F", "", ""},
+	{0xC044A9, 26*26, 26, "C-G", "", ""},
+	{0xE01041, 4096,  64, "LV-", "", ""},
+}
+
+func (sm strideMapping)offsetOf(suffix string) uint32 {
+	return uint32(suffix[0]-'A')*sm.S1 + uint32(suffix[1]-'A')*sm.S2 + uint32(suffix[2]-'A')
+}
+
+func (sm strideMapping)firstSuffix() string {
+	if sm.First == "" { return "AAA" }
+	return sm.First
+}
+
+func (sm strideMapping)lastSuffix() string {
+	if sm.Last == "" { return "ZZZ" }
+	return sm.Last
+}
+
+func (sm strideMapping)end() uint32 {
+	return sm.Start - sm.offsetOf(sm.firstSuffix()) + sm.offsetOf(sm.lastSuffix())
+}
+
+func (sm strideMapping)registration(addr uint32) string {
+	if addr < sm.Start || addr > sm.end() { return "" }
+
+	offset := addr - sm.Start + sm.offsetOf(sm.firstSuffix())
+	i1,i2,i3 := offset/sm.S1, (offset%sm.S1)/sm.S2, offset%sm.S2
+	if i1 >= 26 || i2 >= 26 || i3 >= 26 {
+		return "" // In one of the gaps
+	}
+	return sm.Prefix + strideAlphabet[i1:i1+1] + strideAlphabet[i2:i2+1] + strideAlphabet[i3:i3+1]
+}
+
+// addr returns the address for the three letter suffix, if it falls in this mapping.
+func (sm strideMapping)addr(suffix string) (uint32, bool) {
+	if len(suffix) != 3 || strings.Trim(suffix, strideAlphabet) != "" {
+		return 0, false
+	} else if suffix < sm.firstSuffix() || suffix > sm.lastSuffix() {
+		return 0, false
+	}
+	return sm.Start - sm.offsetOf(sm.firstSuffix()) + sm.offsetOf(suffix), true
+}
+
+// A numericMapping assigns a block of addresses to registrations made of a prefix and a
+// fixed number of digits.
+type numericMapping struct {
+	Start   uint32
+	First   int
+	Count   int
+	Prefix  string
+	Digits  int
+}
+
+var numericMappings = []numericMapping{
+	{0x140000, 0,    100000, "RA-",  5},
+	{0x0B03E8, 1000, 1000,   "CU-T", 4},
+}
+
+func (nm numericMapping)registration(addr uint32) string {
+	if addr < nm.Start || addr >= nm.Start + uint32(nm.Count) { return "" }
+	return fmt.Sprintf("%s%0*d", nm.Prefix, nm.Digits, nm.First + int(addr - nm.Start))
+}
+
+func (nm numericMapping)addr(digits string) (uint32, bool) {
+	if len(digits) != nm.Digits { return 0, false }
+	n,err := strconv.Atoi(digits)
+	if err != nil || n < nm.First || n >= nm.First + nm.Count { return 0, false }
+	return nm.Start + uint32(n - nm.First), true
+}
+
+// Registration returns the aircraft's registration (tail number), for addresses in blocks
+// that are allocated algorithmically (e.g. US N-numbers, Canadian C-Fxxx and C-Gxxx); or
+// the empty string, if the address isn't in one of those blocks (which includes Japan).
+func (id IcaoId)Registration() string {
+	addr,err := id.Uint32()
+	if err != nil || id.IsNonICAO() {
+		return ""
+	}
+
+	if reg := nnumberFromAddr(addr); reg != "" {
+		return reg
+	}
+	for _,sm := range strideMappings {
+		if reg := sm.registration(addr); reg != "" {
+			return reg
+		}
+	}
+	for _,nm := range numericMappings {
+		if reg := nm.registration(addr); reg != "" {
+			return reg
+		}
+	}
+	return ""
+}
+
+// IcaoIdFromRegistration is the inverse of IcaoId.Registration. It is case insensitive, and
+// the hyphen after the nationality prefix is optional.
+func IcaoIdFromRegistration(reg string) (IcaoId, error) {
+	reg = strings.ToUpper(strings.TrimSpace(reg))
+
+	if strings.HasPrefix(reg, "N") {
+		addr,err := nnumberToAddr(reg)
+		if err != nil { return "", err }
+		return IcaoIdFromUint32(addr), nil
+	}
+
+	compact := strings.Replace(reg, "-", "", -1)
+	for _,sm := range strideMappings {
+		prefix := strings.Replace(sm.Prefix, "-", "", -1)
+		if strings.HasPrefix(compact, prefix) {
+			if addr,ok := sm.addr(strings.TrimPrefix(compact, prefix)); ok {
+				return IcaoIdFromUint32(addr), nil
+			}
+		}
+	}
+	for _,nm := range numericMappings {
+		prefix := strings.Replace(nm.Prefix, "-", "", -1)
+		if strings.HasPrefix(compact, prefix) {
+			if addr,ok := nm.addr(strings.TrimPrefix(compact, prefix)); ok {
+				return IcaoIdFromUint32(addr), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("registration '%s' is not in an algorithmically allocated block", reg)
+}
+
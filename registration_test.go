@@ -0,0 +1,93 @@
+package adsb
+
+import "testing"
+
+func TestRegistration(t *testing.T) {
+	tests := []struct {
+		Id   IcaoId
+		Reg  string
+	}{
+		{"A00001", "N1"},
+		{"A00002", "N1A"},
+		{"A00003", "N1AA"},
+		{"A00004", "N1AB"},
+		{"A061D9", "N12345"},
+		{"ADF7C7", "N99999"},
+		{"C00001", "C-FAAA"},
+		{"C044A8", "C-FZZZ"},
+		{"C044A9", "C-GAAA"},
+		{"3C4421", "D-AAAA"},
+		{"3C0001", "D-APAA"},
+		{"140000", "RA-00000"},
+		{"0B03E8", "CU-T1000"},
+		{"ADF7C8", ""}, // US military block, not an N-number
+		{"3C4440", ""}, // A gap in a stride mapping
+		{"~A00001", ""},
+	}
+
+	for i,test := range tests {
+		if actual := test.Id.Registration(); actual != test.Reg {
+			t.Errorf("[%d] %s: Registration %q, expected %q", i, test.Id, actual, test.Reg)
+		}
+		if test.Reg == "" { continue }
+		if actual,err := IcaoIdFromRegistration(test.Reg); err != nil || actual != test.Id {
+			t.Errorf("[%d] %s: IcaoIdFromRegistration %q, expected %q (%v)", i, test.Reg, actual, test.Id, err)
+		}
+	}
+}
+
+func TestRegistrationRoundTrip(t *testing.T) {
+	// Every address in the N-number block should map to a distinct, valid N-number
+	seen := map[string]bool{}
+	for addr := uint32(nnumberFirst); addr <= nnumberLast; addr++ {
+		id := IcaoIdFromUint32(addr)
+		reg := id.Registration()
+		if reg == "" || len(reg) > 6 || seen[reg] {
+			t.Fatalf("%s: bad registration %q", id, reg)
+		}
+		seen[reg] = true
+		if back,err := IcaoIdFromRegistration(reg); err != nil || back != id {
+			t.Fatalf("%s: %s mapped back to %s (%v)", id, reg, back, err)
+		}
+	}
+
+	for _,sm := range strideMappings {
+		for addr := sm.Start; addr <= sm.end(); addr++ {
+			id := IcaoIdFromUint32(addr)
+			reg := sm.registration(addr)
+			if reg == "" { continue }
+			if back,err := IcaoIdFromRegistration(reg); err != nil || back != id {
+				t.Errorf("%s: %s mapped back to %s (%v)", id, reg, back, err)
+			}
+		}
+	}
+}
+
+func TestIcaoIdFromRegistration(t *testing.T) {
+	tests := []struct {
+		Reg   string
+		Id    IcaoId
+		Err   bool
+	}{
+		{"n12345",  "A061D9", false},
+		{" N1 ",    "A00001", false},
+		{"CFAAA",   "C00001", false},
+		{"c-gaaa",  "C044A9", false},
+		{"N0123",   "",       true}, // Can't start with a zero
+		{"N123456", "",       true}, // Too long
+		{"N1ABC",   "",       true}, // Too many letters
+		{"N1I",     "",       true}, // No I or O
+		{"N12A3",   "",       true}, // Letters only at the end
+		{"G-ABCD",  "",       true}, // UK registrations aren't algorithmic
+		{"C-FAA",   "",       true},
+	}
+
+	for i,test := range tests {
+		actual,err := IcaoIdFromRegistration(test.Reg)
+		if (err != nil) != test.Err {
+			t.Errorf("[%d] %q: err %v, expected error=%v", i, test.Reg, err, test.Err)
+		} else if actual != test.Id {
+			t.Errorf("[%d] %q: got %q, expected %q", i, test.Reg, actual, test.Id)
+		}
+	}
+}
@@ -41,7 +41,7 @@ const (
 	// https://github.com/mutability/mlat-client/blob/master/mlat/client/output.py#L264
 	ExtSBSNumStations = 22
 	// 23 left blank for now
-	ExtSBSErrorEstimate = 24
+	ExtSBSErrorEstimate = 24 // In metres
 )
 
 // Hack global. Maybe should have a parser struct.
@@ -59,6 +59,21 @@ func toTimeUTC(d,t string) (time.Time, error) {
 	}
 }
 
+// SBS1 flags are "-1" for true, "0" for false, and empty if not present.
+func parseSBS1Flag(s string) (val bool, present bool) {
+	switch strings.TrimSpace(s) {
+	case "": return false, false
+	case "0": return false, true
+	default: return true, true
+	}
+}
+
+func formatSBS1Flag(val, present bool) string {
+	if !present { return "" }
+	if val { return "-1" }
+	return "0"
+}
+
 func (m *Msg)FromSBS1(s string) error {
 	ioReader := strings.NewReader(s)
 	csvReader := csv.NewReader(ioReader)
@@ -79,6 +94,7 @@ func (m *Msg)FromSBS1(s string) error {
 			m.SubType = i
 		}
 		m.Icao24 = IcaoId(r[SBS1Icao24])
+		m.Source = sourceFromSBS1(m)
 		
 		if t,err := toTimeUTC(r[SBS1DateGen], r[SBS1TimeGen]); err != nil {
 			return err
@@ -140,31 +156,47 @@ func (m *Msg)FromSBS1(s string) error {
 			} else if long,err := strconv.ParseFloat(r[SBS1Longitude], 64); err != nil {
 				return err
 			} else {//if lat!=0.0 && long>0.0 { // Some dodgy data outputs nil locations as "0.00,0.00"
-				m.Position = geo.Latlong{lat, long}
+				m.Position = geo.Latlong{Lat:lat, Long:long}
 				m.hasPosition = true
 			}
 		}
 
+		m.AlertSquawkChange, m.hasAlertSquawkChange = parseSBS1Flag(r[SBS1AlertSquawkChange])
+		m.Emergency, m.hasEmergency = parseSBS1Flag(r[SBS1Emergency])
+		m.SPI, m.hasSPI = parseSBS1Flag(r[SBS1SPI])
+		m.IsOnGround, m.hasOnGround = parseSBS1Flag(r[SBS1IsOnGround])
+
 		// Extended basestation format ?
 		if len(r) == 25 {
 			if (r[ExtSBSNumStations] != "") {
 				if i,err := strconv.ParseInt(r[ExtSBSNumStations], 10, 64); err != nil {
+					return err
+				} else {
 					m.NumStations = i
 				}
 			}
-			// Not sure what this data type is, omit for now
-			//if (r[ExtSBSErrorEstimate] != "") {
-			//	if i,err := strconv.ParseInt(r[ExtSBSErrorEstimate], 10, 64); err != nil {
-			//		m.ErrorEstimate = i
-			//	}
-			//}
+			// mlat-client writes this as a whole number of metres, but allow for fractions
+			if (r[ExtSBSErrorEstimate] != "") {
+				if f,err := strconv.ParseFloat(r[ExtSBSErrorEstimate], 64); err != nil {
+					return err
+				} else {
+					m.ErrorEstimate = f
+				}
+			}
 		}
 	}
 	return nil
 }
 
+// ToSBS1 formats the message as SBS-1; MLAT messages use the ext_basestation format, to
+// carry the station count & error estimate.
 func (m *Msg)ToSBS1() string {
 	r := make([]string, 22)
+	if m.IsMLAT() {
+		r = make([]string, 25)
+		if m.NumStations > 0   { r[ExtSBSNumStations]   = fmt.Sprintf("%d", m.NumStations) }
+		if m.ErrorEstimate > 0 { r[ExtSBSErrorEstimate] = fmt.Sprintf("%.0f", m.ErrorEstimate) }
+	}
 
 	r[SBS1Message]      = m.Type
 	r[SBS1Transmission] = fmt.Sprintf("%d", m.SubType)
@@ -187,13 +219,10 @@ func (m *Msg)ToSBS1() string {
 	}
 	r[SBS1VerticalRate] = fmt.Sprintf("%d", m.VerticalRate)
 	r[SBS1Squawk]       = m.Squawk
-	//r[SBS1AlertSquawkChange] = "" // = 18 // (Squawk change)	 Flag to indicate squawk has changed.
-	//r[SBS1Emergency] = "" // = 19 //	 Flag to indicate emergency code has been set
-	//r[SBS1SPI] = "" // = 20 // (Ident)	 Flag to indicate transponder Ident has been activated.
-	//r[SBS1IsOnGround] = "" // = 21 //	 Flag to indicate ground squat switch is active
-
-	// May need to do something here ...
-	if m.IsMLAT() {}
-	
+	r[SBS1AlertSquawkChange] = formatSBS1Flag(m.AlertSquawkChange, m.hasAlertSquawkChange)
+	r[SBS1Emergency]    = formatSBS1Flag(m.Emergency, m.hasEmergency)
+	r[SBS1SPI]          = formatSBS1Flag(m.SPI, m.hasSPI)
+	r[SBS1IsOnGround]   = formatSBS1Flag(m.IsOnGround, m.hasOnGround)
+
 	return strings.Join(r, ",")
 }
@@ -4,6 +4,7 @@ package adsb
 import(
 	"fmt"
 	"bufio"
+	"encoding/json"
 	"strings"
 	"testing"
 )
@@ -65,6 +66,41 @@ func TestExtendedSBSParsing(t *testing.T) {
 	}
 }
 
+func TestExtendedSBSFields(t *testing.T) {
+	text := "MLAT,3,1,1,A76E37,1,2016/03/10,18:22:22.989,2016/03/10,18:22:22.989,,28211,497,66,36.83470,-120.48830,1696,,,,,,5,,120"
+	m := Msg{}
+	if err := m.FromSBS1(text); err != nil { t.Fatal(err) }
+	if m.NumStations != 5 || m.ErrorEstimate != 120 {
+		t.Errorf("bad ext fields: %d, %f", m.NumStations, m.ErrorEstimate)
+	}
+	if cm := (CompositeMsg{Msg:m}); cm.NumStations != 5 || cm.ErrorEstimate != 120 {
+		t.Errorf("ext fields not on composite")
+	}
+
+	m2 := Msg{}
+	if err := m2.FromSBS1(m.ToSBS1()); err != nil {
+		t.Errorf("could not reparse '%s': %v", m.ToSBS1(), err)
+	} else if m2.NumStations != 5 || m2.ErrorEstimate != 120 || !m2.IsMLAT() {
+		t.Errorf("ext fields lost in round trip: %s", m.ToSBS1())
+	}
+
+	// Both MLAT fields are in the JSON, but only when known
+	if j,err := json.Marshal(m); err != nil || !strings.Contains(string(j), `"NumStations":5,"ErrorEstimate":120`) {
+		t.Errorf("MLAT fields not in JSON: %v %s", err, j)
+	}
+	adsbMsg := Msg{}
+	if err := adsbMsg.FromSBS1("MSG,3,1,1,A81BD0,1,2015/11/27,21:31:03.354,2015/11/27,21:31:03.316,,20125,,,36.69804,-121.86007,,,,,,0"); err != nil {
+		t.Fatal(err)
+	}
+	if j,_ := json.Marshal(adsbMsg); strings.Contains(string(j), "NumStations") || strings.Contains(string(j), "ErrorEstimate") {
+		t.Errorf("unknown MLAT fields in JSON: %s", j)
+	}
+
+	if err := m.FromSBS1(strings.Replace(text, ",5,,120", ",five,,120", 1)); err == nil {
+		t.Errorf("bad station count was accepted")
+	}
+}
+
 func TestMaskededSBSParsing(t *testing.T) {
 	scanner := bufio.NewScanner(strings.NewReader(maskedsbs))
 	for scanner.Scan() {
@@ -80,3 +116,20 @@ func TestMaskededSBSParsing(t *testing.T) {
 		}
 	}
 }
+
+func TestSBSFlags(t *testing.T) {
+	m := Msg{}
+	text := "MSG,6,1,1,A81BD0,1,2015/11/27,21:31:05.255,2015/11/27,21:31:05.253,,,,,,,,7700,-1,-1,0,0"
+	if err := m.FromSBS1(text); err != nil {
+		t.Fatalf("parse fail on '%s': %v", text, err)
+	}
+	if !m.HasAlertSquawkChange() || !m.AlertSquawkChange { t.Errorf("alert flag not parsed") }
+	if !m.HasEmergency() || !m.Emergency { t.Errorf("emergency flag not parsed") }
+	if !m.HasSPI() || m.SPI { t.Errorf("SPI flag not parsed") }
+	if !m.HasOnGround() || m.IsOnGround { t.Errorf("ground flag not parsed") }
+	if !strings.HasSuffix(m.ToSBS1(), ",7700,-1,-1,0,0") { t.Errorf("flags not output: %s", m.ToSBS1()) }
+
+	m = Msg{}
+	if err := m.FromSBS1(strings.TrimSpace(strings.Split(sbs, "\n")[2])); err != nil { t.Fatal(err) }
+	if m.HasEmergency() || m.HasSPI() { t.Errorf("blank flags were parsed as present") }
+}
@@ -0,0 +1,266 @@
+package simulator
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/geo"
+)
+
+// The inverse of the decoding in the adsb package: we build the raw Mode S frames that an
+// aircraft would send, with valid parity, so that they can be fed to FromModeS, FromAVR or
+// FromBeast.
+
+// {{{ Bit twiddling
+
+const crc24Generator = 0x1FFF409
+
+// crc24 computes the Mode S parity over the data.
+func crc24(data []byte) uint32 {
+	crc := uint32(0)
+	for _,b := range data {
+		crc ^= uint32(b) << 16
+		for i:=0; i<8; i++ {
+			crc <<= 1
+			if crc & 0x1000000 != 0 { crc ^= crc24Generator }
+		}
+	}
+	return crc & 0xFFFFFF
+}
+
+// putBits writes the n bit value into data, starting at (1-indexed) bit 'first'.
+func putBits(data []byte, first, n int, val uint32) {
+	for i:=0; i<n; i++ {
+		bit := first - 1 + i
+		mask := byte(1) << (7 - uint(bit%8))
+		if val & (1 << uint(n-1-i)) != 0 {
+			data[bit/8] |= mask
+		} else {
+			data[bit/8] &^= mask
+		}
+	}
+}
+
+// encodeAC12 builds the 12 bit altitude code used in ADS-B position messages, using 25ft
+// increments (i.e. with the Q bit set).
+func encodeAC12(alt int64) uint32 {
+	n := uint32((alt + 1000 + 12) / 25) & 0x7FF
+	return (n & 0x7F0) << 1 | 0x0010 | (n & 0x000F)
+}
+
+// modeAToID13 rearranges a Mode A code (one octal digit per hex nibble, so 0x7700 is squawk
+// 7700) into the 13 bit identity field (C1 A1 C2 A2 C4 A4 X B1 D1 B2 D2 B4 D4).
+func modeAToID13(modeA uint32) uint32 {
+	id13 := uint32(0)
+	for _,b := range []struct{ modeA,id13 uint32 }{
+		{0x0010, 0x1000}, {0x1000, 0x0800}, {0x0020, 0x0400}, {0x2000, 0x0200}, // C1 A1 C2 A2
+		{0x0040, 0x0100}, {0x4000, 0x0080}, {0x0100, 0x0020}, {0x0001, 0x0010}, // C4 A4 B1 D1
+		{0x0200, 0x0008}, {0x0002, 0x0004}, {0x0400, 0x0002}, {0x0004, 0x0001}, // B2 D2 B4 D4
+	} {
+		if modeA & b.modeA != 0 { id13 |= b.id13 }
+	}
+	return id13
+}
+
+const adsbCallsignChars = "#ABCDEFGHIJKLMNOPQRSTUVWXYZ##### ###############0123456789######"
+
+// }}}
+// {{{ CPR encoding
+
+const (
+	cprMax = 1 << 17
+	cprNZ  = 15
+)
+
+// cprNL is the number of longitude zones at the latitude.
+func cprNL(lat float64) int {
+	lat = math.Abs(lat)
+	switch {
+	case lat == 0:  return 59
+	case lat == 87: return 2
+	case lat > 87:  return 1
+	}
+	a := 1 - math.Cos(math.Pi / (2 * cprNZ))
+	b := math.Pow(math.Cos(math.Pi / 180 * lat), 2)
+	return int(math.Floor(2 * math.Pi / math.Acos(1 - a/b)))
+}
+
+// cprMod is a modulus that is always positive.
+func cprMod(a, b float64) float64 {
+	r := math.Mod(a, b)
+	if r < 0 { r += b }
+	return r
+}
+
+// cprEncode turns a position into the 17 bit latitude & longitude of an even or odd airborne
+// CPR frame. See DO-260B, A.1.7.
+func cprEncode(pos geo.Latlong, odd bool) (uint32, uint32) {
+	i := 0.0
+	if odd { i = 1 }
+
+	dLat := 360 / (4*cprNZ - i)
+	yz := math.Floor(cprMax * cprMod(pos.Lat, dLat) / dLat + 0.5)
+	rLat := dLat * (yz / cprMax + math.Floor(pos.Lat / dLat))
+
+	dLon := 360 / math.Max(float64(cprNL(rLat)) - i, 1)
+	xz := math.Floor(cprMax * cprMod(pos.Long, dLon) / dLon + 0.5)
+
+	return uint32(yz) % cprMax, uint32(xz) % cprMax
+}
+
+// }}}
+
+// {{{ ExtendedSquitterFrame
+
+// ExtendedSquitterFrame builds an ADS-B frame for the 56 bit ME field, with valid parity. It
+// is DF17, unless the address is masked, in which case it is DF18 with CF=1 (an anonymous
+// address, from a non-transponder device).
+func ExtendedSquitterFrame(id adsb.IcaoId, me []byte) ([]byte, error) {
+	addr,err := id.Uint32()
+	if err != nil {
+		return nil, err
+	} else if len(me) != 7 {
+		return nil, fmt.Errorf("ME field is %d bytes", len(me))
+	}
+
+	frame := make([]byte, 14)
+	frame[0] = adsb.DFExtendedSquitter << 3 | 5 // Capability 5: airborne, level 2+ transponder
+	if id.IsMasked() {
+		frame[0] = adsb.DFNonTransponder << 3 | 1
+	}
+	frame[1], frame[2], frame[3] = byte(addr >> 16), byte(addr >> 8), byte(addr)
+	copy(frame[4:11], me)
+
+	p := crc24(frame[:11])
+	frame[11], frame[12], frame[13] = byte(p >> 16), byte(p >> 8), byte(p)
+	return frame, nil
+}
+
+// }}}
+// {{{ IdentificationME, AirbornePositionME, AirborneVelocityME
+
+// IdentificationME builds the ME field of an identification message (type code 4, category
+// A0); see ExtendedSquitterFrame. Characters that can't be sent become spaces.
+func IdentificationME(callsign string) []byte {
+	me := make([]byte, 7)
+	putBits(me, 1, 5, 4)
+	cs := strings.ToUpper(callsign + "        ")[:8]
+	for i:=0; i<8; i++ {
+		c := strings.IndexByte(adsbCallsignChars, cs[i])
+		if c < 0 || cs[i] == '#' { c = 32 }
+		putBits(me, 9+i*6, 6, uint32(c))
+	}
+	return me
+}
+
+// AirbornePositionME builds the ME field of an airborne position message (type code 11),
+// with the altitude in 25ft increments; see ExtendedSquitterFrame. A pair of frames, one even
+// and one odd, are needed to convey the position.
+func AirbornePositionME(pos geo.Latlong, altitude int64, odd bool) []byte {
+	lat,lon := cprEncode(pos, odd)
+	oddBit := uint32(0)
+	if odd { oddBit = 1 }
+
+	me := make([]byte, 7)
+	putBits(me, 1, 5, 11)
+	putBits(me, 9, 12, encodeAC12(altitude))
+	putBits(me, 22, 1, oddBit)
+	putBits(me, 23, 17, lat)
+	putBits(me, 40, 17, lon)
+	return me
+}
+
+// AirborneVelocityME builds the ME field of a subsonic airborne velocity message (type code
+// 19, subtype 1); see ExtendedSquitterFrame.
+func AirborneVelocityME(groundSpeed, track, verticalRate int64) []byte {
+	me := make([]byte, 7)
+	putBits(me, 1, 5, 19)
+	putBits(me, 6, 3, 1)
+
+	rad := float64(track) * math.Pi / 180
+	vew := int64(math.Round(float64(groundSpeed) * math.Sin(rad)))
+	vns := int64(math.Round(float64(groundSpeed) * math.Cos(rad)))
+	if vew < 0 { putBits(me, 14, 1, 1); vew = -vew }
+	if vns < 0 { putBits(me, 25, 1, 1); vns = -vns }
+	if vew > 1022 { vew = 1022 }
+	if vns > 1022 { vns = 1022 }
+	putBits(me, 15, 10, uint32(vew + 1))
+	putBits(me, 26, 10, uint32(vns + 1))
+
+	vr := verticalRate
+	if vr < 0 { putBits(me, 37, 1, 1); vr = -vr }
+	if vr = (vr + 32)/64 + 1; vr > 511 { vr = 511 }
+	putBits(me, 38, 9, uint32(vr))
+
+	return me
+}
+
+// }}}
+// {{{ SurveillanceAltitudeFrame, SurveillanceIdentityFrame, AllCallFrame
+
+// SurveillanceAltitudeFrame builds a DF4 surveillance reply (the kind a transponder sends
+// when interrogated by radar), with the altitude in 25ft increments. The parity is overlaid
+// with the address, as usual.
+func SurveillanceAltitudeFrame(id adsb.IcaoId, altitude int64) ([]byte, error) {
+	addr,err := id.Uint32()
+	if err != nil {
+		return nil, err
+	}
+
+	ac12 := encodeAC12(altitude)
+	ac13 := (ac12 & 0x0FC0) << 1 | (ac12 & 0x003F)
+
+	frame := make([]byte, 7)
+	frame[0] = adsb.DFShortAltitude << 3 // Flight status 0: airborne, no alert
+	frame[2] = byte(ac13 >> 8)
+	frame[3] = byte(ac13)
+
+	p := crc24(frame[:4]) ^ addr
+	frame[4], frame[5], frame[6] = byte(p >> 16), byte(p >> 8), byte(p)
+	return frame, nil
+}
+
+// SurveillanceIdentityFrame builds a DF5 surveillance reply, carrying the squawk (e.g. "7700").
+func SurveillanceIdentityFrame(id adsb.IcaoId, squawk string) ([]byte, error) {
+	addr,err := id.Uint32()
+	if err != nil {
+		return nil, err
+	}
+	code := uint32(0)
+	if _,err := fmt.Sscanf(squawk, "%04x", &code); err != nil || len(squawk) != 4 || code & 0x8888 != 0 {
+		return nil, fmt.Errorf("'%s' is not a squawk", squawk)
+	}
+
+	frame := make([]byte, 7)
+	putBits(frame, 1, 5, adsb.DFShortIdentity)
+	putBits(frame, 20, 13, modeAToID13(code))
+
+	p := crc24(frame[:4]) ^ addr
+	frame[4], frame[5], frame[6] = byte(p >> 16), byte(p >> 8), byte(p)
+	return frame, nil
+}
+
+// AllCallFrame builds a DF11 all-call reply, as if to an interrogator code of zero.
+func AllCallFrame(id adsb.IcaoId) ([]byte, error) {
+	addr,err := id.Uint32()
+	if err != nil {
+		return nil, err
+	}
+
+	frame := []byte{adsb.DFAllCall << 3 | 5, byte(addr >> 16), byte(addr >> 8), byte(addr), 0, 0, 0}
+	p := crc24(frame[:4])
+	frame[4], frame[5], frame[6] = byte(p >> 16), byte(p >> 8), byte(p)
+	return frame, nil
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,82 @@
+package simulator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/geo"
+)
+
+func TestReplyFrames(t *testing.T) {
+	tm := time.Now()
+
+	frame,err := SurveillanceAltitudeFrame("A81BD0", 12350)
+	if err != nil { t.Fatal(err) }
+	m := adsb.Msg{}
+	if err := m.FromModeS(frame, tm); err != nil || m.Icao24 != "A81BD0" || m.Altitude != 12350 ||
+		m.SubType != 5 || m.IsOnGround { // MSG,5
+		t.Errorf("bad DF4: %v %+v", err, m)
+	}
+
+	frame,err = AllCallFrame("A81BD0")
+	if err != nil { t.Fatal(err) }
+	if err := m.FromModeS(frame, tm); err != nil || m.Icao24 != "A81BD0" || m.SubType != 8 { // MSG,8
+		t.Errorf("bad DF11: %v %+v", err, m)
+	}
+
+	frame,err = SurveillanceIdentityFrame("A81BD0", "4521")
+	if err != nil { t.Fatal(err) }
+	if err := m.FromModeS(frame, tm); err != nil || m.Icao24 != "A81BD0" || m.Squawk != "4521" {
+		t.Errorf("bad DF5: %v %+v", err, m)
+	}
+	if _,err := SurveillanceIdentityFrame("A81BD0", "7800"); err == nil {
+		t.Errorf("bad squawk was accepted")
+	}
+
+	frame,_ = ExtendedSquitterFrame("A81BD0", IdentificationME("vrd961"))
+	if err := m.FromModeS(frame, tm); err != nil || m.Callsign != "VRD961" || !m.HasCallsign() {
+		t.Errorf("bad identification: %v %+v", err, m)
+	}
+
+	frame,_ = ExtendedSquitterFrame("A81BD0", AirborneVelocityME(304, 328, -1856))
+	if err := m.FromModeS(frame, tm); err != nil || m.GroundSpeed != 304 || m.Track != 328 ||
+		m.VerticalRate != -1856 {
+		t.Errorf("bad velocity: %v %d %d %d", err, m.GroundSpeed, m.Track, m.VerticalRate)
+	}
+
+	if _,err := AllCallFrame("XYZ"); err == nil {
+		t.Errorf("bad IcaoId was accepted")
+	}
+	if _,err := ExtendedSquitterFrame("nope", make([]byte, 7)); err == nil {
+		t.Errorf("bad IcaoId was accepted")
+	}
+}
+
+func TestAirbornePositionME(t *testing.T) {
+	positions := []geo.Latlong{
+		{Lat:37.6188, Long:-122.3754},
+		{Lat:-33.9399, Long:151.1753},
+		{Lat:64.1300, Long:-21.9406},
+		{Lat:0.5, Long:179.9},
+	}
+
+	for i,pos := range positions {
+		tm := time.Now()
+		d := adsb.NewCPRDecoder()
+		for j,odd := range []bool{false, true} {
+			frame,err := ExtendedSquitterFrame("A81BD0", AirbornePositionME(pos, 12350, odd))
+			if err != nil { t.Fatal(err) }
+			m := adsb.Msg{}
+			if err := m.FromModeS(frame, tm.Add(time.Duration(j) * time.Second)); err != nil {
+				t.Fatalf("[%d] %v", i, err)
+			}
+			if m.Altitude != 12350 { t.Errorf("[%d] altitude %d", i, m.Altitude) }
+			if decoded := d.Decode(&m); decoded != odd {
+				t.Errorf("[%d] decoded=%v after odd=%v", i, decoded, odd)
+			} else if decoded && m.Position.DistKM(pos) > 0.01 {
+				t.Errorf("[%d] decoded %s, expected %s", i, m.Position, pos)
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package simulator
+
+import (
+	"math"
+	"time"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/geo"
+)
+
+// {{{ Waypoint{}, Aircraft{}
+
+// Waypoint is a point on an aircraft's route. Waypoints at an altitude of zero are on the
+// ground.
+type Waypoint struct {
+	Position   geo.Latlong
+	AltitudeFt int64
+	SpeedKnots float64 // Ground speed for the leg that starts here
+}
+
+// Aircraft flies its route in straight lines, at constant speed (and constant rate of climb
+// or descent) along each leg. It appears at the first waypoint at Start, and vanishes when
+// it reaches the last one.
+type Aircraft struct {
+	Icao24   adsb.IcaoId
+	Callsign string
+	Squawk   string
+	Start    time.Time
+	Route    []Waypoint
+
+	NoADSB   bool // Only sends Mode S replies (MSG,5/6/7); no callsign, position or velocity
+}
+
+// State is where an aircraft is, and what it is doing, at some instant.
+type State struct {
+	Position     geo.Latlong
+	AltitudeFt   int64
+	GroundSpeed  int64 // Knots
+	Track        int64 // Degrees
+	VerticalRate int64 // Feet per minute
+	OnGround     bool
+}
+
+// }}}
+
+// {{{ legDuration
+
+func legDuration(from, to Waypoint) time.Duration {
+	if from.SpeedKnots <= 0 { return 0 }
+	return time.Duration(from.Position.DistNM(to.Position) / from.SpeedKnots * float64(time.Hour))
+}
+
+// }}}
+// {{{ Aircraft.End
+
+// End is when the aircraft reaches the end of its route.
+func (a Aircraft)End() time.Time {
+	t := a.Start
+	for i:=1; i<len(a.Route); i++ {
+		t = t.Add(legDuration(a.Route[i-1], a.Route[i]))
+	}
+	return t
+}
+
+// }}}
+// {{{ Aircraft.StateAt
+
+// StateAt returns the aircraft's state at the time, or false if it isn't flying its route
+// at that time.
+func (a Aircraft)StateAt(t time.Time) (State, bool) {
+	if len(a.Route) < 2 || t.Before(a.Start) {
+		return State{}, false
+	}
+
+	legStart := a.Start
+	for i:=1; i<len(a.Route); i++ {
+		from, to := a.Route[i-1], a.Route[i]
+		dur := legDuration(from, to)
+		if t.After(legStart.Add(dur)) {
+			legStart = legStart.Add(dur)
+			continue
+		}
+
+		ratio := 0.0
+		if dur > 0 { ratio = float64(t.Sub(legStart)) / float64(dur) }
+		trk := from.Position.BearingTowards(to.Position)
+		pos := from.Position.MoveKM(trk, geo.NM2KM(from.SpeedKnots * t.Sub(legStart).Hours()))
+		alt := from.AltitudeFt + int64(math.Round(float64(to.AltitudeFt - from.AltitudeFt) * ratio))
+
+		s := State{
+			Position:    pos,
+			AltitudeFt:  alt,
+			GroundSpeed: int64(math.Round(from.SpeedKnots)),
+			Track:       int64(math.Round(trk)) % 360,
+			OnGround:    from.AltitudeFt == 0 && to.AltitudeFt == 0,
+		}
+		if dur > 0 {
+			s.VerticalRate = int64(math.Round(float64(to.AltitudeFt - from.AltitudeFt) / dur.Minutes()))
+		}
+		return s, true
+	}
+
+	return State{}, false
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,338 @@
+/* Package simulator generates synthetic ADS-B traffic, for tests and load testing.
+
+Aircraft fly along routes of waypoints; the simulator works out what
+they would transmit, and what a set of virtual receivers would report.
+The output is a stream of SBS-1 messages, with the subtypes interleaved
+at roughly the rates that dump1090 produces them; each message also
+has the raw Mode S frame it would have come from, in AVR or Beast
+format. Receivers can be imperfect: they can drop messages, report
+some twice, go quiet for a while, and have clocks that are off.
+Position and altitude noise can be added to the aircraft's reports.
+The frame builders (ExtendedSquitterFrame et al) can also be used on
+their own, e.g. to test decoders.
+
+Everything is driven by a seeded random number generator, so the same
+simulator generates the same traffic every time.
+
+Sample usage:
+
+    sim := simulator.NewSimulator(42)
+    sim.Aircraft = []simulator.Aircraft{{
+      Icao24: "A81BD0", Callsign: "VRD961", Squawk: "1200", Start: t,
+      Route: []simulator.Waypoint{
+        {Position:sfo, AltitudeFt:0, SpeedKnots:160},
+        {Position:sjc, AltitudeFt:10000, SpeedKnots:300},
+        {Position:lax, AltitudeFt:0},
+      },
+    }}
+    for _,r := range sim.Generate(t, t.Add(time.Hour)) {
+      fmt.Println(r.SBS)
+    }
+
+*/
+package simulator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skypies/adsb"
+)
+
+// {{{ Receiver{}, Report{}
+
+// Receiver is a virtual receiver; it hears every aircraft, subject to its flaws.
+type Receiver struct {
+	Name          string
+	ClockSkew     time.Duration // Added to the time of everything it reports
+	DropRate      float64       // Fraction of messages it misses (0-1)
+	DuplicateRate float64       // Fraction of messages it reports twice
+	Outages       []Outage      // Periods when it hears nothing
+}
+
+type Outage struct {
+	Start, End time.Time
+}
+
+// Report is a message, as reported by a receiver.
+type Report struct {
+	Receiver string
+	Time     time.Time // When it was heard, by the receiver's clock
+	SBS      string    // As dump1090 would output it
+	Frame    []byte    // The raw Mode S frame; nil if we can't generate one (e.g. surface positions)
+}
+
+// Msg parses the SBS-1 form of the report.
+func (r Report)Msg() (adsb.Msg, error) {
+	m := adsb.Msg{}
+	err := m.FromSBS1(r.SBS)
+	return m, err
+}
+
+// AVR is the frame in AVR format; empty if there is no frame.
+func (r Report)AVR() string {
+	if r.Frame == nil { return "" }
+	return adsb.FormatAVR(r.Frame)
+}
+
+// Beast is the frame in Beast format, timestamped in 12MHz ticks since the Unix epoch (by
+// the receiver's clock, and wrapped to 48 bits); nil if there is no frame.
+func (r Report)Beast() []byte {
+	if r.Frame == nil { return nil }
+	ticks := uint64(r.Time.Unix()) * 12000000 + uint64(r.Time.Nanosecond()) * 12 / 1000
+	f,err := adsb.BeastFrameFromModeS(r.Frame, ticks)
+	if err != nil { return nil }
+	return f.Bytes()
+}
+
+// }}}
+// {{{ Simulator{}
+
+// DefaultIntervals are how often each SBS-1 subtype is sent, roughly as seen from dump1090.
+// Subtype 2 (surface position) is only sent on the ground, and 3 (airborne position) only
+// in the air.
+var DefaultIntervals = map[int64]time.Duration{
+	1: time.Second * 5,        // Identification (callsign)
+	2: time.Second,            // Surface position
+	3: time.Millisecond * 500, // Airborne position
+	4: time.Millisecond * 500, // Airborne velocity
+	5: time.Second,            // Surveillance altitude
+	6: time.Second * 5,        // Surveillance identity (squawk)
+	7: time.Second,            // Air-to-air (altitude)
+}
+
+type Simulator struct {
+	Aircraft        []Aircraft
+	Receivers       []Receiver              // If empty, a single perfect receiver is used
+	Intervals       map[int64]time.Duration // How often each subtype is sent; 0==never
+	Jitter          float64                 // Each interval varies randomly by up to this fraction
+	PositionNoiseM  float64                 // Std deviation of noise added to reported positions
+	AltitudeNoiseFt float64                 // Std deviation of noise added to reported altitudes
+
+	rand            *rand.Rand
+	loc             *time.Location          // adsb.TimeLocation, as of NewSimulator; SBS times are in it
+}
+
+// timeLocation loads adsb.TimeLocation. If it isn't valid, the parser would panic on our
+// output anyway (see adsb.Msg.FromSBS1); formatting in UTC instead would shift every time.
+func timeLocation() *time.Location {
+	loc,err := time.LoadLocation(adsb.TimeLocation)
+	if err != nil {
+		panic(fmt.Sprintf("simulator: bad adsb.TimeLocation: %v", err))
+	}
+	return loc
+}
+
+func NewSimulator(seed int64) *Simulator {
+	intervals := map[int64]time.Duration{}
+	for k,v := range DefaultIntervals { intervals[k] = v }
+
+	return &Simulator{
+		Receivers: []Receiver{},
+		Intervals: intervals,
+		Jitter:    0.1,
+		rand:      rand.New(rand.NewSource(seed)),
+		loc:       timeLocation(),
+	}
+}
+
+// }}}
+
+// {{{ event
+
+// event is a transmission from an aircraft.
+type event struct {
+	t       time.Time
+	ac      int   // Index into Simulator.Aircraft
+	subType int64
+	odd     bool  // For airborne positions, whether this is an odd CPR frame
+	s       State // With any noise already added
+}
+
+// }}}
+// {{{ Simulator.schedule
+
+// schedule works out when each aircraft sends each kind of message.
+func (sim *Simulator)schedule(start, end time.Time) []event {
+	subTypes := []int64{}
+	for st,_ := range sim.Intervals { subTypes = append(subTypes, st) }
+	sort.Slice(subTypes, func(i,j int) bool { return subTypes[i] < subTypes[j] })
+
+	events := []event{}
+	for i,a := range sim.Aircraft {
+		first, last := a.Start, a.End()
+		if first.Before(start) { first = start }
+		if last.After(end) { last = end }
+
+		for _,st := range subTypes {
+			interval := sim.Intervals[st]
+			if interval <= 0 { continue }
+			if a.NoADSB && st != 5 && st != 6 && st != 7 { continue }
+
+			odd := false
+			t := first.Add(time.Duration(sim.rand.Float64() * float64(interval))) // Random phase
+			for ; t.Before(last); t = t.Add(sim.interval(interval)) {
+				s,_ := a.StateAt(t)
+				if (st == 2 && !s.OnGround) || (st == 3 && s.OnGround) { continue }
+				events = append(events, event{t:t, ac:i, subType:st, odd:odd, s:sim.addNoise(s)})
+				if st == 3 { odd = !odd }
+			}
+		}
+	}
+
+	sort.SliceStable(events, func(i,j int) bool { return events[i].t.Before(events[j].t) })
+	return events
+}
+
+func (sim *Simulator)interval(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * (1 + sim.Jitter * (2 * sim.rand.Float64() - 1)))
+}
+
+func (sim *Simulator)addNoise(s State) State {
+	if sim.PositionNoiseM > 0 {
+		dist := math.Abs(sim.rand.NormFloat64()) * sim.PositionNoiseM / 1000
+		s.Position = s.Position.MoveKM(sim.rand.Float64() * 360, dist)
+	}
+	if sim.AltitudeNoiseFt > 0 {
+		s.AltitudeFt += int64(math.Round(sim.rand.NormFloat64() * sim.AltitudeNoiseFt))
+	}
+	s.AltitudeFt = (s.AltitudeFt + 12) / 25 * 25 // As sent, in 25ft increments
+	s.VerticalRate = int64(math.Round(float64(s.VerticalRate) / 64)) * 64 // .. and 64ft/min
+	return s
+}
+
+// }}}
+// {{{ Simulator.Generate
+
+// Generate simulates the time period, and returns everything the receivers report, in order
+// of the (true) time the messages were sent.
+func (sim *Simulator)Generate(start, end time.Time) []Report {
+	if sim.rand == nil { sim.rand = rand.New(rand.NewSource(0)) }
+	if sim.loc == nil { sim.loc = timeLocation() }
+	receivers := sim.Receivers
+	if len(receivers) == 0 { receivers = []Receiver{{Name:"sim"}} }
+
+	reports := []Report{}
+	for _,e := range sim.schedule(start, end) {
+		a := sim.Aircraft[e.ac]
+		frame := frame(a, e)
+
+		for _,r := range receivers {
+			if r.isOut(e.t) || sim.rand.Float64() < r.DropRate {
+				continue
+			}
+			t := e.t.Add(r.ClockSkew)
+			report := Report{Receiver:r.Name, Time:t, SBS:sbs(a, e, t.In(sim.loc)), Frame:frame}
+			reports = append(reports, report)
+			if sim.rand.Float64() < r.DuplicateRate {
+				reports = append(reports, report)
+			}
+		}
+	}
+	return reports
+}
+
+func (r Receiver)isOut(t time.Time) bool {
+	for _,o := range r.Outages {
+		if !t.Before(o.Start) && t.Before(o.End) { return true }
+	}
+	return false
+}
+
+// }}}
+
+// {{{ sbs
+
+var emergencySquawks = map[string]bool{"7500":true, "7600":true, "7700":true}
+
+func sbsFlag(b bool) string {
+	if b { return "-1" }
+	return "0"
+}
+
+// sbs formats the event as dump1090 would; each subtype only has some of the fields. The
+// time should already be in adsb.TimeLocation.
+func sbs(a Aircraft, e event, t time.Time) string {
+	r := make([]string, 22)
+	r[adsb.SBS1Message]      = "MSG"
+	r[adsb.SBS1Transmission] = fmt.Sprintf("%d", e.subType)
+	r[adsb.SBS1Session]      = "1"
+	r[adsb.SBS1AircraftID]   = "1"
+	r[adsb.SBS1Icao24]       = string(a.Icao24)
+	r[adsb.SBS1FlightID]     = "1"
+	r[adsb.SBS1DateGen]      = t.Format("2006/01/02")
+	r[adsb.SBS1TimeGen]      = t.Format("15:04:05.000")
+	r[adsb.SBS1DateLog]      = r[adsb.SBS1DateGen]
+	r[adsb.SBS1TimeLog]      = r[adsb.SBS1TimeGen]
+	r[adsb.SBS1IsOnGround]   = sbsFlag(e.s.OnGround)
+
+	alt := fmt.Sprintf("%d", e.s.AltitudeFt)
+	switch e.subType {
+	case 1:
+		r[adsb.SBS1Callsign] = fmt.Sprintf("%-8.8s", a.Callsign)
+	case 2:
+		r[adsb.SBS1Altitude] = alt
+		r[adsb.SBS1GroundSpeed] = fmt.Sprintf("%d", e.s.GroundSpeed)
+		r[adsb.SBS1Track] = fmt.Sprintf("%d", e.s.Track)
+		r[adsb.SBS1Latitude] = fmt.Sprintf("%.5f", e.s.Position.Lat)
+		r[adsb.SBS1Longitude] = fmt.Sprintf("%.5f", e.s.Position.Long)
+	case 3:
+		r[adsb.SBS1Altitude] = alt
+		r[adsb.SBS1Latitude] = fmt.Sprintf("%.5f", e.s.Position.Lat)
+		r[adsb.SBS1Longitude] = fmt.Sprintf("%.5f", e.s.Position.Long)
+	case 4:
+		r[adsb.SBS1GroundSpeed] = fmt.Sprintf("%d", e.s.GroundSpeed)
+		r[adsb.SBS1Track] = fmt.Sprintf("%d", e.s.Track)
+		r[adsb.SBS1VerticalRate] = fmt.Sprintf("%d", e.s.VerticalRate)
+	case 5:
+		r[adsb.SBS1Altitude] = alt
+		r[adsb.SBS1AlertSquawkChange] = "0"
+		r[adsb.SBS1SPI] = "0"
+	case 6:
+		r[adsb.SBS1Squawk] = a.Squawk
+		r[adsb.SBS1AlertSquawkChange] = "0"
+		r[adsb.SBS1Emergency] = sbsFlag(emergencySquawks[a.Squawk])
+		r[adsb.SBS1SPI] = "0"
+	case 7:
+		r[adsb.SBS1Altitude] = alt
+	}
+
+	return strings.Join(r, ",")
+}
+
+// }}}
+// {{{ frame
+
+// frame builds the Mode S frame the aircraft sent. Air-to-air replies (MSG,7) are sent as
+// surveillance replies, as we don't generate the DF0/DF16 replies they really come from.
+func frame(a Aircraft, e event) []byte {
+	var f []byte
+	var err error
+	s := e.s
+
+	switch e.subType {
+	case 1:    f,err = ExtendedSquitterFrame(a.Icao24, IdentificationME(a.Callsign))
+	case 3:    f,err = ExtendedSquitterFrame(a.Icao24, AirbornePositionME(s.Position, s.AltitudeFt, e.odd))
+	case 4:    f,err = ExtendedSquitterFrame(a.Icao24, AirborneVelocityME(s.GroundSpeed, s.Track, s.VerticalRate))
+	case 5, 7: f,err = SurveillanceAltitudeFrame(a.Icao24, s.AltitudeFt)
+	case 6:    f,err = SurveillanceIdentityFrame(a.Icao24, a.Squawk)
+	}
+	if err != nil {
+		return nil
+	}
+	return f
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,249 @@
+package simulator
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/geo"
+)
+
+var (
+	t0  = time.Date(2016, 3, 10, 18, 0, 0, 0, time.UTC)
+	sfo = geo.Latlong{Lat:37.6188, Long:-122.3754}
+	sjc = geo.Latlong{Lat:37.3639, Long:-121.9289}
+	oak = geo.Latlong{Lat:37.7214, Long:-122.2208}
+)
+
+// A flight from SFO to SJC, taking off and landing
+func testAircraft() Aircraft {
+	return Aircraft{
+		Icao24:   "A81BD0",
+		Callsign: "VRD961",
+		Squawk:   "1200",
+		Start:    t0,
+		Route:    []Waypoint{
+			{Position:sfo, AltitudeFt:0, SpeedKnots:150},
+			{Position:sfo.MoveKM(135, 5), AltitudeFt:0, SpeedKnots:240},
+			{Position:sjc.MoveKM(315, 10), AltitudeFt:6000, SpeedKnots:180},
+			{Position:sjc, AltitudeFt:0},
+		},
+	}
+}
+
+func TestStateAt(t *testing.T) {
+	a := testAircraft()
+
+	if _,ok := a.StateAt(t0.Add(-time.Second)); ok { t.Errorf("state before start") }
+	if _,ok := a.StateAt(a.End().Add(time.Second)); ok { t.Errorf("state after end") }
+
+	s,ok := a.StateAt(t0.Add(time.Minute))
+	if !ok || !s.OnGround || s.AltitudeFt != 0 || s.GroundSpeed != 150 || s.Track != 135 {
+		t.Errorf("bad state on the ground: %+v", s)
+	}
+	if d := s.Position.DistNM(sfo); math.Abs(d - 2.5) > 0.01 {
+		t.Errorf("after a minute at 150 knots, %.2fNM from start", d)
+	}
+
+	// Halfway along the climbing leg
+	leg := legDuration(a.Route[1], a.Route[2])
+	s,_ = a.StateAt(t0.Add(legDuration(a.Route[0], a.Route[1]) + leg/2))
+	if s.OnGround || s.AltitudeFt != 3000 || s.GroundSpeed != 240 || s.VerticalRate <= 0 {
+		t.Errorf("bad state climbing: %+v", s)
+	}
+	if expected := int64(math.Round(6000 / leg.Minutes())); s.VerticalRate != expected {
+		t.Errorf("vertical rate %d, expected %d", s.VerticalRate, expected)
+	}
+
+	if s,ok := a.StateAt(a.End()); !ok || s.Position.DistKM(sjc) > 0.01 {
+		t.Errorf("did not arrive: %s", s.Position)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	gen := func() []Report {
+		sim := NewSimulator(42)
+		sim.Aircraft = []Aircraft{testAircraft()}
+		return sim.Generate(t0, t0.Add(time.Minute * 30))
+	}
+	reports := gen()
+
+	counts := map[int64]int{}
+	var prev time.Time
+	for i,r := range reports {
+		m,err := r.Msg()
+		if err != nil { t.Fatalf("[%d] %s: %v", i, r.SBS, err) }
+		if m.Icao24 != "A81BD0" || !m.GeneratedTimestampUTC.Equal(r.Time.Truncate(time.Millisecond)) {
+			t.Errorf("[%d] bad msg: %s", i, r.SBS)
+		}
+		if r.Time.Before(prev) { t.Errorf("[%d] out of order", i) }
+		prev = r.Time
+		counts[m.SubType]++
+
+		switch m.SubType {
+		case 1: if m.Callsign != "VRD961" { t.Errorf("[%d] bad callsign %q", i, m.Callsign) }
+		case 2: if !m.HasPosition() || !m.IsOnGround || m.Altitude != 0 { t.Errorf("[%d] bad surface pos", i) }
+		case 3: if !m.HasPosition() || m.IsOnGround || m.Altitude < 0 { t.Errorf("[%d] bad airborne pos", i) }
+		case 4: if !m.HasGroundSpeed() || !m.HasVerticalRate() || m.HasPosition() { t.Errorf("[%d] bad velocity", i) }
+		case 6: if m.Squawk != "1200" || m.Emergency { t.Errorf("[%d] bad squawk", i) }
+		}
+	}
+
+	// The rates are roughly as configured
+	secs := testAircraft().End().Sub(t0).Seconds()
+	for st,interval := range DefaultIntervals {
+		if st == 2 || st == 3 { continue } // Depend on time spent on the ground
+		if expected := secs / interval.Seconds(); math.Abs(float64(counts[st]) - expected) > expected * 0.05 + 1 {
+			t.Errorf("subtype %d: %d msgs, expected ~%.0f", st, counts[st], expected)
+		}
+	}
+	if counts[2] == 0 || counts[3] == 0 { t.Errorf("missing positions: %v", counts) }
+
+	// Deterministic
+	again := gen()
+	if len(again) != len(reports) {
+		t.Fatalf("second run generated %d reports, not %d", len(again), len(reports))
+	}
+	for i := range reports {
+		if reports[i].SBS != again[i].SBS { t.Errorf("[%d] runs differ", i); break }
+	}
+}
+
+// SBS-1 times are local to adsb.TimeLocation, as dump1090 would write them
+func TestTimeLocation(t *testing.T) {
+	defer func(orig string) { adsb.TimeLocation = orig }(adsb.TimeLocation)
+	adsb.TimeLocation = "America/Los_Angeles"
+
+	sim := NewSimulator(1)
+	sim.Aircraft = []Aircraft{testAircraft()}
+	reports := sim.Generate(t0, t0.Add(time.Second * 10))
+	if len(reports) == 0 { t.Fatalf("no reports") }
+	for i,r := range reports {
+		if m,err := r.Msg(); err != nil || !m.GeneratedTimestampUTC.Equal(r.Time.Truncate(time.Millisecond)) {
+			t.Errorf("[%d] %s parsed as %s, expected %s", i, r.SBS, m.GeneratedTimestampUTC, r.Time)
+		}
+	}
+
+	adsb.TimeLocation = "Nowhere/Special"
+	defer func() {
+		if recover() == nil { t.Errorf("bad TimeLocation did not panic") }
+	}()
+	NewSimulator(1)
+}
+
+func TestFrames(t *testing.T) {
+	sim := NewSimulator(1)
+	sim.Aircraft = []Aircraft{testAircraft()}
+	d := adsb.NewCPRDecoder()
+
+	nPos := 0
+	for i,r := range sim.Generate(t0, t0.Add(time.Minute * 10)) {
+		sbsMsg,_ := r.Msg()
+		if sbsMsg.SubType == 2 {
+			if r.Frame != nil { t.Errorf("[%d] surface position has a frame", i) }
+			continue
+		}
+
+		m := adsb.Msg{}
+		if err := m.FromAVR(r.AVR(), r.Time); err != nil {
+			t.Fatalf("[%d] %s: %v", i, r.AVR(), err)
+		}
+		if m.Icao24 != sbsMsg.Icao24 || m.Altitude != sbsMsg.Altitude || m.Callsign != sbsMsg.Callsign ||
+			m.Squawk != sbsMsg.Squawk || m.VerticalRate != sbsMsg.VerticalRate {
+			t.Errorf("[%d] AVR & SBS differ:\n%s\n%s", i, m.ToSBS1(), sbsMsg.ToSBS1())
+		}
+		if m.SubType == 4 && (math.Abs(float64(m.GroundSpeed - sbsMsg.GroundSpeed)) > 1 ||
+			math.Abs(float64(m.Track - sbsMsg.Track)) > 1) {
+			t.Errorf("[%d] velocity differs: %d/%d vs %d/%d", i, m.GroundSpeed, m.Track, sbsMsg.GroundSpeed, sbsMsg.Track)
+		}
+		if d.Decode(&m) {
+			nPos++
+			if m.Position.DistKM(sbsMsg.Position) > 0.01 {
+				t.Errorf("[%d] position differs: %s vs %s", i, m.Position, sbsMsg.Position)
+			}
+		}
+
+		br := adsb.NewBeastReader(bytes.NewReader(r.Beast()))
+		if f,err := br.Read(); err != nil || !bytes.Equal(f.Data, r.Frame) {
+			t.Errorf("[%d] bad Beast frame: %v", i, err)
+		}
+	}
+	if nPos == 0 { t.Errorf("no positions decoded from the frames") }
+}
+
+func TestReceivers(t *testing.T) {
+	sim := NewSimulator(7)
+	sim.Aircraft = []Aircraft{testAircraft()}
+	sim.Receivers = []Receiver{
+		{Name:"perfect"},
+		{Name:"lossy", DropRate:0.5},
+		{Name:"stutter", DuplicateRate:0.2},
+		{Name:"skewed", ClockSkew:time.Second * 2},
+		{Name:"flaky", Outages:[]Outage{{t0.Add(time.Minute), t0.Add(time.Minute * 2)}}},
+	}
+	start, end := t0, t0.Add(time.Minute * 5)
+
+	counts := map[string]int{}
+	for _,r := range sim.Generate(start, end) {
+		counts[r.Receiver]++
+		if r.Receiver == "flaky" && !r.Time.Before(t0.Add(time.Minute)) && r.Time.Before(t0.Add(time.Minute * 2)) {
+			t.Errorf("flaky receiver reported during its outage")
+		}
+		if r.Receiver == "skewed" && r.Time.Before(start.Add(time.Second * 2)) {
+			t.Errorf("skewed receiver not skewed: %s", r.Time)
+		}
+	}
+
+	n := float64(counts["perfect"])
+	if counts["skewed"] != counts["perfect"] {
+		t.Errorf("skewed receiver lost messages: %v", counts)
+	}
+	if f := float64(counts["lossy"]) / n; f < 0.45 || f > 0.55 {
+		t.Errorf("lossy receiver heard %.2f of msgs", f)
+	}
+	if f := float64(counts["stutter"]) / n; f < 1.15 || f > 1.25 {
+		t.Errorf("stuttering receiver reported %.2f of msgs", f)
+	}
+	if f := float64(counts["flaky"]) / n; f < 0.75 || f > 0.85 {
+		t.Errorf("flaky receiver heard %.2f of msgs", f)
+	}
+}
+
+func TestNoise(t *testing.T) {
+	sim := NewSimulator(3)
+	sim.Aircraft = []Aircraft{testAircraft()}
+	sim.PositionNoiseM = 100
+	sim.AltitudeNoiseFt = 50
+
+	for i,r := range sim.Generate(t0, t0.Add(time.Minute * 10)) {
+		m,_ := r.Msg()
+		if !m.HasPosition() { continue }
+		s,_ := sim.Aircraft[0].StateAt(m.GeneratedTimestampUTC)
+		if d := m.Position.DistKM(s.Position); d > 0.6 {
+			t.Errorf("[%d] position %.0fm out", i, d * 1000)
+		}
+		if m.Altitude % 25 != 0 || math.Abs(float64(m.Altitude - s.AltitudeFt)) > 300 {
+			t.Errorf("[%d] altitude %d, expected ~%d", i, m.Altitude, s.AltitudeFt)
+		}
+	}
+}
+
+func TestNoADSB(t *testing.T) {
+	a := testAircraft()
+	a.NoADSB = true
+	a.Squawk = "7700"
+	sim := NewSimulator(5)
+	sim.Aircraft = []Aircraft{a}
+
+	for _,r := range sim.Generate(t0, t0.Add(time.Minute * 5)) {
+		m,_ := r.Msg()
+		if m.SubType != 5 && m.SubType != 6 && m.SubType != 7 {
+			t.Errorf("Mode S only aircraft sent MSG,%d", m.SubType)
+		}
+		if m.SubType == 6 && !m.Emergency {
+			t.Errorf("emergency squawk not flagged")
+		}
+	}
+}
@@ -0,0 +1,323 @@
+/* Package spatialindex keeps the latest position of each aircraft, indexed so
+that it can quickly answer questions like "what's within 20NM of here ?".
+
+Positions are bucketed into a grid of lat/long cells. Aircraft that
+send no positions for a while are aged out. It is safe for concurrent
+use, so queries can be served while the index is being updated.
+
+Sample usage:
+
+    idx := spatialindex.NewIndex()
+    for _,cm := range msgs {
+      idx.Update(cm)
+    }
+    nearby := idx.WithinRadius(myHouse, geo.NM2KM(20))
+
+*/
+package spatialindex
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/geo"
+)
+
+const kmPerDegreeLat = 111.2
+
+// {{{ Result{}
+
+type Result struct {
+	Msg    *adsb.CompositeMsg
+	DistKM float64
+}
+
+type byDist []Result
+func (a byDist) Len() int           { return len(a) }
+func (a byDist) Swap(i,j int)       { a[i],a[j] = a[j],a[i] }
+func (a byDist) Less(i,j int) bool  { return a[i].DistKM < a[j].DistKM }
+
+// }}}
+// {{{ Index{}
+
+type cellKey [2]int
+
+type entry struct {
+	msg      *adsb.CompositeMsg
+	cell     cellKey
+	lastSeen time.Time
+}
+
+type Index struct {
+	MaxQuietTime time.Duration // Remove aircraft that haven't sent a position for this long
+
+	cellDeg      float64
+	nLong        int     // Number of cells around the globe (the last one may be narrower)
+	cells        map[cellKey]map[adsb.IcaoId]*entry
+	entries      map[adsb.IcaoId]*entry
+	lastAgeOut   time.Time
+	mu           sync.RWMutex
+}
+
+// NewIndex returns an index with cells of 10x10 arcminutes (roughly 10NM square, at the
+// equator).
+func NewIndex() *Index {
+	return NewIndexWithCellSize(1.0 / 6)
+}
+
+// NewIndexWithCellSize returns an index with cells of the given size (in degrees). Cells
+// about the size of the typical query radius work best.
+func NewIndexWithCellSize(cellDeg float64) *Index {
+	return &Index{
+		MaxQuietTime: time.Second * 360,
+		cellDeg:      cellDeg,
+		nLong:        int(math.Ceil(360 / cellDeg)),
+		cells:        map[cellKey]map[adsb.IcaoId]*entry{},
+		entries:      map[adsb.IcaoId]*entry{},
+	}
+}
+
+// }}}
+
+// {{{ Index.key
+
+// Longitude cells are counted eastwards from the antimeridian, and wrap around; so a cell key
+// built from a longitude outside +/-180 (e.g. the edge of a search box) is still correct.
+func (idx *Index)key(pos geo.Latlong) cellKey {
+	return cellKey{int(math.Floor(pos.Lat / idx.cellDeg)), idx.wrap(idx.longIndex(pos.Long))}
+}
+
+// longIndex is the unwrapped index of the longitude's cell.
+func (idx *Index)longIndex(long float64) int {
+	return int(math.Floor((long + 180) / idx.cellDeg))
+}
+
+func (idx *Index)wrap(j int) int {
+	j %= idx.nLong
+	if j < 0 { j += idx.nLong }
+	return j
+}
+
+// }}}
+// {{{ Index.remove
+
+// remove assumes the lock is held.
+func (idx *Index)remove(id adsb.IcaoId) {
+	if e,exists := idx.entries[id]; exists {
+		delete(idx.cells[e.cell], id)
+		if len(idx.cells[e.cell]) == 0 { delete(idx.cells, e.cell) }
+		delete(idx.entries, id)
+	}
+}
+
+// }}}
+// {{{ Index.ageOut
+
+// ageOut assumes the lock is held.
+func (idx *Index)ageOut() (removed int64) {
+	if time.Since(idx.lastAgeOut) < time.Second { return } // Only run once per second.
+	idx.lastAgeOut = time.Now()
+
+	for id,e := range idx.entries {
+		if time.Since(e.lastSeen) >= idx.MaxQuietTime {
+			idx.remove(id)
+			removed++
+		}
+	}
+	return
+}
+
+// }}}
+
+// {{{ Index.Update
+
+// Update records the message as the aircraft's latest position. Messages without positions
+// are ignored.
+func (idx *Index)Update(cm *adsb.CompositeMsg) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.ageOut()
+	if !cm.HasPosition() { return }
+
+	k := idx.key(cm.Position)
+	e,exists := idx.entries[cm.Icao24]
+	if exists && e.cell != k {
+		idx.remove(cm.Icao24)
+		exists = false
+	}
+	if !exists {
+		e = &entry{cell:k}
+		idx.entries[cm.Icao24] = e
+		if idx.cells[k] == nil { idx.cells[k] = map[adsb.IcaoId]*entry{} }
+		idx.cells[k][cm.Icao24] = e
+	}
+	e.msg = cm
+	e.lastSeen = time.Now()
+}
+
+// }}}
+// {{{ Index.Remove
+
+func (idx *Index)Remove(id adsb.IcaoId) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(id)
+}
+
+// }}}
+// {{{ Index.Get
+
+// Get returns the aircraft's latest position.
+func (idx *Index)Get(id adsb.IcaoId) (*adsb.CompositeMsg, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	if e,exists := idx.entries[id]; exists {
+		return e.msg, true
+	}
+	return nil, false
+}
+
+// }}}
+// {{{ Index.Len
+
+func (idx *Index)Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.entries)
+}
+
+// }}}
+
+// {{{ Index.cellsInBox
+
+// cellsInBox calls f for each entry in the cells overlapping the box. Assumes the lock is held.
+// The box may extend past the antimeridian (e.g. to a longitude of 185).
+func (idx *Index)cellsInBox(box geo.LatlongBox, f func(*entry)) {
+	west,east := idx.longIndex(box.SW.Long), idx.longIndex(box.NE.Long)
+	if east - west >= idx.nLong { east = west + idx.nLong - 1 } // All the way round
+	for i:=idx.key(box.SW)[0]; i<=idx.key(box.NE)[0]; i++ {
+		for j:=west; j<=east; j++ {
+			for _,e := range idx.cells[cellKey{i,idx.wrap(j)}] {
+				f(e)
+			}
+		}
+	}
+}
+
+// }}}
+// {{{ Index.WithinBox
+
+func (idx *Index)WithinBox(box geo.LatlongBox) []*adsb.CompositeMsg {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	ret := []*adsb.CompositeMsg{}
+	idx.cellsInBox(box, func(e *entry) {
+		if box.Contains(e.msg.Position) {
+			ret = append(ret, e.msg)
+		}
+	})
+	return ret
+}
+
+// }}}
+// {{{ Index.WithinRadius
+
+// WithinRadius returns the aircraft within the radius of the position, nearest first.
+func (idx *Index)WithinRadius(pos geo.Latlong, radiusKM float64) []Result {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	dLat := radiusKM / kmPerDegreeLat
+	dLong := dLat / math.Max(math.Cos(pos.Lat * math.Pi / 180), 0.01)
+	box := geo.LatlongBox{
+		SW: geo.Latlong{Lat:pos.Lat - dLat, Long:pos.Long - dLong},
+		NE: geo.Latlong{Lat:pos.Lat + dLat, Long:pos.Long + dLong},
+	}
+
+	ret := []Result{}
+	idx.cellsInBox(box, func(e *entry) {
+		if dist := pos.DistKM(e.msg.Position); dist <= radiusKM {
+			ret = append(ret, Result{e.msg, dist})
+		}
+	})
+	sort.Sort(byDist(ret))
+	return ret
+}
+
+// }}}
+// {{{ Index.Nearest
+
+// Nearest returns the k aircraft nearest to the position, nearest first. It searches rings
+// of cells outwards from the position, until no unsearched cell could hold anything closer.
+// If the rings get bigger than the index (e.g. the nearest aircraft are far away), it just
+// looks at everything.
+func (idx *Index)Nearest(pos geo.Latlong, k int) []Result {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if k <= 0 { return []Result{} }
+
+	center := idx.key(pos)
+	found := []Result{}
+	add := func(i, j int) {
+		for _,e := range idx.cells[cellKey{i,idx.wrap(j)}] {
+			found = append(found, Result{e.msg, pos.DistKM(e.msg.Position)})
+		}
+	}
+
+	done := false
+	for r:=0; len(found) < len(idx.entries); r++ {
+		// Everything in ring r is at least (r-1) cells away. Cells get narrower away from the
+		// equator, so we go by the narrowest cell in the ring.
+		maxLat := math.Min(90, math.Max(math.Abs(float64(center[0]-r)), math.Abs(float64(center[0]+r+1))) * idx.cellDeg)
+		cellKM := idx.cellDeg * kmPerDegreeLat * math.Cos(maxLat * math.Pi / 180)
+		if len(found) >= k && found[k-1].DistKM < float64(r-1) * cellKM {
+			done = true
+			break
+		}
+
+		if 8*r > len(idx.entries) || 2*r+1 > idx.nLong {
+			break // Quicker to look at everything
+		}
+
+		if r == 0 {
+			add(center[0], center[1])
+		} else {
+			for j:=center[1]-r; j<=center[1]+r; j++ { // Top & bottom rows
+				add(center[0]-r, j)
+				add(center[0]+r, j)
+			}
+			for i:=center[0]-r+1; i<center[0]+r; i++ { // The sides, minus the corners
+				add(i, center[1]-r)
+				add(i, center[1]+r)
+			}
+		}
+		sort.Sort(byDist(found))
+	}
+
+	if !done && len(found) < len(idx.entries) {
+		found = []Result{}
+		for _,e := range idx.entries {
+			found = append(found, Result{e.msg, pos.DistKM(e.msg.Position)})
+		}
+		sort.Sort(byDist(found))
+	}
+
+	if len(found) > k { found = found[:k] }
+	return found
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
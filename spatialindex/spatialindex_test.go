@@ -0,0 +1,141 @@
+// go test -v github.com/skypies/adsb/spatialindex
+package spatialindex
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/geo"
+)
+
+func makeMsg(id adsb.IcaoId, lat, long float64) *adsb.CompositeMsg {
+	m := adsb.Msg{}
+	sbs := fmt.Sprintf("MSG,3,1,1,%s,1,2015/11/27,21:31:00.000,2015/11/27,21:31:00.000,,5000,,,%f,%f,,,,,,0",
+		id, lat, long)
+	if err := m.FromSBS1(sbs); err != nil {
+		panic(err)
+	}
+	return &adsb.CompositeMsg{Msg:m}
+}
+
+func randomIndex(n int) (*Index, []*adsb.CompositeMsg) {
+	r := rand.New(rand.NewSource(1))
+	idx := NewIndex()
+	msgs := []*adsb.CompositeMsg{}
+	for i:=0; i<n; i++ {
+		cm := makeMsg(adsb.IcaoId(fmt.Sprintf("%06X", i)), 36 + r.Float64()*2, -123 + r.Float64()*2)
+		idx.Update(cm)
+		msgs = append(msgs, cm)
+	}
+	return idx, msgs
+}
+
+func TestQueries(t *testing.T) {
+	idx,msgs := randomIndex(500)
+	pos := geo.Latlong{Lat:37, Long:-122}
+
+	// Compare against brute force
+	all := []Result{}
+	for _,cm := range msgs {
+		all = append(all, Result{cm, pos.DistKM(cm.Position)})
+	}
+	sort.Sort(byDist(all))
+
+	within := idx.WithinRadius(pos, 20)
+	expected := 0
+	for _,r := range all {
+		if r.DistKM <= 20 { expected++ }
+	}
+	if len(within) != expected { t.Errorf("WithinRadius found %d, expected %d", len(within), expected) }
+
+	nearest := idx.Nearest(pos, 10)
+	if len(nearest) != 10 { t.Fatalf("Nearest found %d", len(nearest)) }
+	for i,r := range nearest {
+		if r.Msg != all[i].Msg { t.Errorf("Nearest[%d] was %s, expected %s", i, r.Msg.Icao24, all[i].Msg.Icao24) }
+	}
+
+	box := geo.Latlong{Lat:36.5, Long:-122.5}.BoxTo(geo.Latlong{Lat:36.8, Long:-122.1})
+	inBox := 0
+	for _,cm := range msgs {
+		if box.Contains(cm.Position) { inBox++ }
+	}
+	if n := len(idx.WithinBox(box)); n != inBox { t.Errorf("WithinBox found %d, expected %d", n, inBox) }
+}
+
+// Near the poles cells are narrow, and they wrap around at the antimeridian
+func TestHighLatitudes(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	idx := NewIndex()
+	msgs := []*adsb.CompositeMsg{}
+	for i:=0; i<200; i++ {
+		cm := makeMsg(adsb.IcaoId(fmt.Sprintf("%06X", i)), 75 + r.Float64()*14, -180 + r.Float64()*360)
+		idx.Update(cm)
+		msgs = append(msgs, cm)
+	}
+
+	for _,pos := range []geo.Latlong{{Lat:76, Long:0}, {Lat:80, Long:179.9}, {Lat:88, Long:-90}} {
+		all := []Result{}
+		for _,cm := range msgs {
+			all = append(all, Result{cm, pos.DistKM(cm.Position)})
+		}
+		sort.Sort(byDist(all))
+
+		for i,res := range idx.Nearest(pos, 5) {
+			if res.Msg != all[i].Msg {
+				t.Errorf("%s: Nearest[%d] was %.1fKM away, expected %.1fKM", pos, i, res.DistKM, all[i].DistKM)
+			}
+		}
+	}
+}
+
+func TestAntimeridian(t *testing.T) {
+	idx := NewIndex()
+	idx.Update(makeMsg("A81BD0", -17.0, 179.99))
+	idx.Update(makeMsg("ABEEF0", -17.0, -179.99))
+	for _,pos := range []geo.Latlong{{Lat:-17, Long:179.99}, {Lat:-17, Long:-179.99}} {
+		if n := len(idx.WithinRadius(pos, 50)); n != 2 {
+			t.Errorf("%s: WithinRadius found %d", pos, n)
+		}
+		if res := idx.Nearest(pos, 2); len(res) != 2 || res[1].DistKM > 5 {
+			t.Errorf("%s: bad Nearest: %v", pos, res)
+		}
+	}
+}
+
+// A distant aircraft shouldn't make Nearest search every cell in between
+func TestNearestFarAway(t *testing.T) {
+	idx := NewIndex()
+	sfo := geo.Latlong{Lat:37.6188, Long:-122.3754}
+	idx.Update(makeMsg("A81BD0", 37.5, -122.3))
+	idx.Update(makeMsg("A81BD1", 37.7, -122.4))
+	idx.Update(makeMsg("7C0000", -33.9399, 151.1753)) // Sydney
+
+	start := time.Now()
+	if res := idx.Nearest(sfo, 3); len(res) != 3 || res[2].Msg.Icao24 != "7C0000" {
+		t.Errorf("bad results: %v", res)
+	}
+	if d := time.Since(start); d > time.Millisecond * 100 {
+		t.Errorf("Nearest took %s", d)
+	}
+}
+
+func TestUpdateAndAgeOut(t *testing.T) {
+	idx := NewIndex()
+	idx.Update(makeMsg("A81BD0", 37.0, -122.0))
+	idx.Update(makeMsg("A81BD0", 38.0, -122.0)) // Moves to a new cell
+	if idx.Len() != 1 { t.Errorf("expected 1 entry, found %d", idx.Len()) }
+	if n := len(idx.WithinRadius(geo.Latlong{Lat:37.0, Long:-122.0}, 10)); n != 0 {
+		t.Errorf("old position still indexed")
+	}
+	if cm,_ := idx.Get("A81BD0"); cm.Position.Lat != 38.0 { t.Errorf("Get returned old position") }
+
+	idx.entries["A81BD0"].lastSeen = time.Now().Add(-1 * idx.MaxQuietTime)
+	idx.lastAgeOut = time.Time{}
+	idx.Update(makeMsg("ABEEF0", 37.0, -122.0))
+	if _,exists := idx.Get("A81BD0"); exists { t.Errorf("did not age out") }
+	if len(idx.cells) != 1 { t.Errorf("empty cells left behind") }
+}
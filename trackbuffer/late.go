@@ -0,0 +1,172 @@
+package trackbuffer
+
+import (
+	"sort"
+	"time"
+	"github.com/skypies/adsb"
+)
+
+// LatePolicy says what to do with a message that turns up after the track it belongs to
+// has been flushed.
+type LatePolicy int
+const (
+	LateAmend  LatePolicy = iota // Collect late messages, and flush them as a separate amendment track
+	LateDrop                     // Discard late messages
+	LateReopen                   // Pull the flushed messages (up to LateMemory's worth) back into the
+	                             // buffer; they get flushed again, so consumers should replace them
+)
+
+func (p LatePolicy)String() string {
+	switch p {
+	case LateAmend:  return "amend"
+	case LateDrop:   return "drop"
+	case LateReopen: return "reopen"
+	default:         return "?"
+	}
+}
+
+// flushedTrack remembers enough about a flushed track to recognize late data for it.
+type flushedTrack struct {
+	End       time.Time            // Timestamp of the last message that was flushed
+	FlushedAt time.Time
+	Messages  []*adsb.CompositeMsg // Only retained for LateReopen; just the last LateMemory's worth
+}
+
+// insert adds the message, keeping the track in time order. It returns false if the
+// message had to go anywhere other than the end.
+func (t *Track)insert(m *adsb.CompositeMsg) bool {
+	i := len(t.Messages)
+	for i > 0 && t.Messages[i-1].GeneratedTimestampUTC.After(m.GeneratedTimestampUTC) {
+		i--
+	}
+	t.Messages = append(t.Messages, nil)
+	copy(t.Messages[i+1:], t.Messages[i:])
+	t.Messages[i] = m
+	return i == len(t.Messages)-1
+}
+
+// takeBefore removes the messages older than the cutoff, and returns them as a new track.
+func (t *Track)takeBefore(cutoff time.Time) *Track {
+	i := 0
+	for i < len(t.Messages) && t.Messages[i].GeneratedTimestampUTC.Before(cutoff) {
+		i++
+	}
+	ret := &Track{Messages: t.Messages[:i:i]}
+	t.Messages = t.Messages[i:]
+	return ret
+}
+
+// resegment reapplies the SegmentRules to all the unflushed data for the aircraft (its open
+// track, and any completed flights), after something was inserted out of order. Flights that
+// have ended go (back) to Completed; the last one stays open. The split stats are not
+// updated, as most of these splits will already have been counted.
+func (tb *TrackBuffer)resegment(id adsb.IcaoId) {
+	msgs := []*adsb.CompositeMsg{}
+	others := []*Track{}
+	for _,t := range tb.Completed {
+		if t.Messages[0].Icao24 == id {
+			msgs = append(msgs, t.Messages...)
+		} else {
+			others = append(others, t)
+		}
+	}
+	msgs = append(msgs, tb.Tracks[id].Messages...)
+	sort.SliceStable(msgs, func(i,j int) bool {
+		return msgs[i].GeneratedTimestampUTC.Before(msgs[j].GeneratedTimestampUTC)
+	})
+
+	flights := tb.Segment.Split(msgs)
+	for _,f := range flights[:len(flights)-1] {
+		others = append(others, &Track{Messages: f[:len(f):len(f)]}) // Don't share capacity
+	}
+	tb.Completed = others
+	tb.Tracks[id].Messages = flights[len(flights)-1]
+}
+
+// handleLate looks to see if the message belongs to a track that has already been
+// flushed. If so, it applies the LatePolicy, and returns true if it consumed the message.
+func (tb *TrackBuffer)handleLate(m *adsb.CompositeMsg) bool {
+	f,exists := tb.flushed[m.Icao24]
+	if !exists || m.GeneratedTimestampUTC.After(f.End) {
+		return false
+	}
+
+	switch tb.Late {
+	case LateDrop:
+		tb.stats.LateDropped++
+		return true
+
+	case LateReopen:
+		if _,exists := tb.Tracks[m.Icao24]; exists == false {
+			tb.AddTrack(m.Icao24)
+		}
+		for _,old := range f.Messages {
+			tb.Tracks[m.Icao24].insert(old)
+		}
+		tb.numBuffered += int64(len(f.Messages))
+		delete(tb.flushed, m.Icao24)
+		tb.stats.LateReopened++
+		return false // Let the message get added, like any other
+
+	default:
+		if tb.amendments == nil { tb.amendments = make(map[adsb.IcaoId]*Track) }
+		if _,exists := tb.amendments[m.Icao24]; exists == false {
+			tb.amendments[m.Icao24] = &Track{Messages: []*adsb.CompositeMsg{}}
+		}
+		tb.amendments[m.Icao24].insert(m)
+		tb.numBuffered++
+		tb.stats.LateAmended++
+		return true
+	}
+}
+
+// rememberFlushed notes that the track has been flushed. For LateReopen it keeps the tail of
+// the flushed data, to be reopened; an aircraft that is tracked for hours gets flushed many
+// times, so anything more than LateMemory older than the end of the track is let go.
+func (tb *TrackBuffer)rememberFlushed(t *Track) {
+	if len(t.Messages) == 0 { return }
+	if tb.flushed == nil { tb.flushed = make(map[adsb.IcaoId]*flushedTrack) }
+
+	id := t.Messages[0].Icao24
+	f,exists := tb.flushed[id]
+	if !exists {
+		f = &flushedTrack{}
+		tb.flushed[id] = f
+	}
+
+	if end := t.Messages[len(t.Messages)-1].GeneratedTimestampUTC; end.After(f.End) {
+		f.End = end
+	}
+	f.FlushedAt = time.Now()
+	if tb.Late == LateReopen {
+		f.Messages = append(f.Messages, t.Messages...)
+		i := 0
+		for i < len(f.Messages) && f.End.Sub(f.Messages[i].GeneratedTimestampUTC) > tb.LateMemory {
+			i++
+		}
+		if i > 0 {
+			f.Messages = append([]*adsb.CompositeMsg{}, f.Messages[i:]...) // Release the old array
+		}
+	}
+}
+
+// forgetFlushed stops tracking flushed tracks that are too old to care about.
+func (tb *TrackBuffer)forgetFlushed() {
+	for id,f := range tb.flushed {
+		if time.Since(f.FlushedAt) > tb.LateMemory {
+			delete(tb.flushed, id)
+		}
+	}
+}
+
+func (tb *TrackBuffer)flushAmendments(flushChan chan<- []*adsb.CompositeMsg) {
+	ch := flushChan
+	if tb.AmendChan != nil { ch = tb.AmendChan }
+
+	for id,t := range tb.amendments {
+		ch <- t.Messages
+		tb.stats.AmendmentsFlushed++
+		tb.numBuffered -= int64(len(t.Messages))
+		delete(tb.amendments, id)
+	}
+}
@@ -0,0 +1,118 @@
+package trackbuffer
+
+import (
+	"math"
+	"time"
+
+	"github.com/skypies/adsb"
+)
+
+// positions returns just the messages that have positions.
+func (t *Track)positions() []*adsb.CompositeMsg {
+	ret := []*adsb.CompositeMsg{}
+	for _,m := range t.Messages {
+		if m.HasPosition() {
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
+
+// Resample returns a new track, with a point every step (aligned to multiples of step since
+// the Unix epoch), spanning the original track. Points that coincide with an observed message are
+// copies of it; the rest are interpolated from the observed messages either side, and marked
+// with IsSynthetic. Messages without positions are ignored. The original track must be in
+// time order, and is not modified.
+func (t *Track)Resample(step time.Duration) *Track {
+	ret := &Track{Messages: []*adsb.CompositeMsg{}}
+	in := t.positions()
+	if len(in) == 0 || step <= 0 {
+		return ret
+	}
+
+	first,last := in[0].GeneratedTimestampUTC, in[len(in)-1].GeneratedTimestampUTC
+	// (Truncate would align to Go's zero time, which differs for steps like 7s.)
+	offset := time.Duration(first.UnixNano() % int64(step))
+	if offset < 0 { offset += step }
+	tm := first.Add(-offset)
+	if tm.Before(first) { tm = tm.Add(step) }
+
+	i := 0 // in[i] is the last observation at or before tm
+	for ; !tm.After(last); tm = tm.Add(step) {
+		for i+1 < len(in) && !in[i+1].GeneratedTimestampUTC.After(tm) {
+			i++
+		}
+
+		if in[i].GeneratedTimestampUTC.Equal(tm) {
+			cm := *in[i]
+			cm.IsSynthetic = false
+			ret.Messages = append(ret.Messages, &cm)
+		} else {
+			ret.Messages = append(ret.Messages, adsb.InterpolateComposite(in[i], in[i+1], tm))
+		}
+	}
+
+	return ret
+}
+
+// savitzkyGolay returns the coefficients for a quadratic smoothing filter over 2m+1 points.
+func savitzkyGolay(m int) []float64 {
+	c := make([]float64, 2*m+1)
+	fm := float64(m)
+	norm := (4*fm*fm - 1) * (2*fm + 3)
+	for i:=-m; i<=m; i++ {
+		fi := float64(i)
+		c[i+m] = 3 * (3*fm*fm + 3*fm - 1 - 5*fi*fi) / norm
+	}
+	return c
+}
+
+// Smooth returns a new track, smoothed with a quadratic Savitzky-Golay filter over a window
+// of the given number of points (which should be odd, and at least 5). The filter assumes
+// the points are evenly spaced in time, so it should be run on a resampled track. The first
+// and last few points (half a window's worth) are not smoothed. The observed/synthetic
+// marking is carried over from the original points.
+func (t *Track)Smooth(window int) *Track {
+	ret := &Track{Messages: []*adsb.CompositeMsg{}}
+	in := t.positions()
+	for _,m := range in {
+		cm := *m
+		ret.Messages = append(ret.Messages, &cm)
+	}
+
+	m := window / 2
+	if m < 2 || len(in) < 2*m+1 {
+		return ret
+	}
+	coeffs := savitzkyGolay(m)
+
+	smooth := func(f func(*adsb.CompositeMsg) float64, i int) float64 {
+		sum := 0.0
+		for j,c := range coeffs {
+			sum += c * f(in[i-m+j])
+		}
+		return sum
+	}
+
+	for i:=m; i<len(in)-m; i++ {
+		out := ret.Messages[i]
+		out.Position.Lat  = smooth(func(cm *adsb.CompositeMsg) float64 { return cm.Position.Lat }, i)
+		out.Position.Long = smooth(func(cm *adsb.CompositeMsg) float64 { return cm.Position.Long }, i)
+		out.Altitude      = int64(math.Round(smooth(func(cm *adsb.CompositeMsg) float64 {
+			return float64(cm.Altitude) }, i)))
+		out.GroundSpeed   = int64(math.Round(smooth(func(cm *adsb.CompositeMsg) float64 {
+			return float64(cm.GroundSpeed) }, i)))
+		out.VerticalRate  = int64(math.Round(smooth(func(cm *adsb.CompositeMsg) float64 {
+			return float64(cm.VerticalRate) }, i)))
+
+		// Headings wrap around, so smooth the unit vector instead
+		sin := smooth(func(cm *adsb.CompositeMsg) float64 { return math.Sin(radians(cm.Track)) }, i)
+		cos := smooth(func(cm *adsb.CompositeMsg) float64 { return math.Cos(radians(cm.Track)) }, i)
+		hdg := int64(math.Round(math.Atan2(sin, cos) * 180 / math.Pi))
+		out.Track = (hdg + 360) % 360
+	}
+
+	return ret
+}
+
+func radians(deg int64) float64 { return float64(deg) * math.Pi / 180 }
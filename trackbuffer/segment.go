@@ -0,0 +1,74 @@
+package trackbuffer
+
+import (
+	"time"
+	"github.com/skypies/adsb"
+)
+
+// SegmentRules decide when an aircraft's messages should be split into separate flights, so
+// that each flushed Track covers just one flight.
+type SegmentRules struct {
+	MaxGap             time.Duration // If >0, a gap in the data longer than this starts a new flight
+	OnCallsignChange   bool          // A change of callsign starts a new flight
+	OnGroundTransition bool          // Leaving the ground (i.e. taking off) starts a new flight
+	MinGroundTime      time.Duration // ... but only if it had been reporting on the ground for this long
+}
+
+// Reasons for starting a new flight
+const (
+	SplitNone         = ""
+	SplitGap          = "gap"
+	SplitCallsign     = "callsign"
+	SplitTakeoff      = "takeoff"
+)
+
+func hasRealCallsign(m *adsb.CompositeMsg) bool {
+	return m.Callsign != "" && m.Callsign != adsb.NilCallsign
+}
+
+// groundTime is how long the aircraft had been reporting on the ground, as of the last message.
+func groundTime(msgs []*adsb.CompositeMsg) time.Duration {
+	n := len(msgs)
+	i := n-1
+	for i > 0 && msgs[i-1].IsOnGround {
+		i--
+	}
+	return msgs[n-1].GeneratedTimestampUTC.Sub(msgs[i].GeneratedTimestampUTC)
+}
+
+// IsNewFlight looks at the messages so far for an aircraft's flight (in time order, and not
+// empty), and decides whether the next message starts a new flight. If it does, the reason is
+// returned.
+func (r SegmentRules)IsNewFlight(msgs []*adsb.CompositeMsg, next *adsb.CompositeMsg) (bool, string) {
+	prev := msgs[len(msgs)-1]
+	if r.MaxGap > 0 && next.GeneratedTimestampUTC.Sub(prev.GeneratedTimestampUTC) > r.MaxGap {
+		return true, SplitGap
+	}
+	if r.OnCallsignChange && hasRealCallsign(prev) && hasRealCallsign(next) &&
+		prev.Callsign != next.Callsign {
+		return true, SplitCallsign
+	}
+	// The landing rollout & taxi stay with the arriving flight; the next flight starts when
+	// the aircraft leaves the ground again. A brief blip of the ground flag (e.g. from a
+	// glitchy transponder) isn't a landing, so the ground state has to have lasted a while.
+	if r.OnGroundTransition && prev.IsOnGround && !next.IsOnGround && groundTime(msgs) >= r.MinGroundTime {
+		return true, SplitTakeoff
+	}
+	return false, SplitNone
+}
+
+// Split breaks a time-ordered slice of messages into separate flights.
+func (r SegmentRules)Split(msgs []*adsb.CompositeMsg) [][]*adsb.CompositeMsg {
+	ret := [][]*adsb.CompositeMsg{}
+	start := 0
+	for i:=1; i<len(msgs); i++ {
+		if split,_ := r.IsNewFlight(msgs[start:i], msgs[i]); split {
+			ret = append(ret, msgs[start:i])
+			start = i
+		}
+	}
+	if start < len(msgs) {
+		ret = append(ret, msgs[start:])
+	}
+	return ret
+}
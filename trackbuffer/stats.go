@@ -0,0 +1,124 @@
+package trackbuffer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Stats count the messages going in and the tracks coming out, why tracks were split into
+// separate flights, and what happened to data that arrived out of order or after its track
+// was flushed. Everything is a running total since the buffer was created, apart from Tracks
+// and BufferedMessages, which say how much is being held right now.
+type Stats struct {
+	MessagesIn       int64         // Messages passed to AddMessage
+	MessagesOut      int64         // Messages flushed, as part of a track
+	TracksStarted    int64
+	TracksFlushed    int64
+	SplitsGap        int64         // Tracks split into flights, because of a gap in the data
+	SplitsCallsign   int64         // ... because of a callsign change
+	SplitsTakeoff    int64         // ... because the aircraft took off again
+
+	Reordered        int64         // Messages that arrived out of order
+	LateDropped      int64         // Messages for already-flushed tracks, that were discarded
+	LateAmended      int64         // ... that were added to an amendment
+	LateReopened     int64         // Flushed tracks that were reopened by late data
+	AmendmentsFlushed int64
+
+	Flushes          int64         // Flush calls that weren't rate limited
+	FlushLatency     time.Duration // Total time spent handing tracks to the flush channel
+	MaxFlushLatency  time.Duration
+	LastFlush        time.Time
+
+	Tracks           int           // Number of tracks currently being accumulated
+	BufferedMessages int64         // Number of messages in those tracks
+}
+
+func (s Stats)String() string {
+	return fmt.Sprintf("in=%d, out=%d, tracks=%d/%d, flushes=%d, depth=%d/%d",
+		s.MessagesIn, s.MessagesOut, s.TracksStarted, s.TracksFlushed, s.Flushes,
+		s.Tracks, s.BufferedMessages)
+}
+
+func (s *Stats)recordFlush(latency time.Duration) {
+	s.Flushes++
+	s.FlushLatency += latency
+	if latency > s.MaxFlushLatency { s.MaxFlushLatency = latency }
+	s.LastFlush = time.Now()
+}
+
+func (s *Stats)recordSplit(reason string) {
+	switch reason {
+	case SplitGap:      s.SplitsGap++
+	case SplitCallsign: s.SplitsCallsign++
+	case SplitTakeoff:  s.SplitsTakeoff++
+	}
+}
+
+// publishStats takes a snapshot of the stats, so that other goroutines can safely read them.
+// It runs for every message, so it must not walk the tracks.
+func (tb *TrackBuffer)publishStats() {
+	s := tb.stats
+	s.Tracks = len(tb.Tracks)
+	s.BufferedMessages = tb.numBuffered
+
+	tb.statsMu.Lock()
+	tb.published = s
+	tb.statsMu.Unlock()
+}
+
+// Stats returns the figures as they were at the end of the last AddMessage or Flush. It only
+// reads a copy, so a metrics handler can call it while another goroutine feeds the buffer.
+func (tb *TrackBuffer)Stats() Stats {
+	tb.statsMu.Lock()
+	defer tb.statsMu.Unlock()
+	return tb.published
+}
+
+// WritePrometheus writes the stats in the Prometheus text exposition format.
+func (s Stats)WritePrometheus(w io.Writer) {
+	metric := func(name, kind, help string, vals ...string) {
+		fmt.Fprintf(w, "# HELP trackbuffer_%s %s\n# TYPE trackbuffer_%s %s\n", name, help, name, kind)
+		for _,v := range vals {
+			fmt.Fprintf(w, "trackbuffer_%s%s\n", name, v)
+		}
+	}
+	i := func(n int64) string { return fmt.Sprintf(" %d", n) }
+
+	metric("messages_in_total", "counter", "Messages passed to AddMessage.", i(s.MessagesIn))
+	metric("messages_out_total", "counter", "Messages flushed as part of a track.", i(s.MessagesOut))
+	metric("tracks_started_total", "counter", "Tracks started.", i(s.TracksStarted))
+	metric("tracks_flushed_total", "counter", "Tracks flushed.", i(s.TracksFlushed))
+	metric("flight_splits_total", "counter", "Tracks split into separate flights, by reason.",
+		fmt.Sprintf(`{reason="gap"} %d`, s.SplitsGap),
+		fmt.Sprintf(`{reason="callsign"} %d`, s.SplitsCallsign),
+		fmt.Sprintf(`{reason="takeoff"} %d`, s.SplitsTakeoff))
+	metric("reordered_total", "counter", "Messages that arrived out of order.", i(s.Reordered))
+	metric("late_messages_total", "counter", "Messages for already-flushed tracks, by outcome.",
+		fmt.Sprintf(`{outcome="dropped"} %d`, s.LateDropped),
+		fmt.Sprintf(`{outcome="amended"} %d`, s.LateAmended))
+	metric("late_reopened_total", "counter", "Flushed tracks reopened by late data.",
+		i(s.LateReopened))
+	metric("amendments_flushed_total", "counter", "Amendment tracks flushed.",
+		i(s.AmendmentsFlushed))
+	metric("flush_latency_seconds", "summary", "Time spent handing off tracks.",
+		fmt.Sprintf("_sum %f", s.FlushLatency.Seconds()),
+		fmt.Sprintf("_count %d", s.Flushes))
+	metric("flush_latency_max_seconds", "gauge", "Longest time spent in a flush.",
+		fmt.Sprintf(" %f", s.MaxFlushLatency.Seconds()))
+	if !s.LastFlush.IsZero() {
+		metric("last_flush_timestamp_seconds", "gauge", "Unix time of the most recent flush.",
+			fmt.Sprintf(" %d", s.LastFlush.Unix()))
+	}
+	metric("tracks", "gauge", "Tracks currently being accumulated.", i(int64(s.Tracks)))
+	metric("buffered_messages", "gauge", "Messages in tracks being accumulated.",
+		i(s.BufferedMessages))
+}
+
+// ServeMetrics is an http.HandlerFunc that exports the stats for Prometheus, e.g.
+//   http.HandleFunc("/metrics", tb.ServeMetrics)
+func (tb *TrackBuffer)ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	tb.Stats().WritePrometheus(w)
+}
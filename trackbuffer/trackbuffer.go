@@ -1,29 +1,58 @@
 // TrackBuffer accumulates ADSB messages, grouped by aircraft, and flushes out
-// bundles of them.
+// bundles of them. The SegmentRules split an aircraft's messages into separate
+// flights, so that each bundle covers just one flight. Messages are kept in time
+// order; data that turns up after its track was flushed is handled as per the
+// LatePolicy.
 package trackbuffer
 
 import (
-	"sort"
+	"sync"
 	"time"
 	"github.com/skypies/adsb"
+	"github.com/skypies/geo"
 )
 
-// A slice of ADSB messages that share the same IcaoId
+// A slice of ADSB messages that share the same IcaoId, from a single flight
 type Track struct {
 	Messages  []*adsb.CompositeMsg
 }
 
 type TrackBuffer struct {
 	MaxAge      time.Duration // Flush any track with data older than this
+	Segment     SegmentRules
+	ReorderWindow time.Duration // Hold back messages this recent, in case older ones arrive
+	Late        LatePolicy    // What to do with messages for tracks already flushed
+	LateMemory  time.Duration // How long to remember flushed tracks, to spot late data
+	AmendChan   chan<- []*adsb.CompositeMsg // Where amendments go; if nil, the flush channel
 	Tracks      map[adsb.IcaoId]*Track
+	Completed   []*Track      // Tracks for flights that have ended, waiting to be flushed
 	lastFlush   time.Time
+
+	flushed     map[adsb.IcaoId]*flushedTrack // Recently flushed tracks
+	amendments  map[adsb.IcaoId]*Track        // Late data, waiting to be flushed as amendments
+	numBuffered int64                         // Running total of Size(), for the stats
+
+	stats       Stats      // Live counters
+	published   Stats      // Snapshot of stats, for other goroutines
+	statsMu     sync.Mutex // Protects published
 }
 
 func NewTrackBuffer() *TrackBuffer {
 	tb := TrackBuffer{
 		MaxAge: time.Second*30,
+		Segment: SegmentRules{
+			MaxGap: time.Minute*10,
+			OnCallsignChange: true,
+			OnGroundTransition: true,
+			MinGroundTime: time.Second*30,
+		},
+		ReorderWindow: time.Second*5,
+		Late: LateAmend,
+		LateMemory: time.Minute*5,
 		Tracks: make(map[adsb.IcaoId]*Track),
 		lastFlush: time.Now(),
+		flushed: make(map[adsb.IcaoId]*flushedTrack),
+		amendments: make(map[adsb.IcaoId]*Track),
 	}
 	return &tb
 }
@@ -33,36 +62,78 @@ func (t *Track)Age() time.Duration {
 	return time.Since(t.Messages[0].GeneratedTimestampUTC)
 }
 
+// ClosestApproach works out when the track came closest to the location; see
+// adsb.FindClosestApproach.
+func (t *Track)ClosestApproach(pos geo.Latlong, elevationFt float64) (adsb.ClosestApproach, bool) {
+	return adsb.FindClosestApproach(t.Messages, pos, elevationFt)
+}
+
 func (tb *TrackBuffer)AddTrack(icao adsb.IcaoId) {
 	track := Track{
 		Messages: []*adsb.CompositeMsg{},
 	}
 	tb.Tracks[icao] = &track
+	tb.stats.TracksStarted++
 }
 
+// RemoveTracks takes the open tracks for the aircraft out of the buffer, unflushed, and hands
+// them to the caller; e.g. to write out everything at shutdown. Completed flights waiting to
+// be flushed are left alone, and later messages for the aircraft just start a new track.
 func (tb *TrackBuffer)RemoveTracks(icaos []adsb.IcaoId) []*Track{
 	removed := []*Track{}
 	for _,icao := range icaos {
+		if t,exists := tb.Tracks[icao]; exists { tb.numBuffered -= int64(len(t.Messages)) }
 		removed = append(removed, tb.Tracks[icao])
 		delete(tb.Tracks, icao)
 	}
+	tb.publishStats()
 	return removed
 }
 
+// Size counts the messages currently held, in tracks, completed tracks and amendments.
 func (tb *TrackBuffer)Size() int64 {
 	i := 0
 	for _,t := range tb.Tracks {
 		i += len(t.Messages)
 	}
+	for _,t := range tb.Completed {
+		i += len(t.Messages)
+	}
+	for _,t := range tb.amendments {
+		i += len(t.Messages)
+	}
 	return int64(i)
 }
 
 func (tb *TrackBuffer)AddMessage(m *adsb.CompositeMsg) {
+	defer tb.publishStats()
+	tb.stats.MessagesIn++
+
+	if tb.handleLate(m) {
+		return
+	}
+
 	if _,exists := tb.Tracks[m.Icao24]; exists == false {
 		tb.AddTrack(m.Icao24)
 	}
 	track := tb.Tracks[m.Icao24]
-	track.Messages = append(track.Messages, m)
+
+	if n := len(track.Messages); n > 0 && !m.GeneratedTimestampUTC.Before(track.Messages[n-1].GeneratedTimestampUTC) {
+		if split,reason := tb.Segment.IsNewFlight(track.Messages, m); split {
+			tb.Completed = append(tb.Completed, track)
+			tb.AddTrack(m.Icao24)
+			track = tb.Tracks[m.Icao24]
+			tb.stats.recordSplit(reason)
+		}
+	}
+
+	tb.numBuffered++
+	if !track.insert(m) {
+		// It didn't go on the end, so the split check above didn't apply; it (or data reopened
+		// by handleLate) may even belong to an earlier flight.
+		tb.stats.Reordered++
+		tb.resegment(m.Icao24)
+	}
 }
 
 // Flushing should be automatic and internal, not explicit like this.
@@ -77,16 +148,35 @@ func (tb *TrackBuffer)Flush(flushChan chan<- []*adsb.CompositeMsg) {
 		tb.lastFlush = time.Now()
 	}
 
-	toRemove := []adsb.IcaoId{}
-	
-	for id,_ := range tb.Tracks {
-		if tb.Tracks[id].Age() > tb.MaxAge {
-			toRemove = append(toRemove, id)
+	// Messages inside the reorder window are held back, as older data might still turn up.
+	cutoff := time.Now().Add(-1 * tb.ReorderWindow)
+
+	flushing := tb.Completed
+	tb.Completed = nil
+	for id,t := range tb.Tracks {
+		if t.Age() > tb.MaxAge {
+			if ready := t.takeBefore(cutoff); len(ready.Messages) > 0 {
+				flushing = append(flushing, ready)
+			}
+			if len(t.Messages) == 0 {
+				delete(tb.Tracks, id)
+			}
 		}
 	}
 
-	for _,t := range tb.RemoveTracks(toRemove) {
-		sort.Sort(adsb.CompositeMsgPtrByTimeAsc(t.Messages))
+	tb.publishStats() // In case we block for a while
+	start := time.Now()
+
+	for _,t := range flushing {
+		tb.rememberFlushed(t)
 		flushChan <- t.Messages
+		tb.stats.TracksFlushed++
+		tb.stats.MessagesOut += int64(len(t.Messages))
+		tb.numBuffered -= int64(len(t.Messages))
 	}
+	tb.flushAmendments(flushChan)
+	tb.forgetFlushed()
+
+	tb.stats.recordFlush(time.Since(start))
+	tb.publishStats()
 }
@@ -0,0 +1,349 @@
+// go test -v github.com/skypies/adsb/trackbuffer
+package trackbuffer
+
+import (
+	"bufio"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/adsb/simulator"
+	"github.com/skypies/geo"
+)
+
+var baseTime = time.Now().Add(-time.Hour)
+
+// makeMsg builds a composite message, offset seconds after a fixed base time
+func makeMsg(icao adsb.IcaoId, callsign string, offset int, onGround bool) *adsb.CompositeMsg {
+	cm := adsb.CompositeMsg{}
+	cm.Type, cm.SubType = "MSG", 3
+	cm.Icao24 = icao
+	cm.Callsign = callsign
+	cm.IsOnGround = onGround
+	cm.GeneratedTimestampUTC = baseTime.Add(time.Duration(offset) * time.Second)
+	return &cm
+}
+
+func TestSegmentation(t *testing.T) {
+	tb := NewTrackBuffer()
+	tb.MaxAge = time.Hour * 24
+
+	tests := []struct{
+		cm *adsb.CompositeMsg
+		expectedCompleted int
+	}{
+		{makeMsg("A81BD0", "VRD961",   0, false), 0},
+		{makeMsg("A81BD0", "VRD961",  10, false), 0},
+		{makeMsg("A81BD0", "VRD961",  20, true),  0}, // Landed
+		{makeMsg("A81BD0", "VRD961", 300, true),  0}, // Taxiing in
+		{makeMsg("A81BD0", "VRD962", 900, true),  1}, // New callsign
+		{makeMsg("A81BD0", "VRD962", 940, true),  1}, // Lined up
+		{makeMsg("A81BD0", "VRD962", 950, false), 2}, // Took off
+		{makeMsg("A81BD0", "VRD962", 960, true),  2}, // Glitch in the ground flag ...
+		{makeMsg("A81BD0", "VRD962", 970, false), 2}, // ... isn't a landing
+		{makeMsg("A81BD0", "VRD962",2000, false), 3}, // Gap
+		{makeMsg("ABEEF0", "UAL1",  2010, false), 3}, // Different aircraft
+	}
+
+	for i,test := range tests {
+		tb.AddMessage(test.cm)
+		if len(tb.Completed) != test.expectedCompleted {
+			t.Errorf("[%d] expected %d completed, saw %d", i, test.expectedCompleted, len(tb.Completed))
+		}
+	}
+
+	if n := len(tb.Completed[0].Messages); n != 4 { t.Errorf("first flight had %d msgs", n) }
+
+	ch := make(chan []*adsb.CompositeMsg, 10)
+	tb.lastFlush = time.Time{}
+	tb.Flush(ch)
+	if len(ch) != 3 { t.Errorf("expected 3 flights flushed, saw %d", len(ch)) }
+
+	s := tb.Stats()
+	if s.SplitsGap != 1 || s.SplitsCallsign != 1 || s.SplitsTakeoff != 1 {
+		t.Errorf("split stats wrong: %d/%d/%d", s.SplitsGap, s.SplitsCallsign, s.SplitsTakeoff)
+	}
+}
+
+// The ground flag comes from the SBS feed, so make sure a takeoff in real data splits
+func TestSegmentationFromSBS(t *testing.T) {
+	lines := []string{
+		"MSG,3,1,1,A81BD0,1,2015/11/27,21:31:00.000,2015/11/27,21:31:00.000,,0,,,37.61880,-122.37540,,,,,,-1",
+		"MSG,3,1,1,A81BD0,1,2015/11/27,21:31:10.000,2015/11/27,21:31:10.000,,0,,,37.61500,-122.37000,,,,,,-1",
+		"MSG,3,1,1,A81BD0,1,2015/11/27,21:31:20.000,2015/11/27,21:31:20.000,,300,,,37.61000,-122.36500,,,,,,0",
+	}
+	msgs := []*adsb.CompositeMsg{}
+	for _,line := range lines {
+		cm := adsb.CompositeMsg{}
+		if err := cm.FromSBS1(line); err != nil { t.Fatal(err) }
+		msgs = append(msgs, &cm)
+	}
+
+	r := SegmentRules{OnGroundTransition: true, MinGroundTime: time.Second * 10}
+	if flights := r.Split(msgs); len(flights) != 2 || len(flights[1]) != 1 {
+		t.Errorf("takeoff did not split: %v", flights)
+	}
+	r.MinGroundTime = time.Second * 30
+	if flights := r.Split(msgs); len(flights) != 1 {
+		t.Errorf("split, despite only 10s on the ground: %v", flights)
+	}
+}
+
+func TestSegmentRulesSplit(t *testing.T) {
+	r := SegmentRules{MaxGap: time.Minute}
+	msgs := []*adsb.CompositeMsg{
+		makeMsg("A81BD0", "", 0, false),
+		makeMsg("A81BD0", adsb.NilCallsign, 10, false),
+		makeMsg("A81BD0", "VRD961", 20, false),
+		makeMsg("A81BD0", "VRD961", 200, false),
+	}
+	if flights := r.Split(msgs); len(flights) != 2 || len(flights[0]) != 3 {
+		t.Errorf("bad split: %v", flights)
+	}
+	r.OnCallsignChange = true
+	if flights := r.Split(msgs); len(flights) != 2 {
+		t.Errorf("nil callsign caused a split: %v", flights)
+	}
+}
+
+func TestReordering(t *testing.T) {
+	tb := NewTrackBuffer()
+	now := time.Now()
+	for _,offset := range []int{-60, -40, -50, -2, -45} {
+		cm := makeMsg("A81BD0", "VRD961", 0, false)
+		cm.GeneratedTimestampUTC = now.Add(time.Duration(offset) * time.Second)
+		tb.AddMessage(cm)
+	}
+	if s := tb.Stats(); s.Reordered != 2 { t.Errorf("expected 2 reorders, saw %d", s.Reordered) }
+
+	ch := make(chan []*adsb.CompositeMsg, 10)
+	tb.lastFlush = time.Time{}
+	tb.Flush(ch)
+	if len(ch) != 1 { t.Fatalf("expected 1 flushed track, saw %d", len(ch)) }
+	msgs := <-ch
+	if len(msgs) != 4 { t.Errorf("reorder window did not hold back recent msg (%d)", len(msgs)) }
+	for i:=1; i<len(msgs); i++ {
+		if msgs[i].GeneratedTimestampUTC.Before(msgs[i-1].GeneratedTimestampUTC) {
+			t.Errorf("flushed msgs out of order")
+		}
+	}
+	if tb.Size() != 1 { t.Errorf("held msg not retained") }
+}
+
+func TestLatePolicies(t *testing.T) {
+	now := time.Now()
+	msgAt := func(offset int) *adsb.CompositeMsg {
+		cm := makeMsg("A81BD0", "VRD961", 0, false)
+		cm.GeneratedTimestampUTC = now.Add(time.Duration(offset) * time.Second)
+		return cm
+	}
+
+	for _,policy := range []LatePolicy{LateAmend, LateDrop, LateReopen} {
+		ch := make(chan []*adsb.CompositeMsg, 10)
+		tb := NewTrackBuffer()
+		tb.Late = policy
+		tb.AddMessage(msgAt(-60))
+		tb.AddMessage(msgAt(-40))
+		tb.lastFlush = time.Time{}
+		tb.Flush(ch)
+		if len(ch) != 1 { t.Fatalf("[%s] track not flushed", policy) }
+		<-ch
+
+		tb.AddMessage(msgAt(-50)) // Late
+		if n := tb.Stats().BufferedMessages; n != tb.Size() {
+			t.Errorf("[%s] stats say %d buffered, but holding %d", policy, n, tb.Size())
+		}
+		tb.lastFlush = time.Time{}
+		tb.Flush(ch)
+		s := tb.Stats()
+		if s.BufferedMessages != tb.Size() {
+			t.Errorf("[%s] after flush, stats say %d buffered, but holding %d", policy, s.BufferedMessages, tb.Size())
+		}
+
+		switch policy {
+		case LateAmend:
+			if s.LateAmended != 1 || len(ch) != 1 || len(<-ch) != 1 {
+				t.Errorf("[%s] late msg not amended", policy)
+			}
+		case LateDrop:
+			if s.LateDropped != 1 || len(ch) != 0 || tb.Size() != 0 {
+				t.Errorf("[%s] late msg not dropped", policy)
+			}
+		case LateReopen:
+			if s.LateReopened != 1 || len(ch) != 1 || len(<-ch) != 3 {
+				t.Errorf("[%s] track not reopened", policy)
+			}
+		}
+	}
+}
+
+// Data inserted out of order must still end up in the right flight
+func TestLateSegmentation(t *testing.T) {
+	now := time.Now()
+	msgAt := func(callsign string, offset int) *adsb.CompositeMsg {
+		cm := makeMsg("A81BD0", callsign, 0, false)
+		cm.GeneratedTimestampUTC = now.Add(time.Duration(offset) * time.Second)
+		return cm
+	}
+
+	// Reordered, within the buffer
+	tb := NewTrackBuffer()
+	tb.AddMessage(msgAt("VRD961", -60))
+	tb.AddMessage(msgAt("VRD961", -40))
+	tb.AddMessage(msgAt("VRD962", -20)) // New flight
+	tb.AddMessage(msgAt("VRD961", -30)) // Belongs to the first one
+	if len(tb.Completed) != 1 || len(tb.Completed[0].Messages) != 3 || len(tb.Tracks["A81BD0"].Messages) != 1 {
+		t.Errorf("reordered msg went into the wrong flight")
+	}
+
+	// Reopened, after the first flight was flushed
+	ch := make(chan []*adsb.CompositeMsg, 10)
+	tb = NewTrackBuffer()
+	tb.Late = LateReopen
+	tb.AddMessage(msgAt("VRD961", -60))
+	tb.AddMessage(msgAt("VRD961", -40))
+	tb.lastFlush = time.Time{}
+	tb.Flush(ch)
+	<-ch
+	tb.AddMessage(msgAt("VRD962", -2))
+	tb.AddMessage(msgAt("VRD961", -50)) // Late
+	if len(tb.Completed) != 1 || len(tb.Completed[0].Messages) != 3 {
+		t.Errorf("reopened flight was not split from the current one")
+	}
+	if open := tb.Tracks["A81BD0"].Messages; len(open) != 1 || open[0].Callsign != "VRD962" {
+		t.Errorf("current flight has %d msgs", len(open))
+	}
+	if n := tb.Stats().BufferedMessages; n != tb.Size() || n != 4 {
+		t.Errorf("stats say %d buffered, but holding %d", n, tb.Size())
+	}
+}
+
+// A long flight, flushed piece by piece, shouldn't be remembered (or reopened) in full
+func TestReopenMemory(t *testing.T) {
+	now := time.Now()
+	tb := NewTrackBuffer()
+	tb.Late = LateReopen
+	tb.LateMemory = time.Minute
+
+	for i:=0; i<60; i++ { // An hour of data, flushed a minute at a time
+		track := &Track{}
+		for j:=0; j<6; j++ {
+			cm := makeMsg("A81BD0", "VRD961", 0, false)
+			cm.GeneratedTimestampUTC = now.Add(time.Duration(i*60 + j*10 - 3600) * time.Second)
+			track.Messages = append(track.Messages, cm)
+		}
+		tb.rememberFlushed(track)
+	}
+	if n := len(tb.flushed["A81BD0"].Messages); n > 7 {
+		t.Errorf("remembered %d messages, more than a LateMemory's worth", n)
+	}
+
+	late := makeMsg("A81BD0", "VRD961", 0, false)
+	late.GeneratedTimestampUTC = now.Add(-15 * time.Second)
+	tb.AddMessage(late)
+	if n := tb.Size(); n > 8 {
+		t.Errorf("reopened %d messages", n)
+	}
+}
+
+func trackFromSBS(sbs string) *Track {
+	t := &Track{}
+	scanner := bufio.NewScanner(strings.NewReader(sbs))
+	for scanner.Scan() {
+		m := adsb.Msg{}
+		if err := m.FromSBS1(scanner.Text()); err != nil {
+			panic(err)
+		}
+		t.Messages = append(t.Messages, &adsb.CompositeMsg{Msg:m})
+	}
+	return t
+}
+
+var trackSBS = `MSG,3,1,1,A81BD0,1,2015/11/27,21:31:00.000,2015/11/27,21:31:00.000,,20000,400,350,36.70000,-121.86000,-1000,,,,,0
+MSG,3,1,1,A81BD0,1,2015/11/27,21:31:02.500,2015/11/27,21:31:02.500,,19950,400,10,36.70250,-121.86000,-1000,,,,,0
+MSG,3,1,1,A81BD0,1,2015/11/27,21:31:05.000,2015/11/27,21:31:05.000,,19900,400,10,36.70500,-121.86000,-1000,,,,,0
+MSG,3,1,1,A81BD0,1,2015/11/27,21:31:08.000,2015/11/27,21:31:08.000,,19800,400,10,36.70800,-121.86000,-1000,,,,,0`
+
+func TestResample(t *testing.T) {
+	r := trackFromSBS(trackSBS).Resample(time.Second)
+	if len(r.Messages) != 9 { t.Fatalf("expected 9 points, saw %d", len(r.Messages)) }
+
+	nObserved := 0
+	for _,m := range r.Messages {
+		if !m.IsSynthetic { nObserved++ }
+		if !m.HasPosition() { t.Errorf("resampled point lacks position: %s", m) }
+	}
+	if nObserved != 3 { t.Errorf("expected 3 observed points, saw %d", nObserved) }
+
+	if m := r.Messages[1]; m.Track != 358 || m.Altitude != 19980 {
+		t.Errorf("bad interpolation (wrapped heading ?): %s", m)
+	}
+	if m := r.Messages[6]; math.Abs(m.Position.Lat - 36.706) > 0.00001 {
+		t.Errorf("bad position interpolation: %s", m)
+	}
+
+	// Steps that don't divide evenly are still aligned to the Unix epoch
+	for _,m := range trackFromSBS(trackSBS).Resample(7 * time.Second).Messages {
+		if m.GeneratedTimestampUTC.UnixNano() % int64(7 * time.Second) != 0 {
+			t.Errorf("point not aligned to epoch: %s", m.GeneratedTimestampUTC)
+		}
+	}
+}
+
+func TestSmooth(t *testing.T) {
+	r := trackFromSBS(trackSBS).Resample(time.Second)
+	r.Messages[4].Altitude += 500 // A spike
+	s := r.Smooth(5)
+	if len(s.Messages) != len(r.Messages) { t.Fatalf("smoothing changed length") }
+	if s.Messages[4].Altitude >= r.Messages[4].Altitude - 100 {
+		t.Errorf("spike not smoothed: %d", s.Messages[4].Altitude)
+	}
+	if s.Messages[0].Altitude != r.Messages[0].Altitude { t.Errorf("edge point was changed") }
+	if r.Messages[4].Altitude != 20420 { t.Errorf("original track was modified") }
+}
+
+// A simulated touch-and-go: it lands, taxis back and takes off again
+func TestSimulatedSegmentation(t *testing.T) {
+	rwy := geo.Latlong{Lat:37.3639, Long:-121.9289}
+	ac := simulator.Aircraft{
+		Icao24: "A81BD0", Callsign: "VRD961", Start: baseTime,
+		Route: []simulator.Waypoint{
+			{Position:rwy.MoveKM(315, 10), AltitudeFt:3000, SpeedKnots:150},
+			{Position:rwy, AltitudeFt:0, SpeedKnots:20},
+			{Position:rwy.MoveKM(315, 1), AltitudeFt:0, SpeedKnots:150},
+			{Position:rwy.MoveKM(135, 1), AltitudeFt:0, SpeedKnots:150},
+			{Position:rwy.MoveKM(135, 10), AltitudeFt:3000},
+		},
+	}
+	sim := simulator.NewSimulator(1)
+	sim.Aircraft = []simulator.Aircraft{ac}
+
+	tb := NewTrackBuffer()
+	n := 0
+	for _,r := range sim.Generate(baseTime, ac.End()) {
+		m,_ := r.Msg()
+		if !m.HasPosition() { continue }
+		m.Callsign = ac.Callsign
+		tb.AddMessage(&adsb.CompositeMsg{Msg:m})
+		n++
+	}
+
+	ch := make(chan []*adsb.CompositeMsg, 10)
+	tb.lastFlush = time.Time{}
+	tb.Flush(ch)
+	if len(ch) != 2 { t.Fatalf("expected 2 flights, saw %d", len(ch)) }
+
+	arrival, departure := <-ch, <-ch
+	if len(arrival) + len(departure) != n { t.Errorf("lost msgs: %d+%d != %d", len(arrival), len(departure), n) }
+	if last := arrival[len(arrival)-1]; !last.IsOnGround {
+		t.Errorf("arrival did not end on the ground")
+	}
+	if first := departure[0]; first.IsOnGround || first.Position.DistKM(rwy) > 2 {
+		t.Errorf("departure did not start with the takeoff: %s", first)
+	}
+	if s := tb.Stats(); s.SplitsTakeoff != 1 {
+		t.Errorf("expected one takeoff split: %s", s)
+	}
+}
@@ -0,0 +1,229 @@
+package wind
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/geo"
+)
+
+// {{{ Cell{}
+
+// Cell is the average wind over one cell of the field.
+type Cell struct {
+	Box            geo.LatlongBox
+	AltitudeBand   int64   // The bottom of the altitude band, feet
+
+	N              int     // How many estimates were averaged
+	U,V            float64 // Mean wind velocity, east & north, knots
+	Temperature    float64 // Mean temperature, Celsius
+	NTemperature   int     // How many estimates had temperatures
+}
+
+func (c Cell)Speed() float64     { return math.Hypot(c.U, c.V) }
+func (c Cell)Direction() float64 { return fromDirection(c.U, c.V) }
+func (c Cell)Center() geo.Latlong { return c.Box.Center() }
+
+func (c Cell)String() string {
+	s := fmt.Sprintf("%s %5df+: %03.0f@%.0fkt (n=%d)", c.Center(), c.AltitudeBand, c.Direction(),
+		c.Speed(), c.N)
+	if c.NTemperature > 0 { s += fmt.Sprintf(", %.0fC (n=%d)", c.Temperature, c.NTemperature) }
+	return s
+}
+
+// }}}
+// {{{ Field{}
+
+type cellKey struct {
+	lat,long,band int64
+}
+
+// Field accumulates estimates into a grid. It is safe for concurrent use.
+type Field struct {
+	Estimator   Estimator
+	CellDeg     float64       // Size of the cells, degrees
+	BandFt      int64         // Depth of the altitude bands, feet
+	Window      time.Duration // Only use estimates from this far back
+
+	cells       map[cellKey][]Estimate // Each in time order
+	newest      time.Time
+	mu          sync.Mutex
+}
+
+// NewField returns a field with cells of 0.25 degrees by 2000 feet, averaged over the last
+// 30 minutes.
+func NewField() *Field {
+	return &Field{
+		Estimator: NewEstimator(),
+		CellDeg:   0.25,
+		BandFt:    2000,
+		Window:    time.Minute * 30,
+		cells:     map[cellKey][]Estimate{},
+	}
+}
+
+// }}}
+
+// {{{ Field.key
+
+func (f *Field)key(pos geo.Latlong, alt int64) cellKey {
+	band := int64(math.Floor(float64(alt) / float64(f.BandFt)))
+	return cellKey{
+		int64(math.Floor(pos.Lat / f.CellDeg)),
+		int64(math.Floor(pos.Long / f.CellDeg)),
+		band,
+	}
+}
+
+// }}}
+// {{{ Field.prune
+
+// prune drops estimates older than the window (relative to the newest one).
+func (f *Field)prune() {
+	cutoff := f.newest.Add(-1 * f.Window)
+	for k,ests := range f.cells {
+		i := sort.Search(len(ests), func(i int) bool { return !ests[i].Time.Before(cutoff) })
+		if i == len(ests) {
+			delete(f.cells, k)
+		} else if i > 0 {
+			f.cells[k] = append([]Estimate{}, ests[i:]...)
+		}
+	}
+}
+
+// }}}
+// {{{ Field.Add
+
+// Add estimates the wind from the message, and adds it to the field; it returns false if the
+// message couldn't be used.
+func (f *Field)Add(cm *adsb.CompositeMsg) bool {
+	est,ok := f.Estimator.Estimate(cm)
+	if !ok {
+		return false
+	}
+	f.AddEstimate(est)
+	return true
+}
+
+func (f *Field)AddEstimate(est Estimate) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.cells == nil { f.cells = map[cellKey][]Estimate{} }
+
+	k := f.key(est.Position, est.Altitude)
+	ests := append(f.cells[k], est)
+	for i:=len(ests)-1; i>0 && ests[i].Time.Before(ests[i-1].Time); i-- {
+		ests[i],ests[i-1] = ests[i-1],ests[i] // Keep them in time order
+	}
+	f.cells[k] = ests
+
+	if est.Time.After(f.newest) {
+		f.newest = est.Time
+		f.prune()
+	}
+}
+
+// }}}
+// {{{ Field.cell
+
+// cell averages the estimates in the window ending at t; it returns false if there are none.
+func (f *Field)cell(k cellKey, t time.Time) (Cell, bool) {
+	c := Cell{
+		Box: geo.LatlongBox{
+			SW: geo.Latlong{Lat:float64(k.lat)*f.CellDeg, Long:float64(k.long)*f.CellDeg},
+			NE: geo.Latlong{Lat:float64(k.lat+1)*f.CellDeg, Long:float64(k.long+1)*f.CellDeg},
+		},
+		AltitudeBand: k.band * f.BandFt,
+	}
+
+	tempSum := 0.0
+	for _,est := range f.cells[k] {
+		if est.Time.After(t) || t.Sub(est.Time) > f.Window { continue }
+		c.N++
+		c.U += est.U
+		c.V += est.V
+		if est.HasTemperature {
+			c.NTemperature++
+			tempSum += est.Temperature
+		}
+	}
+
+	if c.N == 0 {
+		return c, false
+	}
+	c.U /= float64(c.N)
+	c.V /= float64(c.N)
+	if c.NTemperature > 0 {
+		c.Temperature = tempSum / float64(c.NTemperature)
+	}
+	return c, true
+}
+
+// }}}
+// {{{ Field.Cells
+
+// Cells returns the average wind in each cell that has estimates from the window ending at
+// t, sorted by altitude band, then location.
+func (f *Field)Cells(t time.Time) []Cell {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := []cellKey{}
+	for k,_ := range f.cells {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i,j int) bool {
+		if keys[i].band != keys[j].band { return keys[i].band < keys[j].band }
+		if keys[i].lat != keys[j].lat { return keys[i].lat < keys[j].lat }
+		return keys[i].long < keys[j].long
+	})
+
+	ret := []Cell{}
+	for _,k := range keys {
+		if c,ok := f.cell(k, t); ok {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// }}}
+// {{{ Field.Lookup
+
+// Lookup returns the average wind in the cell containing the position & altitude.
+func (f *Field)Lookup(pos geo.Latlong, alt int64, t time.Time) (Cell, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cell(f.key(pos, alt), t)
+}
+
+// }}}
+// {{{ Field.Run
+
+// Run adds each message read from the input channel to the field, and passes them on
+// unchanged to the output channel (if not nil), which is closed when the input channel is
+// closed.
+func (f *Field)Run(in <-chan []*adsb.CompositeMsg, out chan<- []*adsb.CompositeMsg) {
+	for msgs := range in {
+		for _,cm := range msgs {
+			f.Add(cm)
+		}
+		if out != nil { out <- msgs }
+	}
+	if out != nil { close(out) }
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
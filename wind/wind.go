@@ -0,0 +1,162 @@
+/* Package wind estimates the wind (and air temperature) from what
+aircraft report about themselves.
+
+An aircraft's ground vector (ground speed & track) is the sum of its
+air vector (true airspeed & heading) and the wind; so when we have
+both, the wind is the difference. The heading and airspeed come from
+Mode S Enhanced Surveillance (Comm-B) replies, or ADS-B velocity
+messages. If the aircraft reports both its Mach number and true
+airspeed, we get the speed of sound, and hence the temperature.
+
+Point estimates are noisy, so a Field aggregates them into a grid of
+cells, by altitude band, over a sliding time window.
+
+Sample usage:
+
+    f := wind.NewField()
+    f.Estimator.Declination = 13.5 // Magnetic variation around SFO
+    go f.Run(flushChan, outChan)
+    ...
+    for _,c := range f.Cells(time.Now()) { fmt.Printf("%s\n", c) }
+
+*/
+package wind
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/geo"
+)
+
+const (
+	// The speed of sound, in knots, is this times the square root of the temperature in
+	// Kelvin.
+	speedOfSoundCoeff = 38.967854
+	zeroCelsius       = 273.15
+)
+
+// {{{ Estimate{}
+
+// Estimate is the wind (and maybe temperature) at one point.
+type Estimate struct {
+	Msg            *adsb.CompositeMsg
+	Time           time.Time
+	Position       geo.Latlong
+	Altitude       int64   // Pressure altitude, feet
+
+	U,V            float64 // Wind velocity (the direction it blows towards), east & north, knots
+	Temperature    float64 // Celsius
+	HasTemperature bool
+}
+
+// Speed returns the wind speed, in knots.
+func (e Estimate)Speed() float64 { return math.Hypot(e.U, e.V) }
+
+// Direction returns the direction the wind is blowing from, in degrees true, as per weather
+// reports.
+func (e Estimate)Direction() float64 { return fromDirection(e.U, e.V) }
+
+func (e Estimate)String() string {
+	s := fmt.Sprintf("%s %5df: %03.0f@%.0fkt", e.Position, e.Altitude, e.Direction(), e.Speed())
+	if e.HasTemperature { s += fmt.Sprintf(", %.0fC", e.Temperature) }
+	return s
+}
+
+func fromDirection(u, v float64) float64 {
+	return math.Mod(math.Atan2(-u, -v) * 180 / math.Pi + 360, 360)
+}
+
+// components splits a speed & direction (towards) into east & north components.
+func components(speed, deg float64) (float64, float64) {
+	rad := deg * math.Pi / 180
+	return speed * math.Sin(rad), speed * math.Cos(rad)
+}
+
+// }}}
+// {{{ Estimator{}
+
+// Estimator decides which messages give usable estimates.
+type Estimator struct {
+	Declination   float64       // Degrees east; added to magnetic headings to get true headings
+	MaxRoll       float64       // Skip aircraft banked more steeply than this, as heading lags in turns
+	MinAirspeed   int64         // Skip slow aircraft (e.g. on the ground), knots
+	MaxWindSpeed  float64       // Skip implausible results, knots
+	MaxAirDataAge time.Duration // Skip air data (heading, airspeed) older than this, relative to the message
+}
+
+func NewEstimator() Estimator {
+	return Estimator{
+		MaxRoll:       5,
+		MinAirspeed:   100,
+		MaxWindSpeed:  250,
+		MaxAirDataAge: 4 * time.Second,
+	}
+}
+
+// isFresh checks that a field, which may have been backfilled from an earlier message (e.g.
+// by msgbuffer), was reported close enough to the message's time. Fields with no recorded
+// time came with the message.
+func (e Estimator)isFresh(cm *adsb.CompositeMsg, reported time.Time) bool {
+	if reported.IsZero() || e.MaxAirDataAge <= 0 { return true }
+	age := cm.GeneratedTimestampUTC.Sub(reported)
+	if age < 0 { age = -age }
+	return age <= e.MaxAirDataAge
+}
+
+// }}}
+// {{{ Estimator.Estimate
+
+// Estimate works out the wind at the message's position, if the message has everything
+// needed. It needs a position, ground speed & track, true airspeed and magnetic heading. The
+// air data must be recent; if it is stale (the aircraft may have turned since), the
+// difference from the ground vector isn't the wind.
+func (e Estimator)Estimate(cm *adsb.CompositeMsg) (Estimate, bool) {
+	if !cm.HasPosition() || cm.IsOnGround || !cm.HasTrueAirspeed() || !cm.HasMagneticHeading() {
+		return Estimate{}, false
+	}
+	if !e.isFresh(cm, cm.TrueAirspeedTime()) || !e.isFresh(cm, cm.MagneticHeadingTime()) {
+		return Estimate{}, false
+	}
+	if cm.GroundSpeed == 0 || cm.TrueAirspeed < e.MinAirspeed {
+		return Estimate{}, false
+	}
+	if cm.HasRollAngle() && math.Abs(cm.RollAngle) > e.MaxRoll {
+		return Estimate{}, false
+	}
+
+	gx,gy := components(float64(cm.GroundSpeed), float64(cm.Track))
+	ax,ay := components(float64(cm.TrueAirspeed), cm.MagneticHeading + e.Declination)
+
+	est := Estimate{
+		Msg:      cm,
+		Time:     cm.GeneratedTimestampUTC,
+		Position: cm.Position,
+		Altitude: cm.Altitude,
+		U:        gx - ax,
+		V:        gy - ay,
+	}
+	if e.MaxWindSpeed > 0 && est.Speed() > e.MaxWindSpeed {
+		return Estimate{}, false
+	}
+
+	if cm.HasMach() && cm.Mach > 0.1 && e.isFresh(cm, cm.MachTime()) {
+		sqrtK := float64(cm.TrueAirspeed) / (cm.Mach * speedOfSoundCoeff)
+		est.Temperature = sqrtK*sqrtK - zeroCelsius
+		est.HasTemperature = est.Temperature > -100 && est.Temperature < 60
+	}
+
+	return est, true
+}
+
+// }}}
+
+// {{{ -------------------------={ E N D }=----------------------------------
+
+// Local variables:
+// folded-file: t
+// end:
+
+// }}}
@@ -0,0 +1,152 @@
+package wind
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/skypies/adsb"
+	"github.com/skypies/geo"
+)
+
+var baseTime = time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// makeMsg builds a message at (lat,long), with the ground vector (gs,trk) and air vector (tas,hdg).
+func makeMsg(t time.Time, lat, long float64, alt, gs, trk, tas int64, hdg float64) *adsb.CompositeMsg {
+	sbs := fmt.Sprintf("MSG,3,1,1,A81BD0,1,2016/03/01,12:00:00.000,2016/03/01,12:00:00.000,,%d,%d,%d,%.5f,%.5f,,,,,,0",
+		alt, gs, trk, lat, long)
+	m := adsb.Msg{}
+	if err := m.FromSBS1(sbs); err != nil {
+		panic(err)
+	}
+	m.GeneratedTimestampUTC = t
+	m.SetTrueAirspeed(tas)
+	m.SetMagneticHeading(hdg)
+	return &adsb.CompositeMsg{Msg:m}
+}
+
+func TestEstimate(t *testing.T) {
+	e := NewEstimator()
+
+	tests := []struct {
+		GS,Trk,TAS  int64
+		Hdg         float64
+		Declination float64
+		Speed,Dir   float64
+	}{
+		{350, 90,  400, 90,  0,  50, 90},  // Headwind, from the east
+		{450, 90,  400, 90,  0,  50, 270}, // Tailwind, from the west
+		{400, 5,   400, 355, 10, 0,  0},   // Declination turns a crosswind into nothing
+		{283, 45,  200, 0,   0,  200, 270},
+	}
+
+	for i,test := range tests {
+		e.Declination = test.Declination
+		cm := makeMsg(baseTime, 37, -122, 20000, test.GS, test.Trk, test.TAS, test.Hdg)
+		est,ok := e.Estimate(cm)
+		if !ok {
+			t.Errorf("[%d] no estimate", i)
+			continue
+		}
+		if math.Abs(est.Speed() - test.Speed) > 1 {
+			t.Errorf("[%d] speed %.1f, expected %.1f", i, est.Speed(), test.Speed)
+		}
+		if test.Speed > 0 && math.Abs(est.Direction() - test.Dir) > 1 {
+			t.Errorf("[%d] direction %.1f, expected %.1f", i, est.Direction(), test.Dir)
+		}
+		if est.HasTemperature {
+			t.Errorf("[%d] has a temperature, without Mach", i)
+		}
+	}
+
+	e.Declination = 0
+
+	// Temperature, from Mach & TAS
+	cm := makeMsg(baseTime, 37, -122, 30000, 350, 90, 350, 90)
+	cm.SetMach(0.6)
+	if est,ok := e.Estimate(cm); !ok || !est.HasTemperature || math.Abs(est.Temperature - -49.1) > 0.5 {
+		t.Errorf("bad temperature estimate: %s", est)
+	}
+
+	// Unusable messages
+	cm = makeMsg(baseTime, 37, -122, 20000, 350, 90, 400, 90)
+	cm.SetRollAngle(20)
+	if _,ok := e.Estimate(cm); ok { t.Errorf("estimated while banked") }
+
+	cm = makeMsg(baseTime, 37, -122, 20000, 350, 90, 400, 90)
+	cm.EHS = adsb.EHS{}
+	if _,ok := e.Estimate(cm); ok { t.Errorf("estimated without TAS & heading") }
+
+	cm = makeMsg(baseTime, 37, -122, 20000, 350, 270, 400, 90)
+	if _,ok := e.Estimate(cm); ok { t.Errorf("accepted a 750kt wind") }
+}
+
+func TestStaleAirData(t *testing.T) {
+	e := NewEstimator()
+
+	// Air data from a Comm-B reply, backfilled into a later position message
+	air := adsb.EHS{}
+	air.SetTrueAirspeed(400)
+	air.SetMagneticHeading(90)
+	air.SetMach(0.6)
+
+	for _,age := range []time.Duration{0, 2*time.Second, time.Minute} {
+		cm := makeMsg(baseTime.Add(age), 37, -122, 30000, 350, 90, 0, 0)
+		cm.EHS = adsb.EHS{}
+		cm.EHS.UpdateAt(air, baseTime)
+
+		est,ok := e.Estimate(cm)
+		if fresh := age <= e.MaxAirDataAge; ok != fresh {
+			t.Errorf("air data %s old: estimated=%v", age, ok)
+		} else if ok && !est.HasTemperature {
+			t.Errorf("air data %s old: no temperature", age)
+		}
+	}
+
+	// Fresh TAS & heading, but a stale Mach, gives no temperature
+	cm := makeMsg(baseTime.Add(time.Minute), 37, -122, 30000, 350, 90, 400, 90)
+	stale := adsb.EHS{}
+	stale.SetMach(0.6)
+	cm.EHS.UpdateAt(stale, baseTime)
+	if est,ok := e.Estimate(cm); !ok || est.HasTemperature {
+		t.Errorf("bad estimate with stale Mach: %v, %s", ok, est)
+	}
+}
+
+func TestField(t *testing.T) {
+	f := NewField()
+
+	// Two estimates in one cell (a headwind & a crosswind), one higher up, and one too old
+	f.Add(makeMsg(baseTime, 37.1, -122.1, 20100, 350, 90, 400, 90))
+	f.Add(makeMsg(baseTime.Add(time.Minute), 37.2, -122.2, 21900, 400, 90, 400, 80))
+	f.Add(makeMsg(baseTime.Add(time.Minute), 37.1, -122.1, 35000, 400, 90, 400, 90))
+	f.AddEstimate(Estimate{Time:baseTime.Add(-time.Hour), Position:geo.Latlong{Lat:37.1, Long:-122.1},
+		Altitude:20000, U:100})
+
+	cells := f.Cells(baseTime.Add(time.Minute * 2))
+	if len(cells) != 2 {
+		t.Fatalf("got %d cells, expected 2: %v", len(cells), cells)
+	}
+
+	c := cells[0]
+	if c.N != 2 || c.AltitudeBand != 20000 {
+		t.Errorf("bad cell: %s", c)
+	}
+	if !c.Box.Contains(geo.Latlong{Lat:37.1, Long:-122.1}) {
+		t.Errorf("cell in wrong place: %s", c.Box)
+	}
+	// Mean of (-50,0) and (6.1,-69.5)
+	if math.Abs(c.U - -21.95) > 0.1 || math.Abs(c.V - -34.73) > 0.1 {
+		t.Errorf("bad mean wind (%.1f,%.1f)", c.U, c.V)
+	}
+
+	if c,ok := f.Lookup(geo.Latlong{Lat:37.15, Long:-122.15}, 35000, baseTime.Add(time.Minute)); !ok || c.N != 1 || c.Speed() > 1 {
+		t.Errorf("bad lookup: %s, %v", c, ok)
+	}
+
+	// Once the window has passed, nothing is left
+	if cells := f.Cells(baseTime.Add(time.Hour)); len(cells) != 0 {
+		t.Errorf("stale cells: %v", cells)
+	}
+}